@@ -2,24 +2,52 @@ package adminapi
 
 import (
 	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/dex_engine"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
 	"github.com/HydroProtocol/hydro-sdk-backend/common"
 	"github.com/HydroProtocol/hydro-sdk-backend/utils"
 	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
 	"github.com/shopspring/decimal"
 	"math/big"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
 func RestartEngineHandler(e echo.Context) (err error) {
-	restartEngineEvent := common.Event{
-		Type: common.EventRestartEngine,
+	progress := &dex_engine.RestartProgress{
+		RestartID:     uuid.NewV4().String(),
+		Status:        dex_engine.RestartStatusPending,
+		LoadedMarkets: []string{},
+		FailedMarkets: map[string]string{},
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	err = dex_engine.SaveRestartProgress(kvStore, progress)
+	if err != nil {
+		return response(e, nil, err)
+	}
+
+	restartEngineEvent := dex_engine.RestartEngineEvent{
+		Event: common.Event{
+			Type: common.EventRestartEngine,
+		},
+		RestartID: progress.RestartID,
 	}
 
 	err = queueService.Push([]byte(utils.ToJsonString(restartEngineEvent)))
-	return response(e, nil, err)
+	return response(e, progress, err)
+}
+
+func GetRestartEngineHandler(e echo.Context) (err error) {
+	progress, err := dex_engine.LoadRestartProgress(kvStore)
+	if err == nil && progress == nil {
+		err = fmt.Errorf("engine has not been restarted")
+	}
+
+	return response(e, progress, err)
 }
 
 func GetStatusHandler(e echo.Context) (err error) {
@@ -84,13 +112,31 @@ func GetTradesHandler(e echo.Context) (err error) {
 	}
 
 	var trades []*models.Trade
-	var count int64
 	err = e.Bind(&req)
 	if err == nil {
-		count, trades = models.TradeDao.FindAccountMarketTrades(req.Address, req.MarketID, req.Status, req.Offset, req.Limit)
+		if req.Limit <= 0 {
+			req.Limit = 20
+		}
+
+		if req.Offset < 0 {
+			req.Offset = 0
+		}
+
+		// the newest trades up to the end of the page are read, and the ones before the offset dropped
+		trades = models.TradeDao.FindAccountTradesPage(req.Address, &models.TradeQuery{
+			MarketID: req.MarketID,
+			Status:   req.Status,
+			Limit:    req.Offset + req.Limit,
+		})
+
+		if req.Offset < len(trades) {
+			trades = trades[req.Offset:]
+		} else {
+			trades = nil
+		}
 	}
 
-	return response(e, map[string]interface{}{"count": count, "trades": trades}, err)
+	return response(e, map[string]interface{}{"trades": trades}, err)
 }
 
 func GetOrdersHandler(e echo.Context) (err error) {
@@ -140,6 +186,99 @@ func DeleteOrderHandler(e echo.Context) (err error) {
 	return response(e, nil, err)
 }
 
+func ListDeadLettersHandler(e echo.Context) (err error) {
+	var req struct {
+		Status string `json:"status" query:"status"`
+		Offset int    `json:"offset" query:"offset"`
+		Limit  int    `json:"limit"  query:"limit"`
+	}
+
+	var deadLetters []*models.DeadLetterEvent
+	var count int64
+
+	err = e.Bind(&req)
+	if err == nil {
+		if req.Limit <= 0 {
+			req.Limit = 20
+		}
+
+		count, deadLetters = models.DeadLetterEventDao.FindEvents(req.Status, req.Limit, req.Offset)
+	}
+
+	return response(e, map[string]interface{}{"count": count, "deadLetters": deadLetters}, err)
+}
+
+func GetDeadLetterHandler(e echo.Context) (err error) {
+	deadLetter, err := findDeadLetter(e.Param("id"))
+	return response(e, deadLetter, err)
+}
+
+// RetryDeadLetterHandler sends the event of the dead letter to its market again.
+func RetryDeadLetterHandler(e echo.Context) (err error) {
+	deadLetter, err := findDeadLetter(e.Param("id"))
+	if err != nil {
+		return response(e, nil, err)
+	}
+
+	if deadLetter.Status != models.DEAD_LETTER_PENDING {
+		return response(e, nil, fmt.Errorf("dead letter %d is %s, only pending dead letters can be retried", deadLetter.ID, deadLetter.Status))
+	}
+
+	if deadLetter.MarketID == "" || deadLetter.Type == models.EventRetryDeadLetter {
+		return response(e, nil, fmt.Errorf("dead letter %d is not an event of a market, it can't be retried", deadLetter.ID))
+	}
+
+	deadLetter.Status = models.DEAD_LETTER_RETRYING
+	deadLetter.UpdatedAt = time.Now().UTC()
+
+	err = models.DeadLetterEventDao.UpdateEvent(deadLetter)
+	if err != nil {
+		return response(e, nil, err)
+	}
+
+	retryEvent := models.RetryDeadLetterEvent{
+		Event: common.Event{
+			Type:     models.EventRetryDeadLetter,
+			MarketID: deadLetter.MarketID,
+		},
+		DeadLetterID: deadLetter.ID,
+	}
+
+	err = queueService.Push([]byte(utils.ToJsonString(retryEvent)))
+	return response(e, deadLetter, err)
+}
+
+func DiscardDeadLetterHandler(e echo.Context) (err error) {
+	deadLetter, err := findDeadLetter(e.Param("id"))
+	if err != nil {
+		return response(e, nil, err)
+	}
+
+	if deadLetter.Status != models.DEAD_LETTER_PENDING {
+		return response(e, nil, fmt.Errorf("dead letter %d is %s, only pending dead letters can be discarded", deadLetter.ID, deadLetter.Status))
+	}
+
+	deadLetter.Status = models.DEAD_LETTER_DISCARDED
+	deadLetter.UpdatedAt = time.Now().UTC()
+
+	err = models.DeadLetterEventDao.UpdateEvent(deadLetter)
+	return response(e, deadLetter, err)
+}
+
+func findDeadLetter(idParam string) (*models.DeadLetterEvent, error) {
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dead letter id %s", idParam)
+	}
+
+	deadLetter := models.DeadLetterEventDao.FindByID(id)
+	if deadLetter == nil {
+		return nil, fmt.Errorf("cannot find dead letter by ID %d", id)
+	}
+
+	return deadLetter, nil
+}
+
 func ListMarketsHandler(e echo.Context) (err error) {
 	markets := models.MarketDao.FindAllMarkets()
 	return response(e, markets, err)
@@ -188,6 +327,42 @@ func EditMarketHandler(e echo.Context) (err error) {
 	if len(fields.GasUsedEstimation) > 0 {
 		dbMarket.GasUsedEstimation = utils.ParseInt(fields.GasUsedEstimation, 0)
 	}
+	var tradingStateChanged bool
+	if len(fields.TradingState) > 0 {
+		err = checkTradingState(fields.TradingState)
+		if err != nil {
+			return response(e, nil, err)
+		}
+
+		tradingStateChanged = dbMarket.TradingState != fields.TradingState
+		dbMarket.TradingState = fields.TradingState
+	}
+	if len(fields.SelfTradePrevention) > 0 {
+		dbMarket.SelfTradePrevention, err = parseSelfTradePrevention(fields.SelfTradePrevention)
+		if err != nil {
+			return response(e, nil, err)
+		}
+	}
+	if len(fields.PriceBand) > 0 {
+		dbMarket.PriceBand, err = parseFraction("price band", fields.PriceBand)
+		if err != nil {
+			return response(e, nil, err)
+		}
+	}
+	if len(fields.PriceBandReference) > 0 {
+		err = checkPriceBandReference(fields.PriceBandReference)
+		if err != nil {
+			return response(e, nil, err)
+		}
+
+		dbMarket.PriceBandReference = fields.PriceBandReference
+	}
+	if len(fields.MarketOrderMaxSlippage) > 0 {
+		dbMarket.MarketOrderMaxSlippage, err = parseFraction("market order max slippage", fields.MarketOrderMaxSlippage)
+		if err != nil {
+			return response(e, nil, err)
+		}
+	}
 	if fields.IsPublished == "true" {
 		dbMarket.IsPublished = true
 	} else if fields.IsPublished == "false" {
@@ -215,6 +390,29 @@ func EditMarketHandler(e echo.Context) (err error) {
 			}
 
 			err = queueService.Push([]byte(utils.ToJsonString(event)))
+		} else if dbMarket.IsPublished {
+			// the engine journals the config, a market which is opened now loads it from the database
+			configEvent := models.MarketConfigEvent{
+				Event: common.Event{
+					Type:     models.EventMarketConfig,
+					MarketID: dbMarket.ID,
+				},
+				Market: dbMarket,
+			}
+
+			err = queueService.Push([]byte(utils.ToJsonString(configEvent)))
+
+			if err == nil && tradingStateChanged {
+				event := models.ChangeMarketStateEvent{
+					Event: common.Event{
+						Type:     models.EventChangeMarketState,
+						MarketID: dbMarket.ID,
+					},
+					TradingState: dbMarket.TradingState,
+				}
+
+				err = queueService.Push([]byte(utils.ToJsonString(event)))
+			}
 		}
 	}
 
@@ -305,6 +503,49 @@ func response(e echo.Context, data interface{}, err error) error {
 	return e.JSONPretty(http.StatusOK, ret, "  ")
 }
 
+func checkTradingState(state string) error {
+	switch state {
+	case models.MARKET_STATE_TRADING, models.MARKET_STATE_HALTED, models.MARKET_STATE_CANCEL_ONLY, models.MARKET_STATE_POST_ONLY, models.MARKET_STATE_CALL_AUCTION:
+		return nil
+	default:
+		return fmt.Errorf("unknown trading state %s", state)
+	}
+}
+
+// parseSelfTradePrevention checks the mode set by the admin, "none" turns self trade prevention off.
+func parseSelfTradePrevention(mode string) (string, error) {
+	switch mode {
+	case "none":
+		return "", nil
+	case models.SELF_TRADE_PREVENTION_CANCEL_NEWEST,
+		models.SELF_TRADE_PREVENTION_CANCEL_OLDEST,
+		models.SELF_TRADE_PREVENTION_CANCEL_BOTH,
+		models.SELF_TRADE_PREVENTION_DECREMENT_AND_CANCEL:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown self trade prevention mode %s", mode)
+	}
+}
+
+func checkPriceBandReference(reference string) error {
+	switch reference {
+	case models.PRICE_BAND_REFERENCE_LAST_TRADE, models.PRICE_BAND_REFERENCE_ORACLE:
+		return nil
+	default:
+		return fmt.Errorf("unknown price band reference %s", reference)
+	}
+}
+
+// parseFraction parses a fraction of a price set by the admin, it must be at least 0 and less than 1.
+func parseFraction(name, value string) (decimal.Decimal, error) {
+	fraction, err := decimal.NewFromString(value)
+	if err != nil || fraction.IsNegative() || fraction.GreaterThanOrEqual(decimal.New(1, 0)) {
+		return decimal.Zero, fmt.Errorf("%s must be at least 0 and less than 1, got %s", name, value)
+	}
+
+	return fraction, nil
+}
+
 type marketFields struct {
 	ID                string `json:"market_id"`
 	MinOrderSize      string `json:"min_order_size"`
@@ -315,4 +556,19 @@ type marketFields struct {
 	TakerFeeRate      string `json:"taker_fee_rate"`
 	GasUsedEstimation string `json:"gas_used_estimation"`
 	IsPublished       string `json:"is_published"`
+
+	// SelfTradePrevention is one of the self trade prevention modes, or "none" to turn it off
+	SelfTradePrevention string `json:"self_trade_prevention"`
+
+	// TradingState is one of trading, halted, cancel_only, post_only or call_auction.
+	// A market can be published in call_auction, it opens when it is changed to trading afterwards.
+	TradingState string `json:"trading_state"`
+
+	// PriceBand is the fraction an order price may be away from the price band reference, 0 turns the band off
+	PriceBand string `json:"price_band"`
+	// PriceBandReference is last_trade or oracle
+	PriceBandReference string `json:"price_band_reference"`
+	// MarketOrderMaxSlippage is the fraction a market order may trade away from the best price, 0 turns it off.
+	// A running engine uses the new slippage from the next order on, like the other settings of the market.
+	MarketOrderMaxSlippage string `json:"market_order_max_slippage"`
 }
@@ -14,6 +14,7 @@ import (
 )
 
 var queueService common.IQueue
+var kvStore common.IKVStore
 var healthCheckService IHealthCheckMonitor
 var erc20Service ethereum.IErc20
 
@@ -28,6 +29,11 @@ func loadRoutes(e *echo.Echo) {
 	e.Add("GET", "/balances", GetBalancesHandler)
 	e.Add("GET", "/status", GetStatusHandler)
 	e.Add("POST", "/restart_engine", RestartEngineHandler)
+	e.Add("GET", "/restart_engine", GetRestartEngineHandler)
+	e.Add("GET", "/dead_letters", ListDeadLettersHandler)
+	e.Add("GET", "/dead_letters/:id", GetDeadLetterHandler)
+	e.Add("POST", "/dead_letters/:id/retry", RetryDeadLetterHandler)
+	e.Add("POST", "/dead_letters/:id/discard", DiscardDeadLetterHandler)
 }
 
 func newEchoServer() *echo.Echo {
@@ -56,14 +62,13 @@ func StartServer(ctx context.Context) {
 	//init erc20 service
 	erc20Service = ethereum.NewErc20Service(nil)
 
+	backend := connection.NewBackend(ctx, os.Getenv("HSK_REDIS_URL"))
+
 	//init event queue
-	queueService, _ = common.InitQueue(
-		&common.RedisQueueConfig{
-			Name:   common.HYDRO_ENGINE_EVENTS_QUEUE_KEY,
-			Ctx:    ctx,
-			Client: connection.NewRedisClient(os.Getenv("HSK_REDIS_URL")),
-		},
-	)
+	queueService, _ = backend.Queue(common.HYDRO_ENGINE_EVENTS_QUEUE_KEY)
+
+	//init kv store, the engine reports the progress of a restart there
+	kvStore, _ = backend.KVStore()
 
 	e := newEchoServer()
 	s := &http.Server{
@@ -33,11 +33,12 @@ type IAdminApi interface {
 
 	NewMarket(marketID, baseTokenAddress, quoteTokenAddress, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation string) ([]byte, error)
 	ListMarkets() ([]byte, error)
-	UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation, isPublish string) ([]byte, error)
+	UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation, isPublish, selfTradePrevention, priceBand, priceBandReference, marketOrderMaxSlippage string) ([]byte, error)
 	PublishMarket(marketID string) ([]byte, error)
 	ApproveMarket(marketID string) (ret []byte, err error)
 	UnPublishMarket(marketID string) ([]byte, error)
 	UpdateMarketFee(marketID, makerFee, takerFee string) ([]byte, error)
+	ChangeMarketState(marketID, tradingState string) ([]byte, error)
 
 	ListAccountOrders(marketID, address, limit, offset, status string) ([]byte, error)
 	ListAccountBalances(address, limit, offset string) ([]byte, error)
@@ -46,6 +47,12 @@ type IAdminApi interface {
 	CancelOrder(ID string) ([]byte, error)
 
 	RestartEngine() ([]byte, error)
+	RestartEngineStatus() ([]byte, error)
+
+	ListDeadLetters(status, limit, offset string) ([]byte, error)
+	GetDeadLetter(ID string) ([]byte, error)
+	RetryDeadLetter(ID string) ([]byte, error)
+	DiscardDeadLetter(ID string) ([]byte, error)
 }
 
 type Admin struct {
@@ -58,6 +65,7 @@ type Admin struct {
 	ListBalanceUrl   string
 	ListTradeUrl     string
 	RestartEngineUrl string
+	DeadLetterUrl    string
 	StatusUrl        string
 }
 
@@ -97,6 +105,7 @@ func NewAdmin(adminApiUrl string, httpClient utils.IHttpClient, erc20 ethereum.I
 	a.ListTradeUrl = fmt.Sprintf("%s/%s", adminApiUrl, "trades")
 	a.ListBalanceUrl = fmt.Sprintf("%s/%s", adminApiUrl, "balances")
 	a.RestartEngineUrl = fmt.Sprintf("%s/%s", adminApiUrl, "restart_engine")
+	a.DeadLetterUrl = fmt.Sprintf("%s/%s", adminApiUrl, "dead_letters")
 	a.StatusUrl = fmt.Sprintf("%s/%s", adminApiUrl, "status")
 
 	return &a
@@ -172,7 +181,7 @@ func (a *Admin) NewMarket(marketID, baseTokenAddress, quoteTokenAddress, minOrde
 	return
 }
 
-func (a *Admin) UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation, isPublish string) (ret []byte, err error) {
+func (a *Admin) UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation, isPublish, selfTradePrevention, priceBand, priceBandReference, marketOrderMaxSlippage string) (ret []byte, err error) {
 	fields := marketFields{
 		ID:                marketID,
 		MinOrderSize:      minOrderSize,
@@ -183,6 +192,11 @@ func (a *Admin) UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecima
 		TakerFeeRate:      takerFeeRate,
 		GasUsedEstimation: gasUsedEstimation,
 		IsPublished:       isPublish,
+
+		SelfTradePrevention:    selfTradePrevention,
+		PriceBand:              priceBand,
+		PriceBandReference:     priceBandReference,
+		MarketOrderMaxSlippage: marketOrderMaxSlippage,
 	}
 
 	err, _, ret = a.client.Put(a.MarketUrl, nil, fields, nil)
@@ -227,6 +241,16 @@ func (a *Admin) UpdateMarketFee(marketID, makerFee, takerFee string) (ret []byte
 	return
 }
 
+func (a *Admin) ChangeMarketState(marketID, tradingState string) (ret []byte, err error) {
+	market := marketFields{
+		ID:           marketID,
+		TradingState: tradingState,
+	}
+
+	err, _, ret = a.client.Put(a.MarketUrl, nil, market, nil)
+	return
+}
+
 func (a *Admin) ListAccountOrders(marketID, address, limit, offset, status string) (ret []byte, err error) {
 	var params []utils.KeyValue
 	params = append(params, utils.KeyValue{Key: "market_id", Value: marketID})
@@ -267,10 +291,40 @@ func (a *Admin) CancelOrder(ID string) (ret []byte, err error) {
 }
 
 func (a *Admin) RestartEngine() (ret []byte, err error) {
+	err, _, ret = a.client.Post(a.RestartEngineUrl, nil, nil, nil)
+	return
+}
+
+func (a *Admin) RestartEngineStatus() (ret []byte, err error) {
 	err, _, ret = a.client.Get(a.RestartEngineUrl, nil, nil, nil)
 	return
 }
 
+func (a *Admin) ListDeadLetters(status, limit, offset string) (ret []byte, err error) {
+	var params []utils.KeyValue
+	params = append(params, utils.KeyValue{Key: "status", Value: status})
+	params = append(params, utils.KeyValue{Key: "limit", Value: limit})
+	params = append(params, utils.KeyValue{Key: "offset", Value: offset})
+
+	err, _, ret = a.client.Get(a.DeadLetterUrl, params, nil, nil)
+	return
+}
+
+func (a *Admin) GetDeadLetter(ID string) (ret []byte, err error) {
+	err, _, ret = a.client.Get(fmt.Sprintf("%s/%s", a.DeadLetterUrl, ID), nil, nil, nil)
+	return
+}
+
+func (a *Admin) RetryDeadLetter(ID string) (ret []byte, err error) {
+	err, _, ret = a.client.Post(fmt.Sprintf("%s/%s/retry", a.DeadLetterUrl, ID), nil, nil, nil)
+	return
+}
+
+func (a *Admin) DiscardDeadLetter(ID string) (ret []byte, err error) {
+	err, _, ret = a.client.Post(fmt.Sprintf("%s/%s/discard", a.DeadLetterUrl, ID), nil, nil, nil)
+	return
+}
+
 func DefaultIfNil(ori, dft string) string {
 	if len(ori) == 0 {
 		return dft
@@ -289,4 +343,18 @@ type marketFields struct {
 	TakerFeeRate      string `json:"taker_fee_rate"`
 	GasUsedEstimation string `json:"gas_used_estimation"`
 	IsPublished       string `json:"is_published"`
+
+	// SelfTradePrevention is one of the self trade prevention modes, or "none" to turn it off
+	SelfTradePrevention string `json:"self_trade_prevention"`
+
+	// TradingState is one of trading, halted, cancel_only, post_only or call_auction
+	TradingState string `json:"trading_state"`
+
+	// PriceBand is the fraction an order price may be away from the price band reference, 0 turns the band off
+	PriceBand string `json:"price_band"`
+	// PriceBandReference is last_trade or oracle
+	PriceBandReference string `json:"price_band_reference"`
+	// MarketOrderMaxSlippage is the fraction a market order may trade away from the best price, 0 turns it off.
+	// A running engine uses the new slippage after the market is loaded again.
+	MarketOrderMaxSlippage string `json:"market_order_max_slippage"`
 }
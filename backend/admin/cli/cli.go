@@ -27,6 +27,14 @@ func NewDexCli() *cli.App {
 	var makerFeeRate string
 	var takerFeeRate string
 	var gasUsedEstimation string
+	var selfTradePrevention string
+	var priceBand string
+	var priceBandReference string
+	var marketOrderMaxSlippage string
+
+	var deadLetterStatus string
+	var deadLetterLimit string
+	var deadLetterOffset string
 
 	//var limit string
 	//var offset string
@@ -113,6 +121,26 @@ func NewDexCli() *cli.App {
 			Name:        "isPublish",
 			Destination: &isPublish,
 		},
+		cli.StringFlag{
+			Name:        "selfTradePrevention",
+			Usage:       "cancel_newest, cancel_oldest, cancel_both, decrement_and_cancel or none",
+			Destination: &selfTradePrevention,
+		},
+		cli.StringFlag{
+			Name:        "priceBand",
+			Usage:       "fraction an order price may be away from the reference price, 0 turns the band off",
+			Destination: &priceBand,
+		},
+		cli.StringFlag{
+			Name:        "priceBandReference",
+			Usage:       "last_trade or oracle",
+			Destination: &priceBandReference,
+		},
+		cli.StringFlag{
+			Name:        "marketOrderMaxSlippage",
+			Usage:       "fraction a market order may trade away from the best price, 0 turns it off",
+			Destination: &marketOrderMaxSlippage,
+		},
 	}
 	//
 	//orderListFlags := []cli.Flag{
@@ -208,7 +236,7 @@ func NewDexCli() *cli.App {
 							return cli.ShowSubcommandHelp(c)
 						}
 
-						printIfErr(admin.UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation, isPublish))
+						printIfErr(admin.UpdateMarket(marketID, minOrderSize, pricePrecision, priceDecimals, amountDecimals, makerFeeRate, takerFeeRate, gasUsedEstimation, isPublish, selfTradePrevention, priceBand, priceBandReference, marketOrderMaxSlippage))
 						return nil
 					},
 				},
@@ -283,6 +311,28 @@ func NewDexCli() *cli.App {
 						return nil
 					},
 				},
+				{
+					Name:  "changeState",
+					Usage: "Change the trading state of a market: trading, halted, cancel_only, post_only or call_auction",
+					Description: `
+    Example: only take cancels in market 'HOT-WETH'
+
+    hydor-dex-ctl market changeState HOT-WETH cancel_only
+
+    A market in call_auction collects limit orders without matching them, the crossed book is
+    uncrossed in one batch at a single price when the market is changed to trading or post_only.`,
+					Action: func(c *cli.Context) error {
+						marketID = c.Args().Get(0)
+						tradingState := c.Args().Get(1)
+
+						if len(marketID) == 0 || len(tradingState) == 0 {
+							return cli.ShowSubcommandHelp(c)
+						}
+
+						printIfErr(admin.ChangeMarketState(marketID, tradingState))
+						return nil
+					},
+				},
 			},
 		},
 		//{
@@ -366,21 +416,99 @@ func NewDexCli() *cli.App {
 		//		},
 		//	},
 		//},
-		//{
-		//	Name:  "engine",
-		//	Usage: "Manage hydro dex engine",
-		//	Subcommands: cli.Commands{
-		//		{
-		//			Name:  "restart",
-		//			Usage: "",
-		//			Flags: orderListFlags,
-		//			Action: func(c *cli.Context) error {
-		//				printIfErr(admin.RestartEngine())
-		//				return nil
-		//			},
-		//		},
-		//	},
-		//},
+		{
+			Name:  "engine",
+			Usage: "Manage hydro dex engine",
+			Subcommands: cli.Commands{
+				{
+					Name:  "restart",
+					Usage: "Reload all published markets without restarting the engine process",
+					Action: func(c *cli.Context) error {
+						printIfErr(admin.RestartEngine())
+						return nil
+					},
+				},
+				{
+					Name:  "restart-status",
+					Usage: "Get the progress of the latest engine restart",
+					Action: func(c *cli.Context) error {
+						printIfErr(admin.RestartEngineStatus())
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "deadletter",
+			Usage: "Manage engine events which failed. (list, show, retry, discard)",
+			Subcommands: cli.Commands{
+				{
+					Name:  "list",
+					Usage: "List dead letters, newest first",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "status",
+							Usage:       "pending, retrying, resolved or discarded, all dead letters if empty",
+							Destination: &deadLetterStatus,
+						},
+						cli.StringFlag{
+							Name:        "limit",
+							Destination: &deadLetterLimit,
+						},
+						cli.StringFlag{
+							Name:        "offset",
+							Destination: &deadLetterOffset,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						printIfErr(admin.ListDeadLetters(deadLetterStatus, deadLetterLimit, deadLetterOffset))
+						return nil
+					},
+				},
+				{
+					Name:  "show",
+					Usage: "Show a dead letter with its payload, error and stack",
+					Action: func(c *cli.Context) error {
+						id := c.Args().Get(0)
+						if len(id) == 0 {
+							fmt.Println("missing arguments, usage: hydro-dex-ctl deadletter show ID")
+							return nil
+						}
+
+						printIfErr(admin.GetDeadLetter(id))
+						return nil
+					},
+				},
+				{
+					Name:  "retry",
+					Usage: "Send the event of a pending dead letter to its market again",
+					Action: func(c *cli.Context) error {
+						id := c.Args().Get(0)
+						if len(id) == 0 {
+							fmt.Println("missing arguments, usage: hydro-dex-ctl deadletter retry ID")
+							return nil
+						}
+
+						printIfErr(admin.RetryDeadLetter(id))
+						return nil
+					},
+				},
+				{
+					Name:  "discard",
+					Usage: "Drop a pending dead letter",
+					Action: func(c *cli.Context) error {
+						id := c.Args().Get(0)
+						if len(id) == 0 {
+							fmt.Println("missing arguments, usage: hydro-dex-ctl deadletter discard ID")
+							return nil
+						}
+
+						printIfErr(admin.DiscardDeadLetter(id))
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:  "status",
 			Usage: "Get current status of the ",
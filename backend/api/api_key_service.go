@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiKeyPrefix = "hk_"
+
+// CreateApiKey creates an api key for the authenticated wallet. The key and its secret are only returned here,
+// the key is kept hashed and the secret is derived from the key with HSK_API_KEY_SECRET whenever it is needed.
+func CreateApiKey(p Param) (interface{}, error) {
+	req := p.(*CreateApiKeyReq)
+
+	for _, ip := range req.AllowedIPs {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return nil, ValidationError(fmt.Sprintf("allowed ip %s should be an IP or a CIDR block", ip))
+			}
+		}
+	}
+
+	random, err := randomHex(24)
+	if err != nil {
+		return nil, err
+	}
+
+	key := apiKeyPrefix + random
+
+	secret, err := apiKeySecret(key)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := &models.ApiKey{
+		Address:    req.Address,
+		Name:       req.Name,
+		KeyHash:    hashToken(key),
+		KeyPrefix:  key[:len(apiKeyPrefix)+8],
+		Scopes:     strings.Join(uniqueStrings(req.Scopes), ","),
+		AllowedIPs: strings.Join(req.AllowedIPs, ","),
+		CreatedAt:  time.Now(),
+	}
+
+	if err := models.ApiKeyDao.InsertApiKey(apiKey); err != nil {
+		return nil, err
+	}
+
+	return &CreateApiKeyResp{
+		ApiKey: apiKey,
+		Key:    key,
+		Secret: secret,
+	}, nil
+}
+
+func GetApiKeys(p Param) (interface{}, error) {
+	return &ApiKeysResp{
+		ApiKeys: models.ApiKeyDao.FindActiveApiKeysByAddress(p.GetAddress()),
+	}, nil
+}
+
+func DeleteApiKey(p Param) (interface{}, error) {
+	req := p.(*DeleteApiKeyReq)
+
+	id, err := strconv.ParseInt(req.ID, 10, 64)
+	if err != nil {
+		return nil, ValidationError("api key id should be a number")
+	}
+
+	revoked, err := models.ApiKeyDao.RevokeApiKey(req.Address, id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if !revoked {
+		return nil, NewApiError(-1, fmt.Sprintf("api key %d not found", id))
+	}
+
+	return nil, nil
+}
+
+// apiKeySecret returns the secret requests with the key are signed with.
+func apiKeySecret(key string) (string, error) {
+	serverSecret := os.Getenv("HSK_API_KEY_SECRET")
+	if serverSecret == "" {
+		return "", NewApiError(-1, "api keys are not enabled, HSK_API_KEY_SECRET is not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(serverSecret))
+	mac.Write([]byte(key))
+	return utils.Bytes2Hex(mac.Sum(nil)), nil
+}
+
+// signApiRequest returns the HMAC-SHA256 signature of a request, as a hex string.
+func signApiRequest(secret string, nonce int64, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(nonce, 10) + method + requestURI))
+	mac.Write(body)
+	return utils.Bytes2Hex(mac.Sum(nil))
+}
+
+func uniqueStrings(values []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type fakeApiKeyDao struct {
+	models.IApiKeyDao
+	apiKey *models.ApiKey
+}
+
+func (d *fakeApiKeyDao) FindActiveApiKey(keyHash string) *models.ApiKey {
+	if d.apiKey.KeyHash == keyHash {
+		return d.apiKey
+	}
+
+	return nil
+}
+
+func (d *fakeApiKeyDao) UseNonce(id int64, nonce int64, usedAt time.Time) (bool, error) {
+	if nonce <= d.apiKey.LastNonce {
+		return false, nil
+	}
+
+	d.apiKey.LastNonce = nonce
+	return true, nil
+}
+
+func TestApiKeyMiddleware(t *testing.T) {
+	_ = os.Setenv("HSK_API_KEY_SECRET", "test-secret")
+	defer os.Unsetenv("HSK_API_KEY_SECRET")
+
+	key := "hk_0123456789abcdef"
+	secret, _ := apiKeySecret(key)
+
+	dao := &fakeApiKeyDao{apiKey: &models.ApiKey{
+		ID:         1,
+		Address:    models.TestUser1,
+		KeyHash:    hashToken(key),
+		Scopes:     "read,trade",
+		AllowedIPs: "10.0.0.0/8",
+	}}
+
+	apiKeyDao := models.ApiKeyDao
+	models.ApiKeyDao = dao
+	defer func() { models.ApiKeyDao = apiKeyDao }()
+
+	body := `{"orderID":"0x01","signature":"0x02"}`
+
+	remoteAddr := "10.1.2.3:52000"
+	forwardedFor := ""
+
+	send := func(scope string, nonce int64, signature string) (*HydroApiContext, error) {
+		req := httptest.NewRequest(http.MethodPost, "/orders?marketID=HOT-DAI", bytes.NewReader([]byte(body)))
+		req.Header.Set("Hydro-Api-Key", key)
+		req.Header.Set("Hydro-Api-Nonce", fmt.Sprint(nonce))
+		req.Header.Set("Hydro-Api-Signature", signature)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+			req.Header.Set(echo.HeaderXRealIP, forwardedFor)
+		}
+
+		cc := &HydroApiContext{echo.New().NewContext(req, httptest.NewRecorder()), "", 0}
+		return cc, apiKeyMiddleware(scope)(func(c echo.Context) error {
+			// the handler still reads the body which was signed
+			read, _ := ioutil.ReadAll(c.Request().Body)
+			assert.EqualValues(t, body, string(read))
+			return nil
+		})(cc)
+	}
+
+	nonce := time.Now().UnixNano() / int64(time.Millisecond)
+	signature := signApiRequest(secret, nonce, http.MethodPost, "/orders?marketID=HOT-DAI", []byte(body))
+
+	cc, err := send(models.API_KEY_SCOPE_TRADE, nonce, signature)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.TestUser1, cc.Address)
+	assert.EqualValues(t, 1, cc.ApiKeyID)
+
+	// the same request can't be sent again
+	_, err = send(models.API_KEY_SCOPE_TRADE, nonce, signature)
+	assert.NotNil(t, err)
+
+	// the key has no cancel scope
+	signature = signApiRequest(secret, nonce+1, http.MethodPost, "/orders?marketID=HOT-DAI", []byte(body))
+	_, err = send(models.API_KEY_SCOPE_CANCEL, nonce+1, signature)
+	assert.NotNil(t, err)
+
+	// the signature covers the query
+	signature = signApiRequest(secret, nonce+1, http.MethodPost, "/orders?marketID=WETH-DAI", []byte(body))
+	_, err = send(models.API_KEY_SCOPE_TRADE, nonce+1, signature)
+	assert.NotNil(t, err)
+
+	// the key is used from 10.0.0.0/8 only, the headers of a client which is not a trusted proxy are ignored
+	remoteAddr = "192.0.2.1:52000"
+	forwardedFor = "10.1.2.3"
+	signature = signApiRequest(secret, nonce+1, http.MethodPost, "/orders?marketID=HOT-DAI", []byte(body))
+	_, err = send(models.API_KEY_SCOPE_TRADE, nonce+1, signature)
+	assert.NotNil(t, err)
+
+	// a trusted proxy forwards the address of the client
+	_ = os.Setenv("HSK_TRUSTED_PROXIES", "192.0.2.0/24")
+	defer os.Unsetenv("HSK_TRUSTED_PROXIES")
+	_, err = send(models.API_KEY_SCOPE_TRADE, nonce+1, signature)
+	assert.Nil(t, err)
+
+	dao.apiKey.AllowedIPs = "192.168.0.0/16"
+	signature = signApiRequest(secret, nonce+2, http.MethodPost, "/orders?marketID=HOT-DAI", []byte(body))
+	_, err = send(models.API_KEY_SCOPE_TRADE, nonce+2, signature)
+	assert.NotNil(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	newRequest := func(remoteAddr, forwardedFor, realIP string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/markets", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		}
+		if realIP != "" {
+			req.Header.Set(echo.HeaderXRealIP, realIP)
+		}
+		return req
+	}
+
+	// nobody is trusted by default
+	assert.EqualValues(t, "203.0.113.9", clientIP(newRequest("203.0.113.9:1234", "10.1.2.3", "10.1.2.3")))
+
+	_ = os.Setenv("HSK_TRUSTED_PROXIES", "172.16.0.0/12, 192.0.2.7")
+	defer os.Unsetenv("HSK_TRUSTED_PROXIES")
+
+	assert.EqualValues(t, "203.0.113.9", clientIP(newRequest("203.0.113.9:1234", "10.1.2.3", "")))
+	assert.EqualValues(t, "10.1.2.3", clientIP(newRequest("192.0.2.7:1234", "10.1.2.3", "")))
+	assert.EqualValues(t, "10.1.2.3", clientIP(newRequest("172.16.0.2:1234", "", "10.1.2.3")))
+
+	// the client can put anything in front of the address the proxies add
+	assert.EqualValues(t, "10.1.2.3", clientIP(newRequest("172.16.0.2:1234", "198.51.100.1, 10.1.2.3, 192.0.2.7", "")))
+	assert.EqualValues(t, "172.16.0.2", clientIP(newRequest("172.16.0.2:1234", "", "")))
+}
@@ -1,28 +1,113 @@
 package api
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
 	"github.com/labstack/echo"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// defaultAuthClockSkew is how many seconds the time of a Hydro-Authentication message may differ from the clock of the server.
+const defaultAuthClockSkew = 300
+
+const hydroAuthenticationMessage = "HYDRO-AUTHENTICATION"
+
 type HydroApiContext struct {
 	echo.Context
 	// If address is not empty means this user is authenticated.
 	Address string
+	// ApiKeyID is the api key the request is authenticated with, it is 0 if the wallet authenticated it.
+	ApiKeyID int64
+}
+
+// clientIP returns the address the request comes from. The forwarding headers can be set by anyone,
+// so they are only believed if the request comes from a proxy in HSK_TRUSTED_PROXIES, ips or cidrs separated by commas.
+// Every trusted proxy appends the address it received the request from to X-Forwarded-For, the client is the last
+// address in it which is not a trusted proxy.
+func clientIP(req *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteIP = req.RemoteAddr
+	}
+
+	if !isTrustedProxy(remoteIP) {
+		return remoteIP
+	}
+
+	if forwardedFor := strings.Join(req.Header[echo.HeaderXForwardedFor], ","); forwardedFor != "" {
+		ips := strings.Split(forwardedFor, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if i == 0 || !isTrustedProxy(ip) {
+				return ip
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(req.Header.Get(echo.HeaderXRealIP)); realIP != "" {
+		return realIP
+	}
+
+	return remoteIP
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, proxy := range strings.Split(os.Getenv("HSK_TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(parsed) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func initHydroApiContext(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cc := &HydroApiContext{c, ""}
+		cc := &HydroApiContext{c, "", 0}
 		return next(cc)
 	}
 }
 
+// authMiddleware authenticates the trader with the token of a session in the Authorization header,
+// or with a signed Hydro-Authentication header.
 func authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cc := c.(*HydroApiContext)
 		cc.Response().Header().Set(echo.HeaderServer, "Echo/3.0")
 
+		if token := sessionToken(cc.Request().Header.Get(echo.HeaderAuthorization)); token != "" {
+			session := models.SessionDao.FindActiveSession(hashToken(token), time.Now())
+			if session == nil {
+				return &ApiError{Code: -11, Desc: "session is expired or revoked, please sign in again"}
+			}
+
+			cc.Address = session.Address
+			return next(cc)
+		}
+
 		hydroAuthToken := cc.Request().Header.Get("Hydro-Authentication")
 		hydroAuthTokens := strings.Split(hydroAuthToken, "#")
 
@@ -30,6 +115,10 @@ func authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return &ApiError{Code: -11, Desc: "Hydro-Authentication should be like {address}#HYDRO-AUTHENTICATION@{time}#{signature}"}
 		}
 
+		if err := checkAuthenticationTime(hydroAuthTokens[1], time.Now()); err != nil {
+			return &ApiError{Code: -11, Desc: err.Error()}
+		}
+
 		valid, err := hydro.IsValidSignature(hydroAuthTokens[0], hydroAuthTokens[1], hydroAuthTokens[2])
 		if !valid || err != nil {
 			return &ApiError{Code: -11, Desc: "Hydro-Authentication valid failed, please check your authentication"}
@@ -38,3 +127,129 @@ func authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(cc)
 	}
 }
+
+// sessionToken returns the token of an Authorization header like Bearer {token}.
+func sessionToken(authorization string) string {
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+// checkAuthenticationTime checks the time of a HYDRO-AUTHENTICATION@{time} message, in milliseconds since the epoch.
+// A signed message is only accepted while its time is within the allowed clock skew, so a captured header can't be
+// used for long.
+func checkAuthenticationTime(message string, now time.Time) error {
+	parts := strings.Split(message, "@")
+	if len(parts) != 2 || parts[0] != hydroAuthenticationMessage {
+		return fmt.Errorf("Hydro-Authentication message should be like HYDRO-AUTHENTICATION@{time}")
+	}
+
+	millis, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Hydro-Authentication time should be milliseconds since the epoch")
+	}
+
+	if !withinClockSkew(millis, now) {
+		return fmt.Errorf("Hydro-Authentication is expired, please sign again")
+	}
+
+	return nil
+}
+
+// withinClockSkew returns true if the time in milliseconds since the epoch differs from now by the allowed clock skew at most.
+func withinClockSkew(millis int64, now time.Time) bool {
+	skew := time.Duration(utils.ParseInt(os.Getenv("HSK_AUTH_CLOCK_SKEW"), defaultAuthClockSkew)) * time.Second
+	signedAt := time.Unix(0, millis*int64(time.Millisecond))
+
+	return !signedAt.Before(now.Add(-skew)) && !signedAt.After(now.Add(skew))
+}
+
+// apiKeyMiddleware authenticates the trader with an api key which has the scope. Requests without
+// a Hydro-Api-Key header are authenticated by authMiddleware.
+// A request with an api key is signed like
+//
+//	Hydro-Api-Key: {key}
+//	Hydro-Api-Nonce: {time in milliseconds}
+//	Hydro-Api-Signature: hex(HMAC-SHA256({secret}, {nonce}{method}{path and query}{body}))
+//
+// The nonce must be within the allowed clock skew and larger than the nonce of the last request of the key.
+func apiKeyMiddleware(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		walletAuth := authMiddleware(next)
+
+		return func(c echo.Context) error {
+			cc := c.(*HydroApiContext)
+
+			key := cc.Request().Header.Get("Hydro-Api-Key")
+			if key == "" {
+				return walletAuth(cc)
+			}
+
+			cc.Response().Header().Set(echo.HeaderServer, "Echo/3.0")
+
+			apiKey, err := authenticateApiKey(cc, key, scope, time.Now())
+			if err != nil {
+				return err
+			}
+
+			cc.Address = apiKey.Address
+			cc.ApiKeyID = apiKey.ID
+			return next(cc)
+		}
+	}
+}
+
+func authenticateApiKey(cc *HydroApiContext, key, scope string, now time.Time) (*models.ApiKey, error) {
+	req := cc.Request()
+
+	apiKey := models.ApiKeyDao.FindActiveApiKey(hashToken(key))
+	if apiKey == nil {
+		return nil, &ApiError{Code: -11, Desc: "api key is unknown or revoked"}
+	}
+
+	if !apiKey.HasScope(scope) {
+		return nil, &ApiError{Code: -11, Desc: fmt.Sprintf("api key has no %s scope", scope)}
+	}
+
+	if ip := clientIP(req); !apiKey.AllowsIP(ip) {
+		return nil, &ApiError{Code: -11, Desc: fmt.Sprintf("api key is not allowed from %s", ip)}
+	}
+
+	nonce, err := strconv.ParseInt(req.Header.Get("Hydro-Api-Nonce"), 10, 64)
+	if err != nil || !withinClockSkew(nonce, now) {
+		return nil, &ApiError{Code: -11, Desc: "Hydro-Api-Nonce should be the current time in milliseconds"}
+	}
+
+	// the body is read for the signature, it is put back for the handler
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	secret, err := apiKeySecret(key)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := signApiRequest(secret, nonce, req.Method, req.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(strings.ToLower(req.Header.Get("Hydro-Api-Signature")))) {
+		return nil, &ApiError{Code: -11, Desc: "Hydro-Api-Signature valid failed, please check your signature"}
+	}
+
+	used, err := models.ApiKeyDao.UseNonce(apiKey.ID, nonce, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !used {
+		return nil, &ApiError{Code: -11, Desc: "Hydro-Api-Nonce should be larger than the nonce of the last request"}
+	}
+
+	return apiKey, nil
+}
@@ -35,6 +35,13 @@ func InvalidPriceAmountError() *ApiError {
 	return &ApiError{Code: -4, Desc: fmt.Sprintf("price and amount should be positive number")}
 }
 
+// RateLimitedCode is the code of requests refused by the rate limit, they are answered with a 429.
+const RateLimitedCode = -12
+
+func RateLimitedError(group string, retryAfter int) *ApiError {
+	return &ApiError{Code: RateLimitedCode, Desc: fmt.Sprintf("too many %s requests, retry after %d seconds", group, retryAfter)}
+}
+
 func buildErrorMessage(errors validator.ValidationErrors) string {
 	buff := bytes.Buffer{}
 
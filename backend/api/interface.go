@@ -74,26 +74,44 @@ type (
 		BaseReq
 		MarketID  string `json:"marketID"  validate:"required"`
 		Side      string `json:"side"      validate:"required,oneof=buy sell"`
-		OrderType string `json:"orderType" validate:"required,oneof=limit market"`
+		OrderType string `json:"orderType" validate:"required,oneof=limit market stop-limit stop-market"`
 		Price     string `json:"price"     validate:"required"`
+		StopPrice string `json:"stopPrice"`
 		Amount    string `json:"amount"    validate:"required"`
 		Expires   int64  `json:"expires"`
-		AccountType    string `json:"accountType,omitempty"`    // "spot" or "margin"
-		MarginMarketID string `json:"marginMarketID,omitempty"` // The marketID if accountType is "margin" (usually same as MarketID for order placement)
+		// TimeInForce is GTC by default, GTT orders expire after Expires seconds
+		TimeInForce string `json:"timeInForce" validate:"omitempty,oneof=GTC IOC FOK GTT"`
+		// SelfTradePrevention decides what happens when the order would match an order of the same trader,
+		// the mode of the market is used if it is empty
+		SelfTradePrevention string `json:"selfTradePrevention" validate:"omitempty,oneof=cancel_newest cancel_oldest cancel_both decrement_and_cancel"`
+		// IsMakerOnly orders never take liquidity, the engine rejects them if they would match.
+		// With PostOnlyReprice the price is moved just behind the best opposite price when the order is built.
+		IsMakerOnly     bool   `json:"isMakerOnly"`
+		PostOnlyReprice bool   `json:"postOnlyReprice"`
+		AccountType     string `json:"accountType,omitempty"`    // "spot" or "margin"
+		MarginMarketID  string `json:"marginMarketID,omitempty"` // The marketID if accountType is "margin" (usually same as MarketID for order placement)
+		// DisplayAmount makes a limit order an iceberg order, the book shows at most DisplayAmount of it at a time
+		DisplayAmount string `json:"displayAmount"`
 	}
 
 	BuildOrderResp struct {
-		ID              string            `json:"id"`
-		MarketID        string            `json:"marketID"`
-		Side            string            `json:"side"`
-		Type            string            `json:"type"`
-		Price           decimal.Decimal   `json:"price"`
-		Amount          decimal.Decimal   `json:"amount"`
-		Json            *models.OrderJSON `json:"json"`
-		AsMakerFeeRate  decimal.Decimal   `json:"asMakerFeeRate"`
-		AsTakerFeeRate  decimal.Decimal   `json:"asTakerFeeRate"`
-		MakerRebateRate decimal.Decimal   `json:"makerRebateRate"`
-		GasFeeAmount    decimal.Decimal   `json:"gasFeeAmount"`
+		ID                  string            `json:"id"`
+		MarketID            string            `json:"marketID"`
+		Side                string            `json:"side"`
+		Type                string            `json:"type"`
+		Price               decimal.Decimal   `json:"price"`
+		Amount              decimal.Decimal   `json:"amount"`
+		StopPrice           decimal.Decimal   `json:"stopPrice"`
+		Json                *models.OrderJSON `json:"json"`
+		AsMakerFeeRate      decimal.Decimal   `json:"asMakerFeeRate"`
+		AsTakerFeeRate      decimal.Decimal   `json:"asTakerFeeRate"`
+		MakerRebateRate     decimal.Decimal   `json:"makerRebateRate"`
+		GasFeeAmount        decimal.Decimal   `json:"gasFeeAmount"`
+		IsMakerOnly         bool              `json:"isMakerOnly"`
+		Repriced            bool              `json:"repriced"`
+		TimeInForce         string            `json:"timeInForce"`
+		SelfTradePrevention string            `json:"selfTradePrevention"`
+		DisplayAmount       decimal.Decimal   `json:"displayAmount"`
 	}
 
 	PlaceOrderReq struct {
@@ -102,11 +120,85 @@ type (
 		Signature string `json:"signature" validate:"required"`
 	}
 
+	// ReplaceOrderReq places the built order OrderID instead of ReplacedOrderID
+	ReplaceOrderReq struct {
+		PlaceOrderReq
+		ReplacedOrderID string `json:"replacedOrderID" validate:"required,len=66"`
+	}
+
+	// AmendOrderReq lowers the amount left open of the order to AvailableAmount
+	AmendOrderReq struct {
+		BaseReq
+		ID              string `json:"id"              param:"orderID" validate:"required,len=66"`
+		AvailableAmount string `json:"availableAmount" validate:"required"`
+	}
+
 	CancelOrderReq struct {
 		BaseReq
 		ID string `json:"id" param:"orderID" validate:"required,len=66"`
 	}
 
+	// CancelOrdersReq cancels the open orders of the trader with the given IDs,
+	// or all of them in the market and on the side if no ID is given
+	CancelOrdersReq struct {
+		BaseReq
+		MarketID string   `json:"marketID" query:"marketID"`
+		Side     string   `json:"side"     query:"side"     validate:"omitempty,oneof=buy sell"`
+		IDs      []string `json:"ids"      query:"ids"      validate:"max=1000,dive,len=66"`
+	}
+
+	SessionNonceResp struct {
+		Nonce     string `json:"nonce"`
+		ExpiresAt int64  `json:"expiresAt"`
+	}
+
+	// CreateSessionReq signs in with a Sign-In with Ethereum message and its personal signature
+	CreateSessionReq struct {
+		BaseReq
+		Message   string `json:"message"   validate:"required"`
+		Signature string `json:"signature" validate:"required"`
+	}
+
+	CreateSessionResp struct {
+		Token     string `json:"token"`
+		Address   string `json:"address"`
+		ExpiresAt int64  `json:"expiresAt"`
+	}
+
+	// DeleteSessionReq revokes the session of the token, or all sessions of its address if All is set
+	DeleteSessionReq struct {
+		BaseReq
+		Token string `json:"token" validate:"required"`
+		All   bool   `json:"all"`
+	}
+
+	// CreateApiKeyReq creates an api key with the scopes, which may only be used from the allowed IPs or CIDR blocks if any are given
+	CreateApiKeyReq struct {
+		BaseReq
+		Name       string   `json:"name"       validate:"max=64"`
+		Scopes     []string `json:"scopes"     validate:"required,min=1,dive,oneof=read trade cancel"`
+		AllowedIPs []string `json:"allowedIPs" validate:"max=20"`
+	}
+
+	CreateApiKeyResp struct {
+		ApiKey *models.ApiKey `json:"apiKey"`
+		Key    string         `json:"key"`
+		Secret string         `json:"secret"`
+	}
+
+	ApiKeysReq struct {
+		BaseReq
+	}
+
+	ApiKeysResp struct {
+		ApiKeys []*models.ApiKey `json:"apiKeys"`
+	}
+
+	DeleteApiKeyReq struct {
+		BaseReq
+		ID string `json:"id" param:"keyID" validate:"required"`
+	}
+
 	CacheOrder struct {
 		OrderResponse         BuildOrderResp  `json:"orderResponse"`
 		Address               string          `json:"address"`
@@ -126,13 +218,19 @@ type (
 		BaseReq
 		MarketID string `json:"marketID" param:"marketID" validate:"required"`
 		Status   string `json:"status"   query:"status"`
-		Page     int    `json:"page"     query:"page"`
-		PerPage  int    `json:"perPage"  query:"perPage"`
+		Before   string `json:"before"   query:"before"`
+		After    string `json:"after"    query:"after"`
+		From     int64  `json:"from"     query:"from"`
+		To       int64  `json:"to"       query:"to"`
+		Limit    int    `json:"limit"    query:"limit"`
 	}
 
 	QueryTradeResp struct {
-		Count  int64           `json:"count"`
 		Trades []*models.Trade `json:"trades"`
+		// Before is the cursor of the older trades, it is empty if there are none
+		Before string `json:"before,omitempty"`
+		// After is the cursor of the trades newer than the page
+		After string `json:"after,omitempty"`
 	}
 
 	FeesReq struct {
@@ -173,6 +271,9 @@ type (
 		GasFeeAmount           decimal.Decimal `json:"gasFeeAmount"`
 		SupportedOrderTypes    []string        `json:"supportedOrderTypes"`
 		MarketOrderMaxSlippage decimal.Decimal `json:"marketOrderMaxSlippage"`
+		PriceBand              decimal.Decimal `json:"priceBand"`
+		PriceBandReference     string          `json:"priceBandReference"`
+		TradingState           string          `json:"tradingState"`
 		MarketStatus
 
 		// Margin trading parameters
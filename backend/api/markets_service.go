@@ -42,9 +42,22 @@ func GetOrderBook(p Param) (interface{}, error) {
 		return nil, err
 	}
 
-	return map[string]interface{}{
+	ret := map[string]interface{}{
 		"orderBook": snapshot,
-	}, nil
+	}
+
+	// the book of a market in a call auction may be crossed, the indicative price tells where it would uncross
+	market := models.MarketDao.FindMarketByID(marketID)
+	if market != nil && market.TradingState == models.MARKET_STATE_CALL_AUCTION {
+		var auction models.CallAuctionPrice
+
+		auctionStr, err := CacheService.Get(models.GetMarketCallAuctionPriceKey(marketID))
+		if err == nil && json.Unmarshal([]byte(auctionStr), &auction) == nil {
+			ret["callAuction"] = auction
+		}
+	}
+
+	return ret, nil
 }
 
 func GetMarkets(_ Param) (interface{}, error) {
@@ -101,8 +114,11 @@ func GetMarkets(_ Param) (interface{}, error) {
 			AsMakerFeeRate:         dbMarket.MakerFeeRate,
 			AsTakerFeeRate:         dbMarket.TakerFeeRate,
 			GasFeeAmount:           gasFeeAmount,
-			SupportedOrderTypes:    []string{"limit", "market"},
-			MarketOrderMaxSlippage: utils.StringToDecimal("0.1"),
+			SupportedOrderTypes:    []string{"limit", "market", models.ORDER_TYPE_STOP_LIMIT, models.ORDER_TYPE_STOP_MARKET},
+			MarketOrderMaxSlippage: dbMarket.MarketOrderMaxSlippage,
+			PriceBand:              dbMarket.PriceBand,
+			PriceBandReference:     dbMarket.PriceBandReference,
+			TradingState:           dbMarket.TradingState,
 			MarketStatus:           *marketStatus,
 
 			// Populate new margin trading fields
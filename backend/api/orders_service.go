@@ -85,10 +85,15 @@ func CancelOrder(p Param) (interface{}, error) {
 		return nil, NewApiError(-1, fmt.Sprintf("order %s not exist", req.ID))
 	}
 
-	if order.Status != common.ORDER_PENDING {
+	if order.Status != common.ORDER_PENDING && order.Status != models.ORDER_UNTRIGGERED {
 		return nil, nil
 	}
 
+	err := checkMarketTakesCancels(order.MarketID)
+	if err != nil {
+		return nil, err
+	}
+
 	cancelOrderEvent := common.CancelOrderEvent{
 		Event: common.Event{
 			Type:     common.EventCancelOrder,
@@ -102,10 +107,76 @@ func CancelOrder(p Param) (interface{}, error) {
 	return nil, QueueService.Push([]byte(utils.ToJsonString(cancelOrderEvent)))
 }
 
+// CancelOrders sends one bulk cancel event for each market the orders are in,
+// the engine cancels the orders of an event together.
+func CancelOrders(p Param) (interface{}, error) {
+	req := p.(*CancelOrdersReq)
+
+	var orders []*models.Order
+	if len(req.IDs) > 0 {
+		orders = models.OrderDao.FindByIDs(req.IDs)
+	} else {
+		orders = models.OrderDao.FindOpenOrdersByAccount(req.Address, req.MarketID, req.Side)
+	}
+
+	var marketIDs []string
+	marketOrderIDs := make(map[string][]string)
+
+	for _, order := range orders {
+		if order.TraderAddress != req.Address || (order.Status != common.ORDER_PENDING && order.Status != models.ORDER_UNTRIGGERED) {
+			continue
+		}
+
+		if (req.MarketID != "" && order.MarketID != req.MarketID) || (req.Side != "" && order.Side != req.Side) {
+			continue
+		}
+
+		if _, ok := marketOrderIDs[order.MarketID]; !ok {
+			marketIDs = append(marketIDs, order.MarketID)
+		}
+
+		marketOrderIDs[order.MarketID] = append(marketOrderIDs[order.MarketID], order.ID)
+	}
+
+	for _, marketID := range marketIDs {
+		err := checkMarketTakesCancels(marketID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, marketID := range marketIDs {
+		bulkCancelOrdersEvent := models.BulkCancelOrdersEvent{
+			Event: common.Event{
+				Type:     models.EventBulkCancelOrders,
+				MarketID: marketID,
+			},
+			IDs: marketOrderIDs[marketID],
+		}
+
+		err := QueueService.Push([]byte(utils.ToJsonString(bulkCancelOrdersEvent)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
 func BuildOrder(p Param) (interface{}, error) {
 	utils.Debugf("BuildOrder param %v", p)
 
 	req := p.(*BuildOrderReq)
+
+	repriced := false
+	if req.IsMakerOnly && req.PostOnlyReprice {
+		var err error
+		repriced, err = repricePostOnlyOrder(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err := checkBalanceAllowancePriceAndAmount(req, req.Address)
 	if err != nil {
 		return nil, err
@@ -116,13 +187,133 @@ func BuildOrder(p Param) (interface{}, error) {
 		return nil, err
 	}
 
+	buildOrderResponse.Repriced = repriced
+
 	return map[string]interface{}{
 		"order": buildOrderResponse,
 	}, nil
 }
 
 func PlaceOrder(p Param) (interface{}, error) {
-	order := p.(*PlaceOrderReq)
+	ret, err := newOrderFromCache(p.(*PlaceOrderReq))
+	if err != nil {
+		return nil, err
+	}
+
+	newOrderEvent, _ := json.Marshal(common.NewOrderEvent{
+		Event: common.Event{
+			MarketID: ret.MarketID,
+			Type:     common.EventNewOrder,
+		},
+		Order: utils.ToJsonString(ret),
+	})
+
+	err = QueueService.Push(newOrderEvent)
+
+	if err != nil {
+		return nil, errors.New("place order failed, place try again")
+	} else {
+		return nil, nil
+	}
+}
+
+// ReplaceOrder places an order built and signed like any other order, and cancels the replaced order in the same engine event.
+func ReplaceOrder(p Param) (interface{}, error) {
+	req := p.(*ReplaceOrderReq)
+
+	replacedOrder := models.OrderDao.FindByID(req.ReplacedOrderID)
+	if replacedOrder == nil || replacedOrder.TraderAddress != req.Address {
+		return nil, NewApiError(-1, fmt.Sprintf("order %s not exist", req.ReplacedOrderID))
+	}
+
+	if replacedOrder.Status != common.ORDER_PENDING && replacedOrder.Status != models.ORDER_UNTRIGGERED {
+		return nil, NewApiError(-1, "replaced_order_is_not_open")
+	}
+
+	ret, err := newOrderFromCache(&req.PlaceOrderReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret.MarketID != replacedOrder.MarketID {
+		return nil, NewApiError(-1, "replacing_order_must_be_in_the_same_market")
+	}
+
+	replaceOrderEvent := models.ReplaceOrderEvent{
+		Event: common.Event{
+			MarketID: ret.MarketID,
+			Type:     models.EventReplaceOrder,
+		},
+		ReplacedOrderID: replacedOrder.ID,
+		Order:           utils.ToJsonString(ret),
+	}
+
+	err = QueueService.Push([]byte(utils.ToJsonString(replaceOrderEvent)))
+	if err != nil {
+		return nil, errors.New("replace order failed, place try again")
+	}
+
+	return nil, nil
+}
+
+// AmendOrder lowers the amount left open of an order, the order keeps its place in the book.
+// The signed amount can't change, so the amount taken from the order is canceled.
+func AmendOrder(p Param) (interface{}, error) {
+	req := p.(*AmendOrderReq)
+
+	order := models.OrderDao.FindByID(req.ID)
+	if order == nil || order.TraderAddress != req.Address {
+		return nil, NewApiError(-1, fmt.Sprintf("order %s not exist", req.ID))
+	}
+
+	if order.Status != common.ORDER_PENDING && order.Status != models.ORDER_UNTRIGGERED {
+		return nil, NewApiError(-1, "order_is_not_open")
+	}
+
+	err := checkMarketTakesCancels(order.MarketID)
+	if err != nil {
+		return nil, err
+	}
+
+	availableAmount, err := decimal.NewFromString(req.AvailableAmount)
+	if err != nil {
+		return nil, NewApiError(-1, "invalid_amount_or_unit")
+	}
+
+	market := models.MarketDao.FindMarketByID(order.MarketID)
+	minAmountUnit := decimal.New(1, int32(-1*market.AmountDecimals))
+	if availableAmount.LessThanOrEqual(decimal.Zero) || !availableAmount.Mod(minAmountUnit).Equal(decimal.Zero) {
+		return nil, NewApiError(-1, "invalid_amount_or_unit")
+	}
+
+	if availableAmount.GreaterThanOrEqual(order.AvailableAmount) {
+		return nil, NewApiError(-1, "amend_can_only_lower_the_amount")
+	}
+
+	amendOrderEvent := models.AmendOrderEvent{
+		Event: common.Event{
+			MarketID: order.MarketID,
+			Type:     models.EventAmendOrder,
+		},
+		ID:              order.ID,
+		AvailableAmount: availableAmount.String(),
+	}
+
+	return nil, QueueService.Push([]byte(utils.ToJsonString(amendOrderEvent)))
+}
+
+// checkMarketTakesCancels returns an error if traders can't cancel their orders in the market, the book is frozen while it is halted.
+func checkMarketTakesCancels(marketID string) error {
+	market := models.MarketDao.FindMarketByID(marketID)
+	if market != nil && market.TradingState == models.MARKET_STATE_HALTED {
+		return NewApiError(-1, "market_halted")
+	}
+
+	return nil
+}
+
+// newOrderFromCache checks the signature of a built order and returns it as a new order.
+func newOrderFromCache(order *PlaceOrderReq) (*models.Order, error) {
 	if valid := hydro.IsValidOrderSignature(order.Address, order.ID, order.Signature); !valid {
 		utils.Infof("valid is %v", valid)
 		return nil, errors.New("bad signature")
@@ -136,43 +327,39 @@ func PlaceOrder(p Param) (interface{}, error) {
 
 	cacheOrder.OrderResponse.Json.Signature = order.Signature
 
-	ret := models.Order{
-		ID:              order.ID,
-		TraderAddress:   order.Address,
-		MarketID:        cacheOrder.OrderResponse.MarketID,
-		Side:            cacheOrder.OrderResponse.Side,
-		Price:           cacheOrder.OrderResponse.Price,
-		Amount:          cacheOrder.OrderResponse.Amount,
-		Status:          common.ORDER_PENDING,
-		Type:            cacheOrder.OrderResponse.Type,
-		Version:         "hydro-v1",
-		AvailableAmount: cacheOrder.OrderResponse.Amount,
-		ConfirmedAmount: decimal.Zero,
-		CanceledAmount:  decimal.Zero,
-		PendingAmount:   decimal.Zero,
-		MakerFeeRate:    cacheOrder.OrderResponse.AsMakerFeeRate,
-		TakerFeeRate:    cacheOrder.OrderResponse.AsTakerFeeRate,
-		MakerRebateRate: cacheOrder.OrderResponse.MakerRebateRate,
-		GasFeeAmount:    cacheOrder.OrderResponse.GasFeeAmount,
-		JSON:            utils.ToJsonString(cacheOrder.OrderResponse.Json),
-		CreatedAt:       time.Now().UTC(),
+	// stop orders wait in the engine until they are triggered
+	status := common.ORDER_PENDING
+	if isStopOrder(cacheOrder.OrderResponse.Type) {
+		status = models.ORDER_UNTRIGGERED
 	}
 
-	newOrderEvent, _ := json.Marshal(common.NewOrderEvent{
-		Event: common.Event{
-			MarketID: cacheOrder.OrderResponse.MarketID,
-			Type:     common.EventNewOrder,
-		},
-		Order: utils.ToJsonString(ret),
-	})
-
-	err := QueueService.Push(newOrderEvent)
-
-	if err != nil {
-		return nil, errors.New("place order failed, place try again")
-	} else {
-		return nil, nil
+	ret := models.Order{
+		ID:                  order.ID,
+		TraderAddress:       order.Address,
+		MarketID:            cacheOrder.OrderResponse.MarketID,
+		Side:                cacheOrder.OrderResponse.Side,
+		Price:               cacheOrder.OrderResponse.Price,
+		Amount:              cacheOrder.OrderResponse.Amount,
+		Status:              status,
+		Type:                cacheOrder.OrderResponse.Type,
+		Version:             "hydro-v1",
+		AvailableAmount:     cacheOrder.OrderResponse.Amount,
+		ConfirmedAmount:     decimal.Zero,
+		CanceledAmount:      decimal.Zero,
+		PendingAmount:       decimal.Zero,
+		MakerFeeRate:        cacheOrder.OrderResponse.AsMakerFeeRate,
+		TakerFeeRate:        cacheOrder.OrderResponse.AsTakerFeeRate,
+		MakerRebateRate:     cacheOrder.OrderResponse.MakerRebateRate,
+		GasFeeAmount:        cacheOrder.OrderResponse.GasFeeAmount,
+		StopPrice:           cacheOrder.OrderResponse.StopPrice,
+		TimeInForce:         cacheOrder.OrderResponse.TimeInForce,
+		SelfTradePrevention: cacheOrder.OrderResponse.SelfTradePrevention,
+		DisplayAmount:       cacheOrder.OrderResponse.DisplayAmount,
+		JSON:                utils.ToJsonString(cacheOrder.OrderResponse.Json),
+		CreatedAt:           time.Now().UTC(),
 	}
+
+	return &ret, nil
 }
 
 func getCacheOrderByOrderID(orderID string) *CacheOrder {
@@ -200,6 +387,22 @@ func checkBalanceAllowancePriceAndAmount(order *BuildOrderReq, address string) e
 		return MarketNotFoundError(order.MarketID)
 	}
 
+	// the engine checks the state again, it may change before the order is placed
+	switch market.TradingState {
+	case models.MARKET_STATE_HALTED:
+		return NewApiError(-1, "market_halted")
+	case models.MARKET_STATE_CANCEL_ONLY:
+		return NewApiError(-1, "market_cancel_only")
+	case models.MARKET_STATE_POST_ONLY:
+		if !order.IsMakerOnly {
+			return NewApiError(-1, "market_post_only")
+		}
+	case models.MARKET_STATE_CALL_AUCTION:
+		if order.OrderType == "market" || order.IsMakerOnly || order.TimeInForce == models.TIME_IN_FORCE_IOC || order.TimeInForce == models.TIME_IN_FORCE_FOK {
+			return NewApiError(-1, "market_call_auction_takes_resting_limit_orders")
+		}
+	}
+
 	// price and amount are already decimal.Decimal at this point in the original code
 	// but they are parsed from order.Price and order.Amount (strings)
 	// For clarity, let's ensure we have them as decimals here.
@@ -222,6 +425,45 @@ func checkBalanceAllowancePriceAndAmount(order *BuildOrderReq, address string) e
 		return NewApiError(-1, "order_less_than_minOrderSize")
 	}
 
+	if order.IsMakerOnly && order.OrderType != "limit" {
+		return NewApiError(-1, "post_only_requires_limit_order")
+	}
+
+	switch order.TimeInForce {
+	case models.TIME_IN_FORCE_IOC, models.TIME_IN_FORCE_FOK:
+		if order.OrderType != "limit" || order.IsMakerOnly {
+			return NewApiError(-1, "immediate_time_in_force_requires_taker_limit_order")
+		}
+	case models.TIME_IN_FORCE_GTT:
+		if order.Expires <= 0 {
+			return NewApiError(-1, "good_til_time_requires_expires")
+		}
+	}
+
+	if order.DisplayAmount != "" {
+		displayAmount, err := decimal.NewFromString(order.DisplayAmount)
+		if err != nil || displayAmount.LessThanOrEqual(decimal.Zero) || !displayAmount.Mod(minAmountUnit).Equal(decimal.Zero) || displayAmount.GreaterThanOrEqual(amount) {
+			return NewApiError(-1, "invalid_display_amount_or_unit")
+		}
+
+		if displayAmount.Mul(price).LessThan(market.MinOrderSize) {
+			return NewApiError(-1, "display_amount_less_than_minOrderSize")
+		}
+
+		if order.OrderType != "limit" || order.TimeInForce == models.TIME_IN_FORCE_IOC || order.TimeInForce == models.TIME_IN_FORCE_FOK {
+			return NewApiError(-1, "iceberg_requires_resting_limit_order")
+		}
+	}
+
+	if isStopOrder(order.OrderType) {
+		stopPrice, err := decimal.NewFromString(order.StopPrice)
+		if err != nil || stopPrice.LessThanOrEqual(decimal.Zero) || !stopPrice.Mod(minPriceUnit).Equal(decimal.Zero) {
+			return NewApiError(-1, "invalid_stop_price_or_unit")
+		}
+	} else if err := checkPriceBand(market, price); err != nil {
+		return err
+	}
+
 	if order.AccountType == "margin" {
 		utils.Dump(fmt.Sprintf("Performing MARGIN balance check for user %s, market %s (side %s)", address, order.MarketID, order.Side))
 
@@ -405,16 +647,16 @@ func BuildAndCacheOrder(address string, order *BuildOrderReq) (*BuildOrderResp,
 
 		orderDataHex, err = sw.GenerateMarginOrderDataHex(
 			int64(2), // Version
-			getExpiredAt(order.Expires),
+			getOrderExpiredAt(order),
 			rand.Int63(), // Salt
 			market.MakerFeeRate,
 			market.TakerFeeRate,
 			decimal.Zero, // MakerRebateRate
 			order.Side == "sell",
-			order.OrderType == "market",
+			isMarketOrder(order),
 			balanceCategory,
 			orderDataMarketIDUint16,
-			false, // isMakerOnly
+			order.IsMakerOnly,
 		)
 		if err != nil {
 			utils.Errorf("Failed to generate margin order data: %v", err)
@@ -430,14 +672,14 @@ func BuildAndCacheOrder(address string, order *BuildOrderReq) (*BuildOrderResp,
 		// Spot orders use the existing SDK's GenerateOrderData
 		orderDataBytes := hydro.GenerateOrderData(
 			int64(2), // Version
-			getExpiredAt(order.Expires),
+			getOrderExpiredAt(order),
 			rand.Int63(), // Salt
 			market.MakerFeeRate,
 			market.TakerFeeRate,
 			decimal.Zero, // MakerRebateRate
 			order.Side == "sell",
-			order.OrderType == "market",
-			false, // isMakerOnly
+			isMarketOrder(order),
+			order.IsMakerOnly,
 		)
 		orderDataHex = goEthereumCommon.Bytes2Hex(orderDataBytes)
 	}
@@ -484,7 +726,7 @@ func BuildAndCacheOrder(address string, order *BuildOrderReq) (*BuildOrderResp,
 		market.TakerFeeRate,
 		decimal.Zero,
 		order.Side == "sell",
-		order.OrderType == "market",
+		isMarketOrder(order),
 		false)
 
 	orderJson := models.OrderJSON{
@@ -511,17 +753,22 @@ func BuildAndCacheOrder(address string, order *BuildOrderReq) (*BuildOrderResp,
 
 	orderHash := hydro.GetOrderHash(sdkOrder)
 	orderResponse := BuildOrderResp{
-		ID:              utils.Bytes2HexP(orderHash),
-		Json:            &orderJson,
-		Side:            order.Side,
-		Type:            order.OrderType,
-		Price:           price,
-		Amount:          amount,
-		MarketID:        order.MarketID,
-		AsMakerFeeRate:  market.MakerFeeRate,
-		AsTakerFeeRate:  market.TakerFeeRate,
-		MakerRebateRate: makerRebateRate,
-		GasFeeAmount:    gasFeeInQuoteToken,
+		ID:                  utils.Bytes2HexP(orderHash),
+		Json:                &orderJson,
+		Side:                order.Side,
+		Type:                order.OrderType,
+		Price:               price,
+		Amount:              amount,
+		StopPrice:           getStopPrice(order),
+		MarketID:            order.MarketID,
+		AsMakerFeeRate:      market.MakerFeeRate,
+		AsTakerFeeRate:      market.TakerFeeRate,
+		MakerRebateRate:     makerRebateRate,
+		GasFeeAmount:        gasFeeInQuoteToken,
+		IsMakerOnly:         order.IsMakerOnly,
+		TimeInForce:         getTimeInForce(order),
+		SelfTradePrevention: getSelfTradePrevention(order, market),
+		DisplayAmount:       getDisplayAmount(order),
 	}
 
 	cacheOrder := CacheOrder{
@@ -547,10 +794,151 @@ func getExpiredAt(expiresInSeconds int64) int64 {
 	}
 }
 
+// getOrderExpiredAt returns the expiry signed in the order, good-til-time orders expire exactly after Expires seconds.
+func getOrderExpiredAt(order *BuildOrderReq) int64 {
+	if order.TimeInForce == models.TIME_IN_FORCE_GTT {
+		return time.Now().Unix() + order.Expires
+	}
+
+	return getExpiredAt(order.Expires)
+}
+
+func getTimeInForce(order *BuildOrderReq) string {
+	if order.TimeInForce == "" {
+		return models.TIME_IN_FORCE_GTC
+	}
+
+	return order.TimeInForce
+}
+
+// checkPriceBand returns an error if price is further from the reference price of the market than its price band.
+// Stop orders are not checked, they are placed away from the market on purpose.
+// There is no band before the first trade of a market which is measured from the last trade.
+func checkPriceBand(market *models.Market, price decimal.Decimal) error {
+	if !market.PriceBand.IsPositive() {
+		return nil
+	}
+
+	reference, err := getPriceBandReference(market)
+	if err != nil {
+		utils.Errorf("get price band reference of market %s failed: %v", market.ID, err)
+		return NewApiError(-1, "price_band_reference_unavailable")
+	}
+
+	if !reference.IsPositive() {
+		return nil
+	}
+
+	low := reference.Mul(decimal.New(1, 0).Sub(market.PriceBand))
+	high := reference.Mul(decimal.New(1, 0).Add(market.PriceBand))
+
+	if price.LessThan(low) || price.GreaterThan(high) {
+		return NewApiError(-1, fmt.Sprintf("price_out_of_band, price must be between %s and %s",
+			low.StringFixed(int32(market.PriceDecimals)),
+			high.StringFixed(int32(market.PriceDecimals))))
+	}
+
+	return nil
+}
+
+// getPriceBandReference returns the price the price band of the market is measured from,
+// it is zero if the market has no trade yet.
+func getPriceBandReference(market *models.Market) (decimal.Decimal, error) {
+	if market.PriceBandReference == models.PRICE_BAND_REFERENCE_ORACLE {
+		return sw.GetOraclePriceInQuote(hydro, goEthereumCommon.HexToAddress(market.BaseTokenAddress), goEthereumCommon.HexToAddress(market.QuoteTokenAddress))
+	}
+
+	lastTrade := models.TradeDao.FindLastTrade(market.ID)
+	if lastTrade == nil {
+		return decimal.Zero, nil
+	}
+
+	return lastTrade.Price, nil
+}
+
+// getDisplayAmount returns the shown part of an iceberg order, it is zero for other orders.
+func getDisplayAmount(order *BuildOrderReq) decimal.Decimal {
+	if order.DisplayAmount == "" {
+		return decimal.Zero
+	}
+
+	return utils.StringToDecimal(order.DisplayAmount)
+}
+
+// getSelfTradePrevention returns the mode chosen by the order, or the mode of the market if the order has none.
+// The mode is saved in the order, so changing the market later doesn't change orders which are already placed.
+func getSelfTradePrevention(order *BuildOrderReq, market *models.Market) string {
+	if order.SelfTradePrevention == "" {
+		return market.SelfTradePrevention
+	}
+
+	return order.SelfTradePrevention
+}
+
 func isMarketBuyOrder(order *BuildOrderReq) bool {
-	return order.OrderType == "market" && order.Side == "buy"
+	return isMarketOrder(order) && order.Side == "buy"
 }
 
+// isMarketOrder returns true for market orders and for stop orders which become market orders when they are triggered.
 func isMarketOrder(order *BuildOrderReq) bool {
-	return order.OrderType == "market"
+	return order.OrderType == "market" || order.OrderType == models.ORDER_TYPE_STOP_MARKET
+}
+
+func isStopOrder(orderType string) bool {
+	return orderType == models.ORDER_TYPE_STOP_LIMIT || orderType == models.ORDER_TYPE_STOP_MARKET
+}
+
+// repricePostOnlyOrder moves the price of a post-only order which would match the published book
+// one price unit behind the best opposite price. The price is signed, so this is done before the order is built.
+func repricePostOnlyOrder(order *BuildOrderReq) (bool, error) {
+	market := models.MarketDao.FindMarketByID(order.MarketID)
+	if market == nil {
+		return false, MarketNotFoundError(order.MarketID)
+	}
+
+	price, err := decimal.NewFromString(order.Price)
+	if err != nil {
+		return false, NewApiError(-1, "invalid_price_or_unit")
+	}
+
+	res, err := GetOrderBook(&OrderBookReq{MarketID: order.MarketID})
+	if err != nil {
+		return false, err
+	}
+
+	snapshot := res.(map[string]interface{})["orderBook"].(SnapshotV2)
+	minPriceUnit := decimal.New(1, int32(-1*market.PriceDecimals))
+
+	if order.Side == "buy" && len(snapshot.Asks) > 0 {
+		bestAsk := utils.StringToDecimal(snapshot.Asks[0][0])
+		if price.LessThan(bestAsk) {
+			return false, nil
+		}
+
+		price = bestAsk.Sub(minPriceUnit)
+	} else if order.Side == "sell" && len(snapshot.Bids) > 0 {
+		bestBid := utils.StringToDecimal(snapshot.Bids[0][0])
+		if price.GreaterThan(bestBid) {
+			return false, nil
+		}
+
+		price = bestBid.Add(minPriceUnit)
+	} else {
+		return false, nil
+	}
+
+	if price.LessThanOrEqual(decimal.Zero) {
+		return false, NewApiError(-1, "post_only_order_cannot_be_repriced")
+	}
+
+	order.Price = price.String()
+	return true, nil
+}
+
+func getStopPrice(order *BuildOrderReq) decimal.Decimal {
+	if !isStopOrder(order.OrderType) {
+		return decimal.Zero
+	}
+
+	return utils.StringToDecimal(order.StopPrice)
 }
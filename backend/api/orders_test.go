@@ -16,6 +16,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 type BuildOrderTestSuit struct {
@@ -122,10 +123,7 @@ func (suite *BuildOrderTestSuit) request(body interface{}) *Response {
 	req := httptest.NewRequest(http.MethodPost, "/orders/build", strings.NewReader(string(bts)))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 
-	address := "0x5409ed021d9299bf6814279a6a1411a7e866a631"
-	signatrue := "0xdcd19ecc53c51bc1c8c67183d9ed8a2c68bb3717b7bbbd39da969960feeb95d45f79ead1d476c5cb1f2ebf77b76a87abee2bf5643a235125a85428d3ef4926b700"
-	message := "HYDRO-AUTHENTICATION"
-	req.Header.Set("Hydro-Authentication", address+"#"+message+"#"+signatrue)
+	req.Header.Set("Hydro-Authentication", hydroAuthentication(time.Now()))
 	rec := httptest.NewRecorder()
 	e.ServeHTTP(rec, req)
 
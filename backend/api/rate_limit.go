@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/labstack/echo"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var RateLimiter connection.RateLimiter
+
+// rateLimit is the token bucket of a group of routes, each client may send Burst requests at once
+// and Rate requests per second after that.
+type rateLimit struct {
+	Group string
+	Rate  float64
+	Burst int
+}
+
+// newRateLimit reads the limit of the group from env, which looks like {rate},{burst}. A rate of 0 turns the limit off.
+func newRateLimit(group, env string, defaultRate float64, defaultBurst int) *rateLimit {
+	limit := &rateLimit{Group: group, Rate: defaultRate, Burst: defaultBurst}
+
+	value := os.Getenv(env)
+	if value == "" {
+		return limit
+	}
+
+	parts := strings.Split(value, ",")
+	rate, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		panic(fmt.Sprintf("%s should be like {rate},{burst}: %s", env, value))
+	}
+
+	limit.Rate = rate
+	limit.Burst = int(math.Max(1, math.Ceil(rate)))
+
+	if len(parts) > 1 {
+		burst, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || burst < 1 {
+			panic(fmt.Sprintf("%s should be like {rate},{burst}: %s", env, value))
+		}
+
+		limit.Burst = burst
+	}
+
+	return limit
+}
+
+// rateLimitMiddleware limits the requests of each client to the routes of the group. Clients are told apart by
+// their api key or their address if the request is authenticated, and by their IP otherwise, so it must come after
+// the authentication of the route. The state of the buckets is shared by the api servers through redis.
+// Every response has the X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers,
+// refused requests get a 429 with a Retry-After header.
+func rateLimitMiddleware(limit *rateLimit) echo.MiddlewareFunc {
+	return limitRequests(limit, rateLimitClient)
+}
+
+// ipRateLimitMiddleware limits the requests of each IP to the routes of the group whether they are authenticated
+// or not. It comes before the authentication of the route, so that failed authentications are limited too.
+func ipRateLimitMiddleware(limit *rateLimit) echo.MiddlewareFunc {
+	return limitRequests(limit, func(cc *HydroApiContext) string {
+		return "ip:" + clientIP(cc.Request())
+	})
+}
+
+func limitRequests(limit *rateLimit, client func(cc *HydroApiContext) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if RateLimiter == nil || limit.Rate <= 0 {
+				return next(c)
+			}
+
+			cc := c.(*HydroApiContext)
+			key := fmt.Sprintf("HYDRO_RATE_LIMIT:%s:%s", limit.Group, client(cc))
+
+			result, err := RateLimiter.Take(key, limit.Rate, limit.Burst, time.Now())
+			if err != nil {
+				// requests are still served if the buckets can't be reached
+				utils.Errorf("rate limit %s failed: %v", key, err)
+				return next(c)
+			}
+
+			header := cc.Response().Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+			if !result.Allowed {
+				retryAfter := ceilSeconds(result.RetryAfter)
+				header.Set("Retry-After", strconv.Itoa(retryAfter))
+				return RateLimitedError(limit.Group, retryAfter)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func rateLimitClient(cc *HydroApiContext) string {
+	if cc.Address == "" {
+		return "ip:" + clientIP(cc.Request())
+	}
+
+	if cc.ApiKeyID != 0 {
+		return fmt.Sprintf("key:%d", cc.ApiKeyID)
+	}
+
+	return "address:" + cc.Address
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rateLimiter := RateLimiter
+	RateLimiter = connection.NewMemory().RateLimiter()
+	defer func() { RateLimiter = rateLimiter }()
+
+	limit := rateLimitMiddleware(&rateLimit{Group: "orders", Rate: 1, Burst: 2})
+
+	send := func(ip, address string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/orders/build", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+
+		cc := &HydroApiContext{echo.New().NewContext(req, rec), address, 0}
+		return rec, limit(func(c echo.Context) error { return nil })(cc)
+	}
+
+	rec, err := send("10.0.0.1", "")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.EqualValues(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+
+	_, err = send("10.0.0.1", "")
+	assert.Nil(t, err)
+
+	rec, err = send("10.0.0.1", "")
+	assert.EqualValues(t, RateLimitedCode, err.(*ApiError).Code)
+	assert.EqualValues(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.EqualValues(t, "1", rec.Header().Get("Retry-After"))
+
+	// authenticated clients are limited by address, not by IP
+	_, err = send("10.0.0.1", "0x5409ed021d9299bf6814279a6a1411a7e866a631")
+	assert.Nil(t, err)
+
+	_, err = send("10.0.0.2", "")
+	assert.Nil(t, err)
+}
+
+func TestIPRateLimitMiddleware(t *testing.T) {
+	rateLimiter := RateLimiter
+	RateLimiter = connection.NewMemory().RateLimiter()
+	defer func() { RateLimiter = rateLimiter }()
+
+	limit := ipRateLimitMiddleware(&rateLimit{Group: "auth", Rate: 1, Burst: 1})
+	failedAuth := func(c echo.Context) error { return &ApiError{Code: -11, Desc: "invalid api key"} }
+
+	send := func(ip, forwardedFor string) error {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+
+		cc := &HydroApiContext{echo.New().NewContext(req, httptest.NewRecorder()), "", 0}
+		return limit(failedAuth)(cc)
+	}
+
+	assert.EqualValues(t, -11, send("10.0.0.1", "10.0.0.3").(*ApiError).Code)
+
+	// failed authentications are limited too, and a spoofed header doesn't get around the limit
+	assert.EqualValues(t, RateLimitedCode, send("10.0.0.1", "10.0.0.3").(*ApiError).Code)
+	assert.EqualValues(t, RateLimitedCode, send("10.0.0.1", "10.0.0.4").(*ApiError).Code)
+
+	assert.EqualValues(t, -11, send("10.0.0.2", "").(*ApiError).Code)
+}
+
+func TestNewRateLimit(t *testing.T) {
+	limit := newRateLimit("public", "HSK_RATE_LIMIT_TEST", 20, 40)
+	assert.EqualValues(t, 20, limit.Rate)
+	assert.EqualValues(t, 40, limit.Burst)
+
+	_ = os.Setenv("HSK_RATE_LIMIT_TEST", "2.5,5")
+	limit = newRateLimit("public", "HSK_RATE_LIMIT_TEST", 20, 40)
+	assert.EqualValues(t, 2.5, limit.Rate)
+	assert.EqualValues(t, 5, limit.Burst)
+
+	// the burst is the rate if it is not given
+	_ = os.Setenv("HSK_RATE_LIMIT_TEST", "3")
+	limit = newRateLimit("public", "HSK_RATE_LIMIT_TEST", 20, 40)
+	assert.EqualValues(t, 3, limit.Burst)
+
+	_ = os.Unsetenv("HSK_RATE_LIMIT_TEST")
+}
@@ -18,45 +18,65 @@ import (
 	"time"
 )
 
-var CacheService common.IKVStore
+var CacheService connection.KVStore
 var QueueService common.IQueue
 
 func loadRoutes(e *echo.Echo) {
 	e.Use(initHydroApiContext)
 
+	// the rate limit of a route comes after its authentication, clients are told apart by their address or api key.
+	// Authenticated routes are also limited by IP before their authentication, so failed authentications are limited too.
+	authLimit := ipRateLimitMiddleware(newRateLimit("auth", "HSK_RATE_LIMIT_AUTH", 20, 40))
+	publicLimit := rateLimitMiddleware(newRateLimit("public", "HSK_RATE_LIMIT_PUBLIC", 20, 40))
+	privateLimit := rateLimitMiddleware(newRateLimit("private", "HSK_RATE_LIMIT_PRIVATE", 10, 20))
+	ordersLimit := rateLimitMiddleware(newRateLimit("orders", "HSK_RATE_LIMIT_ORDERS", 5, 10))
+
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Hello, World!")
 	})
 
-	addRoute(e, "GET", "/markets", nil, GetMarkets)
-	addRoute(e, "GET", "/markets/:marketID/orderbook", &OrderBookReq{}, GetOrderBook)
-	addRoute(e, "GET", "/markets/:marketID/trades", &QueryTradeReq{}, GetAllTrades)
-
-	addRoute(e, "GET", "/markets/:marketID/trades/mine", &QueryTradeReq{}, GetAccountTrades, authMiddleware)
-	addRoute(e, "GET", "/markets/:marketID/candles", &CandlesReq{}, GetTradingView)
-	addRoute(e, "GET", "/fees", &FeesReq{}, GetFees)
-
-	addRoute(e, "GET", "/orders", &QueryOrderReq{}, GetOrders, authMiddleware)
-	addRoute(e, "GET", "/orders/:orderID", &QuerySingleOrderReq{}, GetSingleOrder, authMiddleware)
-	addRoute(e, "POST", "/orders/build", &BuildOrderReq{}, BuildOrder, authMiddleware)
-	addRoute(e, "POST", "/orders", &PlaceOrderReq{}, PlaceOrder, authMiddleware)
-	addRoute(e, "DELETE", "/orders/:orderID", &CancelOrderReq{}, CancelOrder, authMiddleware)
-	addRoute(e, "GET", "/account/lockedBalances", &LockedBalanceReq{}, GetLockedBalance, authMiddleware)
+	addRoute(e, "GET", "/markets", nil, GetMarkets, publicLimit)
+	addRoute(e, "GET", "/markets/:marketID/orderbook", &OrderBookReq{}, GetOrderBook, publicLimit)
+	addRoute(e, "GET", "/markets/:marketID/trades", &QueryTradeReq{}, GetAllTrades, publicLimit)
+
+	addRoute(e, "GET", "/markets/:marketID/trades/mine", &QueryTradeReq{}, GetAccountTrades, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_READ), privateLimit)
+	addRoute(e, "GET", "/markets/:marketID/candles", &CandlesReq{}, GetTradingView, publicLimit)
+	addRoute(e, "GET", "/fees", &FeesReq{}, GetFees, publicLimit)
+
+	addRoute(e, "GET", "/sessions/nonce", nil, GetSessionNonce, publicLimit)
+	addRoute(e, "POST", "/sessions", &CreateSessionReq{}, CreateSession, publicLimit)
+	addRoute(e, "DELETE", "/sessions", &DeleteSessionReq{}, DeleteSession, publicLimit)
+
+	// bots authenticate with api keys, placed orders are still signed by the wallet
+	addRoute(e, "GET", "/orders", &QueryOrderReq{}, GetOrders, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_READ), privateLimit)
+	addRoute(e, "GET", "/orders/:orderID", &QuerySingleOrderReq{}, GetSingleOrder, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_READ), privateLimit)
+	addRoute(e, "POST", "/orders/build", &BuildOrderReq{}, BuildOrder, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_TRADE), ordersLimit)
+	addRoute(e, "POST", "/orders", &PlaceOrderReq{}, PlaceOrder, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_TRADE), ordersLimit)
+	addRoute(e, "PUT", "/orders/:orderID", &AmendOrderReq{}, AmendOrder, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_TRADE), ordersLimit)
+	addRoute(e, "POST", "/orders/replace", &ReplaceOrderReq{}, ReplaceOrder, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_TRADE), ordersLimit)
+	addRoute(e, "DELETE", "/orders", &CancelOrdersReq{}, CancelOrders, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_CANCEL), ordersLimit)
+	addRoute(e, "DELETE", "/orders/:orderID", &CancelOrderReq{}, CancelOrder, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_CANCEL), ordersLimit)
+	addRoute(e, "GET", "/account/lockedBalances", &LockedBalanceReq{}, GetLockedBalance, authLimit, apiKeyMiddleware(models.API_KEY_SCOPE_READ), privateLimit)
+
+	// api keys are managed by the wallet
+	addRoute(e, "GET", "/apiKeys", &ApiKeysReq{}, GetApiKeys, authLimit, authMiddleware, privateLimit)
+	addRoute(e, "POST", "/apiKeys", &CreateApiKeyReq{}, CreateApiKey, authLimit, authMiddleware, privateLimit)
+	addRoute(e, "DELETE", "/apiKeys/:keyID", &DeleteApiKeyReq{}, DeleteApiKey, authLimit, authMiddleware, privateLimit)
 
 	// Margin Account Routes
-	addRoute(e, "GET", "/margin/accounts/:marketID", &MarginAccountDetailsReq{}, GetMarginAccountDetails, authMiddleware)
-	addRoute(e, "POST", "/margin/collateral/deposit", &CollateralManagementReq{}, DepositToCollateral, authMiddleware)
-	addRoute(e, "POST", "/margin/collateral/withdraw", &CollateralManagementReq{}, WithdrawFromCollateral, authMiddleware)
+	addRoute(e, "GET", "/margin/accounts/:marketID", &MarginAccountDetailsReq{}, GetMarginAccountDetails, authLimit, authMiddleware, privateLimit)
+	addRoute(e, "POST", "/margin/collateral/deposit", &CollateralManagementReq{}, DepositToCollateral, authLimit, authMiddleware, ordersLimit)
+	addRoute(e, "POST", "/margin/collateral/withdraw", &CollateralManagementReq{}, WithdrawFromCollateral, authLimit, authMiddleware, ordersLimit)
 
 	// Loan Management Routes
-	addRoute(e, "POST", "/margin/loans/borrow", &CollateralManagementReq{}, BorrowLoan, authMiddleware) // Reusing CollateralManagementReq for borrow
-	addRoute(e, "POST", "/margin/loans/repay", &CollateralManagementReq{}, RepayLoan, authMiddleware)    // Reusing CollateralManagementReq for repay
-	addRoute(e, "GET", "/margin/loans", &LoanListReq{}, GetLoans, authMiddleware)
+	addRoute(e, "POST", "/margin/loans/borrow", &CollateralManagementReq{}, BorrowLoan, authLimit, authMiddleware, ordersLimit) // Reusing CollateralManagementReq for borrow
+	addRoute(e, "POST", "/margin/loans/repay", &CollateralManagementReq{}, RepayLoan, authLimit, authMiddleware, ordersLimit)    // Reusing CollateralManagementReq for repay
+	addRoute(e, "GET", "/margin/loans", &LoanListReq{}, GetLoans, authLimit, authMiddleware, privateLimit)
 
 	// Margin Position Routes
-	addRoute(e, "GET", "/v1/margin/positions", &EmptyReq{}, GetUserMarginPositions, authMiddleware) // New route for listing positions
-	addRoute(e, "POST", "/v1/margin/positions/open", &OpenMarginPositionReq{}, OpenMarginPosition, authMiddleware)
-	addRoute(e, "POST", "/v1/margin/positions/close", &CloseMarginPositionReq{}, CloseMarginPosition, authMiddleware)
+	addRoute(e, "GET", "/v1/margin/positions", &EmptyReq{}, GetUserMarginPositions, authLimit, authMiddleware, privateLimit) // New route for listing positions
+	addRoute(e, "POST", "/v1/margin/positions/open", &OpenMarginPositionReq{}, OpenMarginPosition, authLimit, authMiddleware, ordersLimit)
+	addRoute(e, "POST", "/v1/margin/positions/close", &CloseMarginPositionReq{}, CloseMarginPosition, authLimit, authMiddleware, ordersLimit)
 }
 
 func addRoute(e *echo.Echo, method, url string, param Param, handler func(p Param) (interface{}, error), middlewares ...echo.MiddlewareFunc) {
@@ -98,9 +118,14 @@ func errorHandler(err error, c echo.Context) {
 		desc = "something wrong"
 	}
 
+	httpStatus := http.StatusOK
+	if status == RateLimitedCode {
+		httpStatus = http.StatusTooManyRequests
+	}
+
 	// Send response
 	if !c.Response().Committed {
-		err = c.JSON(http.StatusOK, Response{
+		err = c.JSON(httpStatus, Response{
 			Status: status,
 			Desc:   desc,
 		})
@@ -132,8 +157,9 @@ func getEchoServer() *echo.Echo {
 	// }))
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, "Jwt-Authentication", "Hydro-Authentication"},
+		AllowOrigins:  []string{"*"},
+		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "Jwt-Authentication", "Hydro-Authentication", "Hydro-Api-Key", "Hydro-Api-Nonce", "Hydro-Api-Signature"},
+		ExposeHeaders: []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
 	}))
 
 	loadRoutes(e)
@@ -144,8 +170,8 @@ func getEchoServer() *echo.Echo {
 var hydro sdk.Hydro
 
 func StartServer(ctx context.Context, startMetric func()) {
-	// init redis
-	redisClient := connection.NewRedisClient(os.Getenv("HSK_REDIS_URL"))
+	// init redis, or the memory of the process if HSK_REDIS_URL is connection.MemoryURL
+	backend := connection.NewBackend(ctx, os.Getenv("HSK_REDIS_URL"))
 
 	// init blockchain
 	hydro = ethereum.NewEthereumHydro(os.Getenv("HSK_BLOCKCHAIN_RPC_URL"), os.Getenv("HSK_HYBRID_EXCHANGE_ADDRESS"))
@@ -160,20 +186,9 @@ func StartServer(ctx context.Context, startMetric func()) {
 	//init database
 	models.Connect(os.Getenv("HSK_DATABASE_URL"))
 
-	CacheService, _ = common.InitKVStore(
-		&common.RedisKVStoreConfig{
-			Ctx:    ctx,
-			Client: redisClient,
-		},
-	)
-
-	QueueService, _ = common.InitQueue(
-		&common.RedisQueueConfig{
-			Name:   common.HYDRO_ENGINE_EVENTS_QUEUE_KEY,
-			Ctx:    ctx,
-			Client: redisClient,
-		},
-	)
+	CacheService, _ = backend.KVStore()
+	QueueService, _ = backend.Queue(common.HYDRO_ENGINE_EVENTS_QUEUE_KEY)
+	RateLimiter, _ = backend.RateLimiter()
 
 	e := getEchoServer()
 
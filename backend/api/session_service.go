@@ -0,0 +1,281 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultAuthDomain is the domain sign in messages are accepted for, the web app of the local setup.
+const defaultAuthDomain = "localhost:3000"
+
+// defaultSessionTTL is the longest a session lasts in seconds, the signed message may ask for less.
+const defaultSessionTTL = 3600
+
+// sessionNonceTTL is how long a nonce may be used to sign in after it was issued.
+const sessionNonceTTL = 10 * time.Minute
+
+const siweHeaderSuffix = " wants you to sign in with your Ethereum account:"
+
+// siweMessage holds the fields of a Sign-In with Ethereum message (EIP-4361) which are checked at sign in.
+type siweMessage struct {
+	Domain         string
+	Address        string
+	URI            string
+	Version        string
+	ChainID        string
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime time.Time
+	NotBefore      time.Time
+}
+
+// parseSiweMessage reads a message like
+//
+//	{domain} wants you to sign in with your Ethereum account:
+//	{address}
+//
+//	{statement}
+//
+//	URI: {uri}
+//	Version: 1
+//	Chain ID: {chain id}
+//	Nonce: {nonce}
+//	Issued At: {time}
+//	Expiration Time: {time}
+//
+// The statement is optional, Not Before, Request ID and Resources may follow the fields above.
+// The expiration time is required here, sessions don't outlive the message which opened them.
+func parseSiweMessage(message string) (*siweMessage, error) {
+	lines := strings.Split(message, "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], siweHeaderSuffix) {
+		return nil, fmt.Errorf("message should start with {domain}%s", siweHeaderSuffix)
+	}
+
+	msg := &siweMessage{
+		Domain:  strings.TrimSuffix(lines[0], siweHeaderSuffix),
+		Address: lines[1],
+	}
+
+	if msg.Domain == "" || len(msg.Address) != 42 || !strings.HasPrefix(msg.Address, "0x") {
+		return nil, fmt.Errorf("message should name the domain and the address which signs in")
+	}
+
+	fields := map[string]*string{
+		"URI":      &msg.URI,
+		"Version":  &msg.Version,
+		"Chain ID": &msg.ChainID,
+		"Nonce":    &msg.Nonce,
+	}
+
+	times := map[string]*time.Time{
+		"Issued At":       &msg.IssuedAt,
+		"Expiration Time": &msg.ExpirationTime,
+		"Not Before":      &msg.NotBefore,
+	}
+
+	seen := make(map[string]bool)
+
+	for _, line := range lines[2:] {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name, value := parts[0], parts[1]
+
+		field, isField := fields[name]
+		fieldTime, isTime := times[name]
+		if !isField && !isTime {
+			continue
+		}
+
+		// a field given twice could hide behind the statement
+		if seen[name] {
+			return nil, fmt.Errorf("message has more than one %s", name)
+		}
+		seen[name] = true
+
+		if isField {
+			*field = value
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s of message should be a RFC 3339 time", name)
+		}
+		*fieldTime = t
+	}
+
+	if msg.Version != "1" {
+		return nil, fmt.Errorf("message version should be 1")
+	}
+
+	if msg.Nonce == "" || msg.IssuedAt.IsZero() || msg.ExpirationTime.IsZero() {
+		return nil, fmt.Errorf("message should have a nonce, an issued at and an expiration time")
+	}
+
+	return msg, nil
+}
+
+// checkSiweMessage checks that the message is for this exchange and may be used to sign in at now.
+func checkSiweMessage(msg *siweMessage, now time.Time) error {
+	if !isAuthDomain(msg.Domain) {
+		return fmt.Errorf("message is for domain %s, please sign in on the exchange", msg.Domain)
+	}
+
+	if chainID := os.Getenv("HSK_CHAIN_ID"); chainID != "" && msg.ChainID != chainID {
+		return fmt.Errorf("message is for chain %s, the exchange runs on chain %s", msg.ChainID, chainID)
+	}
+
+	skew := time.Duration(utils.ParseInt(os.Getenv("HSK_AUTH_CLOCK_SKEW"), defaultAuthClockSkew)) * time.Second
+
+	if msg.IssuedAt.After(now.Add(skew)) || msg.NotBefore.After(now.Add(skew)) {
+		return fmt.Errorf("message is not valid yet")
+	}
+
+	if !msg.ExpirationTime.After(now) {
+		return fmt.Errorf("message is expired")
+	}
+
+	return nil
+}
+
+// isAuthDomain returns true if sign in messages for the domain are accepted, HSK_AUTH_DOMAIN lists them separated by commas.
+func isAuthDomain(domain string) bool {
+	domains := os.Getenv("HSK_AUTH_DOMAIN")
+	if domains == "" {
+		domains = defaultAuthDomain
+	}
+
+	for _, d := range strings.Split(domains, ",") {
+		if strings.TrimSpace(d) == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+func GetSessionNonce(p Param) (interface{}, error) {
+	nonce, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CacheService.Set(generateSessionNonceKey(nonce), nonce, sessionNonceTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionNonceResp{
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(sessionNonceTTL).Unix(),
+	}, nil
+}
+
+// CreateSession signs in with a Sign-In with Ethereum message. The nonce of the message must be issued by
+// GetSessionNonce and is used up by the sign in. The session ends at the expiration time of the message,
+// or after HSK_SESSION_TTL seconds if that is earlier.
+func CreateSession(p Param) (interface{}, error) {
+	req := p.(*CreateSessionReq)
+	now := time.Now()
+
+	msg, err := parseSiweMessage(req.Message)
+	if err != nil {
+		return nil, NewApiError(-11, err.Error())
+	}
+
+	if err := checkSiweMessage(msg, now); err != nil {
+		return nil, NewApiError(-11, err.Error())
+	}
+
+	if _, err := CacheService.Get(generateSessionNonceKey(msg.Nonce)); err != nil {
+		return nil, NewApiError(-11, "nonce is unknown or expired, please request a new one")
+	}
+
+	valid, err := hydro.IsValidSignature(msg.Address, req.Message, req.Signature)
+	if !valid || err != nil {
+		return nil, NewApiError(-11, "signature valid failed, please sign the message again")
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(time.Duration(utils.ParseInt(os.Getenv("HSK_SESSION_TTL"), defaultSessionTTL)) * time.Second)
+	if msg.ExpirationTime.Before(expiresAt) {
+		expiresAt = msg.ExpirationTime
+	}
+
+	session := &models.Session{
+		Address:   strings.ToLower(msg.Address),
+		TokenHash: hashToken(token),
+		Nonce:     msg.Nonce,
+		Domain:    msg.Domain,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	// the nonce is unique in sessions, a message which signed in before is refused here
+	if err := models.SessionDao.InsertSession(session); err == models.ErrSessionNonceUsed {
+		return nil, NewApiError(-11, "nonce is already used, please request a new one")
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the nonce is used up
+	if err := CacheService.Delete(generateSessionNonceKey(msg.Nonce)); err != nil {
+		utils.Errorf("delete session nonce %s failed: %v", msg.Nonce, err)
+	}
+
+	return &CreateSessionResp{
+		Token:     token,
+		Address:   session.Address,
+		ExpiresAt: session.ExpiresAt.Unix(),
+	}, nil
+}
+
+// DeleteSession signs out. The token proves the session is the caller's, with All set every session
+// of the address of the token is revoked.
+func DeleteSession(p Param) (interface{}, error) {
+	req := p.(*DeleteSessionReq)
+	now := time.Now()
+
+	session := models.SessionDao.FindActiveSession(hashToken(req.Token), now)
+	if session == nil {
+		return nil, NewApiError(-11, "session is expired or revoked")
+	}
+
+	if req.All {
+		return nil, models.SessionDao.RevokeAddressSessions(session.Address, now)
+	}
+
+	return nil, models.SessionDao.RevokeSession(session.ID, now)
+}
+
+func generateSessionNonceKey(nonce string) string {
+	return "SessionNonce:" + nonce
+}
+
+// hashToken returns the hash kept in place of a session token or an api key.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return utils.Bytes2Hex(hash[:])
+}
+
+func randomHex(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return utils.Bytes2Hex(bytes), nil
+}
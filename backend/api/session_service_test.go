@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func siweTestMessage(domain string, issuedAt, expiresAt time.Time) string {
+	return strings.Join([]string{
+		domain + " wants you to sign in with your Ethereum account:",
+		"0x5409ed021d9299bf6814279a6a1411a7e866a631",
+		"",
+		"Sign in to trade on the exchange.",
+		"",
+		"URI: http://" + domain,
+		"Version: 1",
+		"Chain ID: 50",
+		"Nonce: 4f1b2c3d4e5f60718293a4b5c6d7e8f9",
+		"Issued At: " + issuedAt.UTC().Format(time.RFC3339),
+		"Expiration Time: " + expiresAt.UTC().Format(time.RFC3339),
+	}, "\n")
+}
+
+func TestParseSiweMessage(t *testing.T) {
+	setEnvs()
+	now := time.Now().Truncate(time.Second)
+
+	msg, err := parseSiweMessage(siweTestMessage("localhost:3000", now, now.Add(time.Hour)))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "localhost:3000", msg.Domain)
+	assert.EqualValues(t, "0x5409ed021d9299bf6814279a6a1411a7e866a631", msg.Address)
+	assert.EqualValues(t, "50", msg.ChainID)
+	assert.EqualValues(t, "4f1b2c3d4e5f60718293a4b5c6d7e8f9", msg.Nonce)
+	assert.True(t, msg.IssuedAt.Equal(now))
+	assert.True(t, msg.ExpirationTime.Equal(now.Add(time.Hour)))
+	assert.Nil(t, checkSiweMessage(msg, now))
+
+	// messages for other sites, or which are expired, don't sign in
+	msg, _ = parseSiweMessage(siweTestMessage("phishing.example", now, now.Add(time.Hour)))
+	assert.NotNil(t, checkSiweMessage(msg, now))
+
+	msg, _ = parseSiweMessage(siweTestMessage("localhost:3000", now.Add(-time.Hour), now.Add(-time.Minute)))
+	assert.NotNil(t, checkSiweMessage(msg, now))
+
+	msg, _ = parseSiweMessage(siweTestMessage("localhost:3000", now.Add(time.Hour), now.Add(2*time.Hour)))
+	assert.NotNil(t, checkSiweMessage(msg, now))
+
+	_ = os.Setenv("HSK_AUTH_DOMAIN", "dex.example, localhost:3000")
+	msg, _ = parseSiweMessage(siweTestMessage("dex.example", now, now.Add(time.Hour)))
+	assert.Nil(t, checkSiweMessage(msg, now))
+	_ = os.Unsetenv("HSK_AUTH_DOMAIN")
+
+	// the expiration time is required, and a field can't be given twice
+	_, err = parseSiweMessage(strings.Replace(siweTestMessage("localhost:3000", now, now.Add(time.Hour)), "Expiration Time", "Expires", 1))
+	assert.NotNil(t, err)
+
+	_, err = parseSiweMessage(strings.Replace(siweTestMessage("localhost:3000", now, now.Add(time.Hour)), "Sign in to trade on the exchange.", "Nonce: 1", 1))
+	assert.NotNil(t, err)
+
+	_, err = parseSiweMessage("HYDRO-AUTHENTICATION")
+	assert.NotNil(t, err)
+}
+
+func TestCheckAuthenticationTime(t *testing.T) {
+	now := time.Now()
+	at := func(t time.Time) string {
+		return fmt.Sprintf("HYDRO-AUTHENTICATION@%d", t.UnixNano()/int64(time.Millisecond))
+	}
+
+	assert.Nil(t, checkAuthenticationTime(at(now), now))
+	assert.Nil(t, checkAuthenticationTime(at(now.Add(-4*time.Minute)), now))
+	assert.Nil(t, checkAuthenticationTime(at(now.Add(4*time.Minute)), now))
+
+	assert.NotNil(t, checkAuthenticationTime(at(now.Add(-6*time.Minute)), now))
+	assert.NotNil(t, checkAuthenticationTime(at(now.Add(6*time.Minute)), now))
+	assert.NotNil(t, checkAuthenticationTime("HYDRO-AUTHENTICATION", now))
+	assert.NotNil(t, checkAuthenticationTime("HYDRO-AUTHENTICATION@yesterday", now))
+
+	_ = os.Setenv("HSK_AUTH_CLOCK_SKEW", "600")
+	assert.Nil(t, checkAuthenticationTime(at(now.Add(-6*time.Minute)), now))
+	_ = os.Unsetenv("HSK_AUTH_CLOCK_SKEW")
+}
+
+type sessionDaoStub struct {
+	models.ISessionDao
+	insertErr error
+}
+
+func (d *sessionDaoStub) InsertSession(session *models.Session) error {
+	return d.insertErr
+}
+
+func TestCreateSessionUsesUpNonce(t *testing.T) {
+	blockchain := &sdk.MockBlockchain{}
+	blockchain.On("IsValidSignature", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+	hydro = sdk.MockHydro{&ethereum.EthereumHydroProtocol{}, blockchain}
+
+	now := time.Now()
+	req := &CreateSessionReq{Message: siweTestMessage(defaultAuthDomain, now, now.Add(time.Hour)), Signature: "0x00"}
+	nonceKey := generateSessionNonceKey("4f1b2c3d4e5f60718293a4b5c6d7e8f9")
+
+	cache := &models.MCache{}
+	cache.On("Get", nonceKey).Return("4f1b2c3d4e5f60718293a4b5c6d7e8f9", nil)
+	cache.On("Delete", nonceKey).Return(nil)
+	CacheService = cache
+	defer func() { models.SessionDao = models.SessionDaoPG }()
+
+	models.SessionDao = &sessionDaoStub{}
+	_, err := CreateSession(req)
+	assert.Nil(t, err)
+	cache.AssertNumberOfCalls(t, "Delete", 1)
+
+	models.SessionDao = &sessionDaoStub{insertErr: models.ErrSessionNonceUsed}
+	_, err = CreateSession(req)
+	assert.EqualValues(t, -11, err.(*ApiError).Code)
+
+	// other errors of the database are not taken for a used nonce
+	dbErr := errors.New("connection refused")
+	models.SessionDao = &sessionDaoStub{insertErr: dbErr}
+	_, err = CreateSession(req)
+	assert.EqualValues(t, dbErr, err)
+	cache.AssertNumberOfCalls(t, "Delete", 1)
+}
@@ -1,67 +1,253 @@
 package api
 
 import (
+	"encoding/base64"
+	"fmt"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
 	"github.com/shopspring/decimal"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const MaxBarsCount = 200
+// MaxBarsCount is the most candles a request returns, candles are read from the candles table so it may be large.
+const MaxBarsCount = 1500
 
+const defaultTradesLimit = 20
+
+// maxTradesLimit is the most trades a page of the trade history may have.
+const maxTradesLimit = 100
+
+// GetAllTrades returns a page of the successful trades of the market, newest first.
 func GetAllTrades(p Param) (interface{}, error) {
 	req := p.(*QueryTradeReq)
-	count, trades := models.TradeDao.FindAllTrades(req.MarketID)
 
-	resp := QueryTradeResp{
-		Count:  count,
-		Trades: trades,
+	query, err := newTradeQuery(req, common.STATUS_SUCCESSFUL)
+	if err != nil {
+		return nil, err
 	}
-	return &resp, nil
+
+	return tradesPage(req, query, models.TradeDao.FindMarketTradesPage(query)), nil
 }
 
+// GetAccountTrades returns a page of the trades of the market the trader took part in, newest first.
 func GetAccountTrades(p Param) (interface{}, error) {
 	req := p.(*QueryTradeReq)
-	if req.PerPage <= 0 {
-		req.PerPage = 20
+
+	query, err := newTradeQuery(req, req.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	return tradesPage(req, query, models.TradeDao.FindAccountTradesPage(req.Address, query)), nil
+}
+
+// newTradeQuery reads the filters and cursors of the request. One trade more than the limit
+// is queried to tell if there are more trades past the page.
+func newTradeQuery(req *QueryTradeReq, status string) (*models.TradeQuery, error) {
+	if req.Limit < 0 {
+		return nil, ValidationError("limit should not be negative")
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultTradesLimit
+	}
+
+	if req.Limit > maxTradesLimit {
+		req.Limit = maxTradesLimit
+	}
+
+	if req.From > 0 && req.To > 0 && req.From >= req.To {
+		return nil, ValidationError("from should be earlier than to")
+	}
+
+	query := &models.TradeQuery{
+		MarketID: req.MarketID,
+		Status:   status,
+		Limit:    req.Limit + 1,
+	}
+
+	if req.From > 0 {
+		query.From = time.Unix(req.From, 0)
+	}
+
+	if req.To > 0 {
+		query.To = time.Unix(req.To, 0)
+	}
+
+	var err error
+
+	if query.Before, err = decodeTradeCursor(req.Before); err != nil {
+		return nil, ValidationError("before should be a cursor of a trades response")
+	}
+
+	if query.After, err = decodeTradeCursor(req.After); err != nil {
+		return nil, ValidationError("after should be a cursor of a trades response")
+	}
+
+	return query, nil
+}
+
+// tradesPage cuts the trades down to the limit and sets the cursors of the pages around it.
+func tradesPage(req *QueryTradeReq, query *models.TradeQuery, trades []*models.Trade) *QueryTradeResp {
+	more := len(trades) > req.Limit
+	if more && query.After != nil && query.Before == nil {
+		// the page was taken from the cursor up, the extra trade is the newest one
+		trades = trades[len(trades)-req.Limit:]
+	} else if more {
+		trades = trades[:req.Limit]
+	}
+
+	resp := &QueryTradeResp{Trades: trades}
+
+	if len(trades) == 0 {
+		if query.After != nil {
+			resp.After = req.After
+		}
+		return resp
+	}
+
+	resp.After = encodeTradeCursor(trades[0])
+
+	// paging up from a cursor, the trade of the cursor is older than the page
+	if more || query.After != nil {
+		resp.Before = encodeTradeCursor(trades[len(trades)-1])
 	}
-	if req.Page <= 0 {
-		req.Page = 1
+
+	return resp
+}
+
+// encodeTradeCursor returns the cursor of the trade, an opaque string of its executed_at and id.
+func encodeTradeCursor(trade *models.Trade) string {
+	micros := trade.ExecutedAt.UnixNano() / int64(time.Microsecond)
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", micros, trade.ID)))
+}
+
+// decodeTradeCursor returns nil for an empty cursor.
+func decodeTradeCursor(cursor string) (*models.TradeCursor, error) {
+	if cursor == "" {
+		return nil, nil
 	}
-	offset := req.PerPage * (req.Page - 1)
-	limit := req.PerPage
 
-	count, trades := models.TradeDao.FindAccountMarketTrades(req.Address, req.MarketID, req.Status, limit, offset)
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(string(bytes), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("cursor should be {time}:{id}")
+	}
+
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
 
-	return &QueryTradeResp{
-		Count:  count,
-		Trades: trades,
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TradeCursor{
+		ExecutedAt: time.Unix(0, micros*int64(time.Microsecond)).UTC(),
+		ID:         id,
 	}, nil
 }
 
+// GetTradingView returns the candles of the market between from and to. The range is cut to the latest MaxBarsCount
+// candles, in which case the meta of the response has truncated set and the older candles are loaded with a to
+// before the first candle. Periods without trades are filled with a candle at the close of the period before them.
 func GetTradingView(p Param) (interface{}, error) {
 	params := p.(*CandlesReq)
-	pair := params.MarketID
-	from := params.From
-	to := params.To
 	granularity := params.Granularity
 
-	if (to - granularity*MaxBarsCount) > from {
+	if !models.IsCandleResolution(granularity) {
+		return nil, ValidationError(fmt.Sprintf("granularity should be one of %v", models.CandleResolutions))
+	}
+
+	if params.From > params.To {
+		return nil, ValidationError("from should be earlier than to")
+	}
+
+	to := params.To
+	if now := time.Now().Unix(); to > now {
+		to = now
+	}
+
+	from := params.From
+	cut := (to - granularity*MaxBarsCount) > from
+	if cut {
 		from = to - granularity*MaxBarsCount
 	}
 
-	trades := models.TradeDao.FindTradesByMarket(pair, time.Unix(from, 0), time.Unix(to, 0))
+	from = models.CandleStartTime(time.Unix(from, 0), granularity)
+	to = models.CandleStartTime(time.Unix(to, 0), granularity)
 
-	if len(trades) == 0 {
+	candles := models.CandleDao.FindCandles(params.MarketID, granularity, from, to)
+	previous := models.CandleDao.FindLastCandle(params.MarketID, granularity, from)
+
+	if len(candles) == 0 && previous == nil {
+		// the market has no trades up to the range, there is nothing older to load either
 		return map[string]interface{}{
 			"candles": []*Bar{},
+			"meta":    map[string]bool{"noData": true},
 		}, nil
 	}
 
 	return map[string]interface{}{
-		"candles": BuildTradingViewByTrades(trades, granularity),
+		"candles": fillCandleGaps(candles, previous, from, to, granularity),
+		// without a candle before the range the cut part has no trades
+		"meta": map[string]bool{"truncated": cut && previous != nil},
 	}, nil
+}
+
+// fillCandleGaps returns a bar for every period from the first candle, or from from if there is a candle
+// before the range, to to. A period without trades opens and closes at the close of the period before it.
+func fillCandleGaps(candles []*models.Candle, previous *models.Candle, from, to, granularity int64) []*Bar {
+	bars := []*Bar{}
+
+	start := from
+	if previous == nil {
+		start = candles[0].StartTime
+	}
 
+	var lastClose decimal.Decimal
+	if previous != nil {
+		lastClose = previous.Close
+	}
+
+	i := 0
+	for t := start; t <= to; t += granularity {
+		if i < len(candles) && candles[i].StartTime == t {
+			candle := candles[i]
+			bars = append(bars, &Bar{
+				Time:   candle.StartTime,
+				Open:   candle.Open,
+				Close:  candle.Close,
+				Low:    candle.Low,
+				High:   candle.High,
+				Volume: candle.Volume,
+			})
+
+			lastClose = candle.Close
+			i++
+			continue
+		}
+
+		bars = append(bars, &Bar{
+			Time:   t,
+			Open:   lastClose,
+			Close:  lastClose,
+			Low:    lastClose,
+			High:   lastClose,
+			Volume: decimal.Zero,
+		})
+	}
+
+	return bars
 }
 
 func BuildTradingViewByTrades(trades []*models.Trade, granularity int64) []*Bar {
@@ -31,6 +31,136 @@ func TestBuildTradingViewByTrades(t *testing.T) {
 	fmt.Println(utils.ToJsonString(trades))
 }
 
+func TestTradeCursor(t *testing.T) {
+	trade := &models.Trade{ID: 42, ExecutedAt: time.Date(2019, 5, 1, 8, 30, 0, 123456000, time.UTC)}
+
+	cursor, err := decodeTradeCursor(encodeTradeCursor(trade))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 42, cursor.ID)
+	assert.True(t, trade.ExecutedAt.Equal(cursor.ExecutedAt))
+
+	cursor, err = decodeTradeCursor("")
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+
+	_, err = decodeTradeCursor("not a cursor")
+	assert.NotNil(t, err)
+}
+
+func TestNewTradeQuery(t *testing.T) {
+	req := &QueryTradeReq{MarketID: "WETH-DAI"}
+	query, err := newTradeQuery(req, "")
+	assert.Nil(t, err)
+	assert.EqualValues(t, defaultTradesLimit+1, query.Limit)
+
+	req = &QueryTradeReq{MarketID: "WETH-DAI", Limit: 1000}
+	query, err = newTradeQuery(req, "")
+	assert.Nil(t, err)
+	assert.EqualValues(t, maxTradesLimit+1, query.Limit)
+
+	_, err = newTradeQuery(&QueryTradeReq{MarketID: "WETH-DAI", From: 200, To: 100}, "")
+	assert.NotNil(t, err)
+
+	_, err = newTradeQuery(&QueryTradeReq{MarketID: "WETH-DAI", Before: "!"}, "")
+	assert.NotNil(t, err)
+}
+
+func TestTradesPage(t *testing.T) {
+	var trades []*models.Trade
+	for i := 3; i > 0; i-- {
+		trade := newTestTrade("0.1", "1", int64(i))
+		trade.ID = int64(i)
+		trades = append(trades, trade)
+	}
+
+	req := &QueryTradeReq{MarketID: "WETH-DAI", Limit: 2}
+	query, _ := newTradeQuery(req, "")
+	resp := tradesPage(req, query, trades)
+	assert.EqualValues(t, 2, len(resp.Trades))
+	assert.EqualValues(t, 3, resp.Trades[0].ID)
+	assert.EqualValues(t, encodeTradeCursor(trades[0]), resp.After)
+	assert.EqualValues(t, encodeTradeCursor(trades[1]), resp.Before)
+
+	// paging up from a cursor drops the newest trade
+	req = &QueryTradeReq{MarketID: "WETH-DAI", Limit: 2, After: encodeTradeCursor(newTestTrade("0.1", "1", 0))}
+	query, _ = newTradeQuery(req, "")
+	resp = tradesPage(req, query, trades)
+	assert.EqualValues(t, 2, len(resp.Trades))
+	assert.EqualValues(t, 2, resp.Trades[0].ID)
+	assert.EqualValues(t, 1, resp.Trades[1].ID)
+	assert.EqualValues(t, encodeTradeCursor(trades[2]), resp.Before)
+
+	req = &QueryTradeReq{MarketID: "WETH-DAI", Limit: 5}
+	query, _ = newTradeQuery(req, "")
+	resp = tradesPage(req, query, trades)
+	assert.EqualValues(t, 3, len(resp.Trades))
+	assert.EqualValues(t, "", resp.Before)
+}
+
+func TestFillCandleGaps(t *testing.T) {
+	candles := []*models.Candle{
+		{StartTime: 120, Open: utils.StringToDecimal("1"), Close: utils.StringToDecimal("2"), Low: utils.StringToDecimal("1"), High: utils.StringToDecimal("2"), Volume: utils.StringToDecimal("3")},
+		{StartTime: 300, Open: utils.StringToDecimal("3"), Close: utils.StringToDecimal("3"), Low: utils.StringToDecimal("3"), High: utils.StringToDecimal("3"), Volume: utils.StringToDecimal("1")},
+	}
+
+	bars := fillCandleGaps(candles, nil, 0, 360, 60)
+	assert.EqualValues(t, `[{"time":120,"open":"1","close":"2","low":"1","high":"2","volume":"3"},{"time":180,"open":"2","close":"2","low":"2","high":"2","volume":"0"},{"time":240,"open":"2","close":"2","low":"2","high":"2","volume":"0"},{"time":300,"open":"3","close":"3","low":"3","high":"3","volume":"1"},{"time":360,"open":"3","close":"3","low":"3","high":"3","volume":"0"}]`, utils.ToJsonString(bars))
+
+	previous := &models.Candle{StartTime: 0, Close: utils.StringToDecimal("0.5")}
+	bars = fillCandleGaps(candles[1:], previous, 240, 300, 60)
+	assert.EqualValues(t, `[{"time":240,"open":"0.5","close":"0.5","low":"0.5","high":"0.5","volume":"0"},{"time":300,"open":"3","close":"3","low":"3","high":"3","volume":"1"}]`, utils.ToJsonString(bars))
+
+	bars = fillCandleGaps(nil, previous, 240, 300, 60)
+	assert.EqualValues(t, 2, len(bars))
+}
+
+type candleDaoStub struct {
+	models.ICandleDao
+	candles  []*models.Candle
+	previous *models.Candle
+	from, to int64
+}
+
+func (d *candleDaoStub) FindCandles(marketID string, resolution, from, to int64) []*models.Candle {
+	d.from, d.to = from, to
+	return d.candles
+}
+
+func (d *candleDaoStub) FindLastCandle(marketID string, resolution, before int64) *models.Candle {
+	return d.previous
+}
+
+func TestGetTradingView(t *testing.T) {
+	candleDao := models.CandleDao
+	defer func() { models.CandleDao = candleDao }()
+
+	monday := time.Date(2019, 10, 14, 0, 0, 0, 0, time.UTC).Unix()
+	stub := &candleDaoStub{candles: []*models.Candle{{StartTime: monday - 604800}}}
+	models.CandleDao = stub
+
+	// weekly ranges are rounded to Mondays
+	resp, err := GetTradingView(&CandlesReq{MarketID: "WETH-DAI", From: monday - 86400, To: monday + 86400, Granularity: 604800})
+	assert.Nil(t, err)
+	assert.EqualValues(t, monday-604800, stub.from)
+	assert.EqualValues(t, monday, stub.to)
+	assert.EqualValues(t, 2, len(resp.(map[string]interface{})["candles"].([]*Bar)))
+	assert.False(t, resp.(map[string]interface{})["meta"].(map[string]bool)["truncated"])
+
+	// a range longer than MaxBarsCount is cut to its latest candles and flagged
+	stub.previous = &models.Candle{StartTime: monday - 2*604800}
+	resp, err = GetTradingView(&CandlesReq{MarketID: "WETH-DAI", From: 1, To: monday, Granularity: 60})
+	assert.Nil(t, err)
+	assert.EqualValues(t, monday-60*MaxBarsCount, stub.from)
+	assert.True(t, resp.(map[string]interface{})["meta"].(map[string]bool)["truncated"])
+
+	// there is nothing to load before the range without a candle before it
+	stub.previous = nil
+	stub.candles = []*models.Candle{{StartTime: monday}}
+	resp, err = GetTradingView(&CandlesReq{MarketID: "WETH-DAI", From: 1, To: monday, Granularity: 60})
+	assert.Nil(t, err)
+	assert.False(t, resp.(map[string]interface{})["meta"].(map[string]bool)["truncated"])
+}
+
 func newTestTrade(price, amount string, executedAt int64) *models.Trade {
 	priceDecimal, _ := decimal.NewFromString(price)
 	amountDecimal, _ := decimal.NewFromString(amount)
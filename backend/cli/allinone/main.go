@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/admin/api"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/api"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/cli"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/dex_engine"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/dex_launcher"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/dex_watcher"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/HydroProtocol/hydro-sdk-backend/websocket"
+	_ "github.com/joho/godotenv/autoload"
+	"os"
+	"sync"
+)
+
+// all-in-one runs the api, admin api, engine, websocket, launcher and watcher in one process.
+// Queues and caches are kept in the process unless HSK_REDIS_URL is set,
+// so the whole exchange can run against a local chain with only a database.
+func run() int {
+	ctx, stop := context.WithCancel(context.Background())
+	go cli.WaitExitSignal(stop)
+
+	if os.Getenv("HSK_REDIS_URL") == "" {
+		_ = os.Setenv("HSK_REDIS_URL", connection.MemoryURL)
+	}
+
+	if os.Getenv("HSK_API_URL") == "" {
+		_ = os.Setenv("HSK_API_URL", "http://localhost:3001")
+	}
+
+	models.Connect(os.Getenv("HSK_DATABASE_URL"))
+
+	var wg sync.WaitGroup
+	start := func(name string, service func()) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer utils.Infof("%s stopped", name)
+
+			service()
+		}()
+	}
+
+	// metrics are served once for the whole process
+	noMetrics := func() {}
+
+	start("engine", func() { dex_engine.Run(ctx, utils.StartMetrics) })
+	start("api", func() { api.StartServer(ctx, noMetrics) })
+	start("admin api", func() { adminapi.StartServer(ctx) })
+	start("websocket", func() { startWebsocket(ctx) })
+	start("launcher", func() { dex_launcher.Start(ctx, noMetrics) })
+	start("watcher", func() { dex_watcher.Start(ctx, noMetrics, nil) })
+
+	wg.Wait()
+	return 0
+}
+
+func startWebsocket(ctx context.Context) {
+	queue, err := connection.NewBackend(ctx, os.Getenv("HSK_REDIS_URL")).Queue(common.HYDRO_WEBSOCKET_MESSAGES_QUEUE_KEY)
+	if err != nil {
+		panic(err)
+	}
+
+	wsServer := websocket.NewWSServer(":3002", queue)
+
+	websocket.RegisterChannelCreator(
+		common.MarketChannelPrefix,
+		websocket.NewMarketChannelCreator(&websocket.DefaultHttpSnapshotFetcher{
+			ApiUrl: os.Getenv("HSK_API_URL"),
+		}),
+	)
+
+	wsServer.Start(ctx)
+}
+
+func main() {
+	os.Exit(run())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/urfave/cli"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "hydro-dex-candles"
+	app.Usage = "Rebuild the candles of markets from their trades, stop the engine while it runs"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "db",
+			Usage:  "database which holds the trades and candles",
+			EnvVar: "HSK_DATABASE_URL",
+		},
+		cli.StringFlag{
+			Name:  "market",
+			Usage: "id of the market to rebuild, rebuild every market if it's empty",
+		},
+		cli.Int64Flag{
+			Name:  "from",
+			Usage: "rebuild the candles of trades executed since this unix time, from the start of its week",
+		},
+		cli.IntFlag{
+			Name:  "batch",
+			Usage: "how many trades are read at once",
+			Value: 1000,
+		},
+	}
+	app.Action = run
+
+	err := app.Run(os.Args)
+	if err != nil {
+		utils.Errorf(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(c *cli.Context) error {
+	dbURL := c.String("db")
+	if len(dbURL) == 0 {
+		return fmt.Errorf("missing arguments, usage: hydro-dex-candles --db url [--market id] [--from time]")
+	}
+
+	if c.Int("batch") <= 0 {
+		return fmt.Errorf("batch should be positive")
+	}
+
+	models.Connect(dbURL)
+
+	var marketIDs []string
+	if marketID := c.String("market"); marketID != "" {
+		if models.MarketDao.FindMarketByID(marketID) == nil {
+			return fmt.Errorf("market %s not found", marketID)
+		}
+
+		marketIDs = append(marketIDs, marketID)
+	} else {
+		for _, market := range models.MarketDao.FindAllMarkets() {
+			marketIDs = append(marketIDs, market.ID)
+		}
+	}
+
+	from := time.Unix(c.Int64("from"), 0)
+
+	for _, marketID := range marketIDs {
+		start := time.Now()
+
+		count, err := models.BackfillCandles(marketID, from, c.Int("batch"))
+		if err != nil {
+			return fmt.Errorf("backfill candles of market %s failed after %d trades: %v", marketID, count, err)
+		}
+
+		utils.Infof("market %s candles rebuilt from %d trades in %s", marketID, count, time.Since(start))
+	}
+
+	return nil
+}
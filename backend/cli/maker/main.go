@@ -6,6 +6,8 @@ import (
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
@@ -15,6 +17,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -33,14 +36,30 @@ const pk = "0xa6553a3cbade744d6c6f63e557345402abd93e25cd1f1dba8bb0d374de2fcf4f"
 const address = "0x126aa4ef50a6e546aa5ecd1eb83c060fb780891a"
 
 func getHydroAuthenticationHeader() string {
-	message := "HYDRO-AUTHENTICATION"
+	message := fmt.Sprintf("HYDRO-AUTHENTICATION@%d", time.Now().UnixNano()/int64(time.Millisecond))
 	signature, _ := ethereum.PersonalSign([]byte(message), pk)
 	return fmt.Sprintf("%s#%s#%s", address, message, utils.Bytes2HexP(signature))
 }
 
-func setReqHeader(req *http.Request) {
+// setReqHeader authenticates the request with the api key in HSK_API_KEY and HSK_API_SECRET if they are set,
+// or with a Hydro-Authentication header signed by the private key otherwise.
+func setReqHeader(req *http.Request, body []byte) {
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Hydro-Authentication", getHydroAuthenticationHeader())
+
+	apiKey, apiSecret := os.Getenv("HSK_API_KEY"), os.Getenv("HSK_API_SECRET")
+	if apiKey == "" || apiSecret == "" {
+		req.Header.Add("Hydro-Authentication", getHydroAuthenticationHeader())
+		return
+	}
+
+	nonce := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(nonce + req.Method + req.URL.RequestURI()))
+	mac.Write(body)
+
+	req.Header.Add("Hydro-Api-Key", apiKey)
+	req.Header.Add("Hydro-Api-Nonce", nonce)
+	req.Header.Add("Hydro-Api-Signature", utils.Bytes2Hex(mac.Sum(nil)))
 }
 
 func placeOrder() {
@@ -71,7 +90,7 @@ func placeOrder() {
 	})
 
 	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/orders/build", apiURL), bytes.NewReader(body))
-	setReqHeader(req)
+	setReqHeader(req, body)
 	res, err := http.DefaultClient.Do(req)
 
 	if err != nil {
@@ -104,7 +123,7 @@ func placeOrder() {
 	})
 
 	req, _ = http.NewRequest(http.MethodPost, fmt.Sprintf("%s/orders", apiURL), bytes.NewReader(placeOrderRequestBody))
-	setReqHeader(req)
+	setReqHeader(req, placeOrderRequestBody)
 	res, err = http.DefaultClient.Do(req)
 
 	if err != nil {
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/dex_engine"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/jinzhu/gorm"
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/urfave/cli"
+)
+
+const batchSize = 1000
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "hydro-dex-replay"
+	app.Usage = "Replay the engine journal into a scratch database"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "journal-db",
+			Usage:  "database which holds the journal, markets and tokens",
+			EnvVar: "HSK_DATABASE_URL",
+		},
+		cli.StringFlag{
+			Name:  "scratch-db",
+			Usage: "database to rebuild orders and trades in, ALL ITS DATA IS DROPPED",
+		},
+		cli.StringFlag{
+			Name:  "migrations",
+			Usage: "dir of the sql migrations",
+			Value: "db/migrations",
+		},
+		cli.Int64Flag{
+			Name:  "to",
+			Usage: "id of the last event to replay, replay the whole journal if it's 0",
+		},
+	}
+	app.Action = run
+
+	err := app.Run(os.Args)
+	if err != nil {
+		utils.Errorf(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(c *cli.Context) error {
+	journalURL, scratchURL := c.String("journal-db"), c.String("scratch-db")
+	if len(journalURL) == 0 || len(scratchURL) == 0 {
+		return fmt.Errorf("missing arguments, usage: hydro-dex-replay --journal-db url --scratch-db url")
+	}
+
+	if journalURL == scratchURL {
+		return fmt.Errorf("the scratch database can't be the journal database")
+	}
+
+	journalDB, err := gorm.Open("postgres", journalURL)
+	if err != nil {
+		return err
+	}
+	defer journalDB.Close()
+
+	models.Connect(scratchURL)
+	err = models.ResetDatabase(c.String("migrations"))
+	if err != nil {
+		return err
+	}
+
+	err = copyMarkets(journalDB)
+	if err != nil {
+		return err
+	}
+
+	replayer := dex_engine.NewReplayer()
+	to := c.Int64("to")
+
+	var lastEventID int64
+	var replayed, failed int
+
+	for done := false; !done; {
+		var events []*models.EngineEvent
+		journalDB.Where("id > ?", lastEventID).Order("id asc").Limit(batchSize).Find(&events)
+
+		if len(events) == 0 {
+			break
+		}
+
+		for _, event := range events {
+			if to > 0 && event.ID > to {
+				done = true
+				break
+			}
+
+			if event.Type == common.EventConfirmTransaction {
+				err = attachTransactionHash(journalDB, event)
+				if err == nil {
+					err = attachSettlementFailure(journalDB, event)
+				}
+			}
+
+			if err == nil {
+				err = replayer.Replay(event)
+			}
+
+			if err != nil {
+				utils.Errorf("replay event %d failed: %v", event.ID, err)
+				failed++
+			}
+
+			err = nil
+			lastEventID = event.ID
+			replayed++
+		}
+	}
+
+	utils.Infof("replay done, last event: %d, replayed: %d, failed: %d", lastEventID, replayed, failed)
+
+	for _, market := range models.MarketDao.FindAllMarkets() {
+		if snapshot := replayer.Orderbook(market.ID); snapshot != nil {
+			utils.Infof("market %s sequence: %d, bids: %d, asks: %d", market.ID, snapshot.Sequence, len(snapshot.Bids), len(snapshot.Asks))
+		}
+	}
+
+	return nil
+}
+
+// copyMarkets copies the markets and tokens. Markets are replayed with the configs in the journal, the markets
+// copied here are only used for markets whose config was not journaled yet.
+func copyMarkets(journalDB *gorm.DB) error {
+	var tokens []*models.Token
+	journalDB.Find(&tokens)
+
+	for _, token := range tokens {
+		err := models.TokenDao.InsertToken(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	var markets []*models.Market
+	journalDB.Find(&markets)
+
+	for _, market := range markets {
+		err := models.MarketDao.InsertMarket(market)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attachTransactionHash gives the hash of a confirm event to the replayed transaction it settles.
+// Hashes are set by the launcher, which is not part of the journal, so the replayed transaction
+// is found by the taker order and the sequence of its first trade.
+func attachTransactionHash(journalDB *gorm.DB, event *models.EngineEvent) error {
+	var e common.ConfirmTransactionEvent
+	err := json.Unmarshal([]byte(event.Payload), &e)
+	if err != nil {
+		return err
+	}
+
+	if models.TransactionDao.FindTransactionByHash(e.Hash) != nil {
+		return nil
+	}
+
+	var journalTrade models.Trade
+	journalDB.Where("transaction_hash = ?", e.Hash).Order("sequence asc").First(&journalTrade)
+	if journalTrade.ID == 0 {
+		return fmt.Errorf("no trades of transaction %s in the journal database", e.Hash)
+	}
+
+	var trade models.Trade
+	models.DB.Where("taker_order_id = ? and sequence = ?", journalTrade.TakerOrderID, journalTrade.Sequence).First(&trade)
+	if trade.ID == 0 {
+		return fmt.Errorf("transaction %s is not replayed, taker order %s", e.Hash, journalTrade.TakerOrderID)
+	}
+
+	var launchLog models.LaunchLog
+	models.DB.Where("item_type = 'hydroTrade' and item_id = ?", trade.TransactionID).First(&launchLog)
+	if launchLog.ID == 0 {
+		return fmt.Errorf("no launch log of transaction %d", trade.TransactionID)
+	}
+
+	launchLog.Hash = sql.NullString{String: e.Hash, Valid: true}
+	return models.UpdateLaunchLogToPending(&launchLog)
+}
+
+// attachSettlementFailure gives the diagnosis of a failed settlement to the replayed transaction,
+// so the replay cancels the same orders. Events journaled before the watcher sent the diagnosis don't carry it.
+func attachSettlementFailure(journalDB *gorm.DB, event *models.EngineEvent) error {
+	var e common.ConfirmTransactionEvent
+	err := json.Unmarshal([]byte(event.Payload), &e)
+	if err != nil {
+		return err
+	}
+
+	if e.Status != common.STATUS_FAILED {
+		return nil
+	}
+
+	var journalTransaction models.Transaction
+	journalDB.Where("transaction_hash = ?", e.Hash).First(&journalTransaction)
+	if journalTransaction.FailureReason == "" {
+		return nil
+	}
+
+	transaction := models.TransactionDao.FindTransactionByHash(e.Hash)
+	if transaction == nil {
+		return fmt.Errorf("transaction %s is not replayed", e.Hash)
+	}
+
+	transaction.FailureReason = journalTransaction.FailureReason
+	transaction.FaultyOrders = journalTransaction.FaultyOrders
+	return models.TransactionDao.UpdateTransaction(transaction)
+}
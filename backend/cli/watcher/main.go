@@ -6,20 +6,18 @@ import (
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/big"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/cli"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/dex_watcher"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/messagebus"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/sdk_wrappers"
-	"github.com/HydroProtocol/hydro-sdk-backend/common"
 	"github.com/HydroProtocol/hydro-sdk-backend/sdk"
 	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
 	"github.com/HydroProtocol/hydro-sdk-backend/utils"
@@ -41,12 +39,6 @@ const RepayEventSignature = "Repay(address,uint16,address,uint256)"
 
 var marginContractABI abi.ABI
 
-type DBTransactionHandler struct {
-	eventQueue  common.IQueue
-	kvStore     common.IKVStore
-	redisClient *connection.RedisClient
-}
-
 type MarginContractEventHandler struct {
 	redisClient *connection.RedisClient
 	hydroSDK    sdk.Hydro
@@ -302,99 +294,17 @@ func (h *MarginContractEventHandler) Handle(logEntry structs.Log) {
 	}
 }
 
-func (handler DBTransactionHandler) TxHandlerFunc(txAndReceipt *structs.RemovableTxAndReceipt) {
-	tx := txAndReceipt.Tx
-	txReceipt := txAndReceipt.Receipt
-
-	launchLog := models.LaunchLogDao.FindByHash(tx.GetHash())
-	if launchLog == nil {
-		utils.Debugf("Skip useless transaction %s", tx.GetHash())
-		return
-	}
-
-	if launchLog.Status != common.STATUS_PENDING {
-		utils.Infof("LaunchLog is not pending %s, skip", launchLog.Hash.String)
-		return
-	}
-
-	txResult := txReceipt.GetResult()
-	hash := tx.GetHash()
-	transaction := models.TransactionDao.FindTransactionByID(launchLog.ItemID)
-	utils.Infof("Transaction %s txResult is %+v", tx.GetHash(), txResult)
-
-	var status string
-	if txResult {
-		status = common.STATUS_SUCCESSFUL
-	} else {
-		status = common.STATUS_FAILED
-	}
-
-	if launchLog.ItemType == "hydroApprove" {
-		launchLog.Status = status
-		errUpdate := models.LaunchLogDao.UpdateLaunchLog(launchLog)
-		if errUpdate != nil {
-			panic(errUpdate)
-		}
-		return
-	}
-
-	event := &common.ConfirmTransactionEvent{
-		Event: common.Event{
-			Type:     common.EventConfirmTransaction,
-			MarketID: transaction.MarketID,
-		},
-		Hash:      hash,
-		Status:    status,
-		Timestamp: txAndReceipt.TimeStamp,
-	}
-
-	bts, _ := json.Marshal(event)
-	errQueue := handler.eventQueue.Push(bts)
-	if errQueue != nil {
-		utils.Errorf("Push event into Queue Error: %v", errQueue)
-	}
-
-	handler.kvStore.Set(common.HYDRO_WATCHER_BLOCK_NUMBER_CACHE_KEY, strconv.FormatUint(tx.GetBlockNumber(), 10), 0)
-}
-
 func main() {
 	ctx, stop := context.WithCancel(context.Background())
 	go cli.WaitExitSignal(stop)
 
-	models.Connect(os.Getenv("HSK_DATABASE_URL"))
-	redisClient := connection.NewRedisClient(os.Getenv("HSK_REDIS_URL"))
-
-	kvStore, err := common.InitKVStore(&common.RedisKVStoreConfig{
-		Ctx:    ctx,
-		Client: redisClient,
-	})
-	if err != nil {
-		panic(fmt.Sprintf("Failed to init KVStore: %v", err))
-	}
-
-	queue, err := common.InitQueue(&common.RedisQueueConfig{
-		Name:   common.HYDRO_ENGINE_EVENTS_QUEUE_KEY,
-		Client: redisClient,
-		Ctx:    ctx,
-	})
-	if err != nil {
-		panic(err)
-	}
-
-	filter := func(tx sdk.Transaction) bool {
-		return models.LaunchLogDao.FindByHash(tx.GetHash()) != nil
-	}
-
-	dbTxHandler := DBTransactionHandler{
-		eventQueue: queue,
-		kvStore:    kvStore,
-	}
-
-	txReceiptPlugin := plugin.NewTxReceiptPluginWithFilter(dbTxHandler.TxHandlerFunc, filter)
+	dex_watcher.Start(ctx, utils.StartMetrics, registerMarginPlugin)
+}
 
+// registerMarginPlugin watches the events of the margin contract, its messages are published through redis.
+func registerMarginPlugin(watcher *nights_watch.AbstractWatcher) {
+	redisClient := connection.NewRedisClient(os.Getenv("HSK_REDIS_URL"))
 	api := os.Getenv("HSK_BLOCKCHAIN_RPC_URL")
-	watcher := nights_watch.NewHttpBasedEthWatcher(ctx, api)
-	watcher.RegisterTxReceiptPlugin(txReceiptPlugin)
 
 	var errAbi error
 	marginContractABI, errAbi = abi.JSON(strings.NewReader(MarginContractABIJsonString))
@@ -415,24 +325,4 @@ func main() {
 
 	eventPlugin := plugin.NewContractEventPluginWithFilter(marginEventHandler, marginContractAddressHex, &marginContractABI, nil)
 	watcher.RegisterContractEventPlugin(eventPlugin)
-
-	syncedBlockInCache, err := kvStore.Get(common.HYDRO_WATCHER_BLOCK_NUMBER_CACHE_KEY)
-	if err != nil && err != common.KVStoreEmpty {
-		panic(err)
-	}
-
-	var startFromBlock uint64
-	if b, convErr := strconv.Atoi(syncedBlockInCache); convErr == nil {
-		startFromBlock = uint64(b) + 1
-	} else {
-		startFromBlock = 0
-	}
-
-	go utils.StartMetrics()
-	errRun := watcher.RunTillExitFromBlock(startFromBlock)
-	if errRun != nil {
-		utils.Infof("Watcher Exit with err: %s", errRun)
-	} else {
-		utils.Infof("Watcher Exit")
-	}
 }
@@ -19,17 +19,12 @@ func main() {
 func run() int {
 	ctx, stop := context.WithCancel(context.Background())
 
-	redisClient := connection.NewRedisClient(os.Getenv("HSK_REDIS_URL"))
-	redisClient = redisClient.WithContext(ctx)
+	backend := connection.NewBackend(ctx, os.Getenv("HSK_REDIS_URL"))
 
 	go cli.WaitExitSignal(stop)
 
 	// new a source queue
-	queue, err := common.InitQueue(&common.RedisQueueConfig{
-		Name:   common.HYDRO_WEBSOCKET_MESSAGES_QUEUE_KEY,
-		Ctx:    ctx,
-		Client: redisClient,
-	})
+	queue, err := backend.Queue(common.HYDRO_WEBSOCKET_MESSAGES_QUEUE_KEY)
 
 	if err != nil {
 		panic(err)
@@ -0,0 +1,80 @@
+package connection
+
+import (
+	"context"
+
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/go-redis/redis"
+)
+
+// MemoryURL is the HSK_REDIS_URL which keeps queues and keys in the process instead of redis.
+// It is meant for running all services in one process, services in other processes can't see the data.
+const MemoryURL = "memory://"
+
+// Backend builds the queues, key value stores and rate limiters of a service.
+type Backend interface {
+	Queue(name string) (common.IQueue, error)
+	KVStore() (KVStore, error)
+	RateLimiter() (RateLimiter, error)
+}
+
+// KVStore is a common.IKVStore which can delete keys as well.
+type KVStore interface {
+	common.IKVStore
+	Delete(key string) error
+}
+
+// NewBackend returns the LocalMemory if url is MemoryURL, and redis otherwise.
+func NewBackend(ctx context.Context, url string) Backend {
+	if url == MemoryURL {
+		return &memoryBackend{ctx: ctx, memory: LocalMemory}
+	}
+
+	return &redisBackend{ctx: ctx, client: NewRedisClient(url)}
+}
+
+type redisBackend struct {
+	ctx    context.Context
+	client *redis.Client
+}
+
+func (b *redisBackend) Queue(name string) (common.IQueue, error) {
+	return common.InitQueue(&common.RedisQueueConfig{
+		Name:   name,
+		Ctx:    b.ctx,
+		Client: b.client,
+	})
+}
+
+func (b *redisBackend) KVStore() (KVStore, error) {
+	store, err := common.InitKVStore(&common.RedisKVStoreConfig{
+		Ctx:    b.ctx,
+		Client: b.client,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisKVStore{IKVStore: store, client: b.client}, nil
+}
+
+func (b *redisBackend) RateLimiter() (RateLimiter, error) {
+	return &RedisRateLimiter{client: b.client}, nil
+}
+
+type memoryBackend struct {
+	ctx    context.Context
+	memory *Memory
+}
+
+func (b *memoryBackend) Queue(name string) (common.IQueue, error) {
+	return b.memory.Queue(b.ctx, name), nil
+}
+
+func (b *memoryBackend) KVStore() (KVStore, error) {
+	return b.memory.KVStore(), nil
+}
+
+func (b *memoryBackend) RateLimiter() (RateLimiter, error) {
+	return b.memory.RateLimiter(), nil
+}
@@ -0,0 +1,216 @@
+package connection
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+)
+
+// Memory keeps queues and keys in the process, it takes the place of redis when all services run in one process.
+// Queues with the same name share their messages, like redis lists.
+type Memory struct {
+	mu      sync.Mutex
+	queues  map[string]*memoryList
+	values  map[string]memoryValue
+	buckets map[string]*memoryBucket
+}
+
+type memoryValue struct {
+	value     string
+	expiredAt time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		queues:  make(map[string]*memoryList),
+		values:  make(map[string]memoryValue),
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+// LocalMemory is the memory shared by all services of the process.
+var LocalMemory = NewMemory()
+
+// Queue returns the queue with name, Pop returns common.EXIT after ctx is canceled.
+func (m *Memory) Queue(ctx context.Context, name string) common.IQueue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list, ok := m.queues[name]
+	if !ok {
+		list = &memoryList{notify: make(chan struct{}, 1)}
+		m.queues[name] = list
+	}
+
+	return &MemoryQueue{ctx: ctx, list: list}
+}
+
+// KVStore returns the key value store of the memory.
+func (m *Memory) KVStore() KVStore {
+	return &MemoryKVStore{memory: m}
+}
+
+// RateLimiter returns the rate limiter of the memory.
+func (m *Memory) RateLimiter() RateLimiter {
+	return &MemoryRateLimiter{memory: m}
+}
+
+type memoryList struct {
+	mu       sync.Mutex
+	messages [][]byte
+	notify   chan struct{}
+}
+
+func (l *memoryList) push(data []byte) {
+	l.mu.Lock()
+	l.messages = append(l.messages, data)
+	l.mu.Unlock()
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (l *memoryList) pop() ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.messages) == 0 {
+		return nil, false
+	}
+
+	data := l.messages[0]
+	l.messages[0] = nil
+	l.messages = l.messages[1:]
+
+	// wake up the next reader if there are messages left
+	if len(l.messages) > 0 {
+		select {
+		case l.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return data, true
+}
+
+// MemoryQueue is a common.IQueue in the process, messages are popped in the order they are pushed.
+type MemoryQueue struct {
+	ctx  context.Context
+	list *memoryList
+}
+
+func (q *MemoryQueue) Push(data []byte) error {
+	q.list.push(data)
+	return nil
+}
+
+func (q *MemoryQueue) Pop() ([]byte, error) {
+	for {
+		if data, ok := q.list.pop(); ok {
+			return data, nil
+		}
+
+		select {
+		case <-q.ctx.Done():
+			return nil, common.EXIT
+		case <-q.list.notify:
+		}
+	}
+}
+
+// MemoryKVStore is a common.IKVStore in the process.
+type MemoryKVStore struct {
+	memory *Memory
+}
+
+func (s *MemoryKVStore) Set(key string, value string, expire time.Duration) error {
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+
+	v := memoryValue{value: value}
+	if expire > 0 {
+		v.expiredAt = time.Now().Add(expire)
+	}
+
+	s.memory.values[key] = v
+	return nil
+}
+
+func (s *MemoryKVStore) Get(key string) (string, error) {
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+
+	v, ok := s.memory.values[key]
+	if !ok {
+		return "", common.KVStoreEmpty
+	}
+
+	if !v.expiredAt.IsZero() && time.Now().After(v.expiredAt) {
+		delete(s.memory.values, key)
+		return "", common.KVStoreEmpty
+	}
+
+	return v.value, nil
+}
+
+func (s *MemoryKVStore) Delete(key string) error {
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+
+	delete(s.memory.values, key)
+	return nil
+}
+
+// maxMemoryBuckets is how many buckets the memory keeps before it drops the full ones.
+const maxMemoryBuckets = 10000
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// fullAt is when the bucket is full again, a full bucket and a missing one are the same
+	fullAt time.Time
+}
+
+// MemoryRateLimiter is a RateLimiter in the process.
+type MemoryRateLimiter struct {
+	memory *Memory
+}
+
+func (l *MemoryRateLimiter) Take(key string, rate float64, burst int, now time.Time) (*RateLimitResult, error) {
+	l.memory.mu.Lock()
+	defer l.memory.mu.Unlock()
+
+	if len(l.memory.buckets) >= maxMemoryBuckets {
+		for k, bucket := range l.memory.buckets {
+			if !now.Before(bucket.fullAt) {
+				delete(l.memory.buckets, k)
+			}
+		}
+	}
+
+	bucket, ok := l.memory.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(burst), updatedAt: now}
+		l.memory.buckets[key] = bucket
+	}
+
+	if now.After(bucket.updatedAt) {
+		bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate)
+		bucket.updatedAt = now
+	}
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	result := newRateLimitResult(allowed, bucket.tokens, rate, burst)
+	bucket.fullAt = now.Add(result.ResetAfter)
+
+	return result, nil
+}
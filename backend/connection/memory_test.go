@@ -0,0 +1,96 @@
+package connection
+
+import (
+	"context"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	memory := NewMemory()
+
+	producer := memory.Queue(ctx, "events")
+	consumer := memory.Queue(ctx, "events")
+	other := memory.Queue(ctx, "other")
+
+	_ = producer.Push([]byte("1"))
+	_ = producer.Push([]byte("2"))
+	_ = other.Push([]byte("3"))
+
+	data, err := consumer.Pop()
+	assert.Nil(t, err)
+	assert.EqualValues(t, "1", string(data))
+
+	data, err = consumer.Pop()
+	assert.Nil(t, err)
+	assert.EqualValues(t, "2", string(data))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = producer.Push([]byte("4"))
+	}()
+
+	data, err = consumer.Pop()
+	assert.Nil(t, err)
+	assert.EqualValues(t, "4", string(data))
+
+	cancel()
+	_, err = consumer.Pop()
+	assert.EqualValues(t, common.EXIT, err)
+}
+
+func TestMemoryKVStore(t *testing.T) {
+	store := NewMemory().KVStore()
+
+	_, err := store.Get("key")
+	assert.EqualValues(t, common.KVStoreEmpty, err)
+
+	_ = store.Set("key", "value", 0)
+	value, err := store.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value", value)
+
+	_ = store.Set("key", "expired", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, err = store.Get("key")
+	assert.EqualValues(t, common.KVStoreEmpty, err)
+
+	_ = store.Set("key", "deleted", 0)
+	assert.Nil(t, store.Delete("key"))
+	_, err = store.Get("key")
+	assert.EqualValues(t, common.KVStoreEmpty, err)
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := NewMemory().RateLimiter()
+	now := time.Now()
+
+	// the bucket starts full with 3 tokens and refills 2 tokens per second
+	for i := 2; i >= 0; i-- {
+		result, err := limiter.Take("ip:10.0.0.1", 2, 3, now)
+		assert.Nil(t, err)
+		assert.True(t, result.Allowed)
+		assert.EqualValues(t, i, result.Remaining)
+	}
+
+	result, _ := limiter.Take("ip:10.0.0.1", 2, 3, now)
+	assert.False(t, result.Allowed)
+	assert.EqualValues(t, 500*time.Millisecond, result.RetryAfter)
+	assert.EqualValues(t, 1500*time.Millisecond, result.ResetAfter)
+
+	// other keys have their own buckets
+	result, _ = limiter.Take("ip:10.0.0.2", 2, 3, now)
+	assert.True(t, result.Allowed)
+
+	result, _ = limiter.Take("ip:10.0.0.1", 2, 3, now.Add(500*time.Millisecond))
+	assert.True(t, result.Allowed)
+	assert.EqualValues(t, 0, result.Remaining)
+
+	// the bucket never holds more than the burst
+	result, _ = limiter.Take("ip:10.0.0.1", 2, 3, now.Add(time.Hour))
+	assert.True(t, result.Allowed)
+	assert.EqualValues(t, 2, result.Remaining)
+}
@@ -0,0 +1,102 @@
+package connection
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RateLimiter takes tokens from token buckets. A bucket holds burst tokens at most and refills at rate tokens
+// per second, a request is allowed if it can take a token.
+type RateLimiter interface {
+	Take(key string, rate float64, burst int, now time.Time) (*RateLimitResult, error)
+}
+
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is how many tokens are left in the bucket
+	Remaining int
+	// RetryAfter is how long until the bucket has a token again, it is zero if tokens are left
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+func newRateLimitResult(allowed bool, tokens, rate float64, burst int) *RateLimitResult {
+	result := &RateLimitResult{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(burst) - tokens) / rate * float64(time.Second)),
+	}
+
+	if tokens < 1 {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result
+}
+
+// takeTokenScript refills the bucket in KEYS[1] for the time since it was last used and takes a token if there is one.
+// The bucket expires once it would be full again, a full bucket and a missing one are the same.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(bucket[1])
+local updatedAt = tonumber(bucket[2])
+
+if tokens == nil then
+  tokens = burst
+  updatedAt = now
+end
+
+if now > updatedAt then
+  tokens = math.min(burst, tokens + (now - updatedAt) * rate / 1000)
+  updatedAt = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated_at', updatedAt)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimiter keeps the buckets in redis, services in several processes share them.
+type RedisRateLimiter struct {
+	client *redis.Client
+}
+
+func (l *RedisRateLimiter) Take(key string, rate float64, burst int, now time.Time) (*RateLimitResult, error) {
+	millis := now.UnixNano() / int64(time.Millisecond)
+
+	res, err := takeTokenScript.Run(l.client, []string{key}, rate, burst, millis).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, fmt.Errorf("unexpected rate limit result %v", res)
+	}
+
+	allowed, _ := values[0].(int64)
+	tokensStr, _ := values[1].(string)
+
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRateLimitResult(allowed == 1, tokens, rate, burst), nil
+}
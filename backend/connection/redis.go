@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
 	"github.com/go-redis/redis"
 )
 
@@ -19,3 +20,13 @@ func NewRedisClient(url string) *redis.Client {
 	client := redis.NewClient(opt)
 	return client
 }
+
+// RedisKVStore is the key value store of the sdk, with Delete.
+type RedisKVStore struct {
+	common.IKVStore
+	client *redis.Client
+}
+
+func (s *RedisKVStore) Delete(key string) error {
+	return s.client.Del(key).Err()
+}
@@ -1,6 +1,6 @@
-ALTER TABLE markets
-DROP COLUMN borrow_enable,
-DROP COLUMN liquidate_rate,
-DROP COLUMN withdraw_rate,
-DROP COLUMN auction_ratio_start,
-DROP COLUMN auction_ratio_per_block;
+ALTER TABLE IF EXISTS markets
+DROP COLUMN IF EXISTS borrow_enable,
+DROP COLUMN IF EXISTS liquidate_rate,
+DROP COLUMN IF EXISTS withdraw_rate,
+DROP COLUMN IF EXISTS auction_ratio_start,
+DROP COLUMN IF EXISTS auction_ratio_per_block;
@@ -1 +1 @@
-DROP TABLE margin_active_positions;
+DROP TABLE IF EXISTS margin_active_positions;
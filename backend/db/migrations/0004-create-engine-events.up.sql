@@ -0,0 +1,9 @@
+-- engine_events table
+create table engine_events(
+  id bigserial primary key,
+  market_id text not null,
+  type text not null,
+  payload text not null,
+  created_at timestamp
+);
+create index idx_engine_events_market_id on engine_events (market_id, id);
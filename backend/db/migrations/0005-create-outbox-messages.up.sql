@@ -0,0 +1,6 @@
+-- outbox_messages table
+create table outbox_messages(
+  id bigserial primary key,
+  payload text not null,
+  created_at timestamp
+);
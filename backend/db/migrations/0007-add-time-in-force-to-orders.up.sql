@@ -0,0 +1,2 @@
+alter table orders
+add column time_in_force text not null default 'GTC';
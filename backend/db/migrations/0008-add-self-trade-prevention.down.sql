@@ -0,0 +1,5 @@
+alter table if exists orders
+drop column if exists self_trade_prevention;
+
+alter table if exists markets
+drop column if exists self_trade_prevention;
@@ -0,0 +1,5 @@
+alter table markets
+add column self_trade_prevention text not null default '';
+
+alter table orders
+add column self_trade_prevention text not null default '';
@@ -0,0 +1,2 @@
+alter table if exists markets
+drop column if exists trading_state;
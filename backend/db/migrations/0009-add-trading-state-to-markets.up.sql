@@ -0,0 +1,2 @@
+alter table markets
+add column trading_state text not null default 'trading';
@@ -0,0 +1,8 @@
+alter table if exists markets
+drop column if exists price_band;
+
+alter table if exists markets
+drop column if exists price_band_reference;
+
+alter table if exists markets
+drop column if exists market_order_max_slippage;
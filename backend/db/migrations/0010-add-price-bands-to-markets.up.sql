@@ -0,0 +1,8 @@
+alter table markets
+add column price_band numeric(10,5) not null default 0;
+
+alter table markets
+add column price_band_reference text not null default 'last_trade';
+
+alter table markets
+add column market_order_max_slippage numeric(10,5) not null default 0.1;
@@ -0,0 +1,15 @@
+-- dead_letter_events table
+create table dead_letter_events(
+  id bigserial primary key,
+  engine_event_id bigint not null default 0,
+  market_id text not null,
+  type text not null,
+  payload text not null,
+  error text not null,
+  stack text not null default '',
+  attempts integer not null default 1,
+  status text not null,
+  created_at timestamp,
+  updated_at timestamp
+);
+create index idx_dead_letter_events_status on dead_letter_events (status, id);
@@ -0,0 +1,5 @@
+alter table if exists transactions
+drop column if exists failure_reason;
+
+alter table if exists transactions
+drop column if exists faulty_orders;
@@ -0,0 +1,5 @@
+alter table transactions
+add column failure_reason text not null default '';
+
+alter table transactions
+add column faulty_orders text not null default '';
@@ -0,0 +1,2 @@
+alter table if exists orders
+drop column if exists display_amount;
@@ -0,0 +1,2 @@
+alter table orders
+add column display_amount numeric(32,18) not null default 0;
@@ -0,0 +1,14 @@
+-- sessions table
+create table sessions(
+  id bigserial primary key,
+  address text not null,
+  token_hash text not null,
+  nonce text not null,
+  domain text not null,
+  expires_at timestamp not null,
+  revoked_at timestamp,
+  created_at timestamp
+);
+create unique index idx_sessions_token_hash on sessions (token_hash);
+create unique index idx_sessions_nonce on sessions (nonce);
+create index idx_sessions_address on sessions (address);
@@ -0,0 +1,16 @@
+-- api_keys table
+create table api_keys(
+  id bigserial primary key,
+  address text not null,
+  name text not null default '',
+  key_hash text not null,
+  key_prefix text not null,
+  scopes text not null,
+  allowed_ips text not null default '',
+  last_nonce bigint not null default 0,
+  last_used_at timestamp,
+  revoked_at timestamp,
+  created_at timestamp
+);
+create unique index idx_api_keys_key_hash on api_keys (key_hash);
+create index idx_api_keys_address on api_keys (address);
@@ -0,0 +1,7 @@
+create index concurrently if not exists idx_market_id_status_executed_at on trades (market_id, status, executed_at);
+create index concurrently if not exists idx_trades_taker on trades (taker,market_id);
+create index concurrently if not exists idx_trades_maker on trades (maker,market_id);
+
+drop index if exists idx_trades_market_id_status_executed_at_id;
+drop index if exists idx_trades_taker_market_id_executed_at_id;
+drop index if exists idx_trades_maker_market_id_executed_at_id;
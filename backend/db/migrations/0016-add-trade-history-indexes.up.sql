@@ -0,0 +1,7 @@
+create index concurrently if not exists idx_trades_market_id_status_executed_at_id on trades (market_id, status, executed_at, id);
+create index concurrently if not exists idx_trades_taker_market_id_executed_at_id on trades (taker, market_id, executed_at, id);
+create index concurrently if not exists idx_trades_maker_market_id_executed_at_id on trades (maker, market_id, executed_at, id);
+
+drop index if exists idx_market_id_status_executed_at;
+drop index if exists idx_trades_taker;
+drop index if exists idx_trades_maker;
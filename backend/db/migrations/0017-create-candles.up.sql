@@ -0,0 +1,14 @@
+-- candles table
+create table candles(
+  market_id text not null,
+  resolution integer not null,
+  start_time bigint not null,
+  open numeric(32,18) not null,
+  high numeric(32,18) not null,
+  low numeric(32,18) not null,
+  close numeric(32,18) not null,
+  volume numeric(32,18) not null,
+  trade_count integer not null default 0,
+  updated_at timestamp,
+  primary key (market_id, resolution, start_time)
+);
@@ -0,0 +1 @@
+drop table if exists market_handled_events;
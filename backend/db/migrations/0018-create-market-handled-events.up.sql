@@ -0,0 +1,6 @@
+-- market_handled_events table, the last journal event each market handled
+create table market_handled_events(
+  market_id text not null primary key,
+  event_id bigint not null,
+  updated_at timestamp
+);
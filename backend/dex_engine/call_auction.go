@@ -0,0 +1,339 @@
+package dex_engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/shopspring/decimal"
+)
+
+// WsTypeCallAuctionPrice is sent on the market channel when the indicative price of a call auction changes.
+const WsTypeCallAuctionPrice = "callAuctionPrice"
+
+// OrderRejectedMarketCallAuction is the reason sent for an order which would not rest in the book
+// while the market is in a call auction.
+const OrderRejectedMarketCallAuction = "market_call_auction_takes_resting_limit_orders"
+
+type WebsocketCallAuctionPricePayload struct {
+	Type string `json:"type"`
+	*models.CallAuctionPrice
+}
+
+// isCallAuction returns true while the market collects orders without matching them.
+func (m *MarketHandler) isCallAuction() bool {
+	return m.market.TradingState == models.MARKET_STATE_CALL_AUCTION
+}
+
+// takesOrders returns true for the trading states in which orders are checked against the book,
+// the book must not be crossed in them.
+func takesOrders(tradingState string) bool {
+	return tradingState == "" || tradingState == models.MARKET_STATE_TRADING || tradingState == models.MARKET_STATE_POST_ONLY
+}
+
+// callAuctionRejectReason returns why the order is not taken by a call auction. Only limit orders which rest
+// in the book are collected, post-only orders are rejected because the uncross may make them take liquidity.
+func callAuctionRejectReason(order *models.Order) string {
+	if isMarketOrder(order) || !restsInBook(order) || isPostOnly(order) {
+		return OrderRejectedMarketCallAuction
+	}
+
+	return ""
+}
+
+// restOrder puts the order into the book without matching it, orders collect this way during a call auction.
+func (book *orderbook) restOrder(order *common.MemoryOrder, displayAmount decimal.Decimal) (matchResult common.MatchResult) {
+	e := book.insertOrder(order, displayAmount)
+
+	msg := common.OrderBookChangeMessage(book.marketID, book.Sequence, e.Side, e.Price, e.Amount)
+	matchResult.OrderBookActivities = append(matchResult.OrderBookActivities, msg)
+
+	return
+}
+
+// callAuctionLevel is the open amount of a side of the book at a price, hidden parts of iceberg orders included.
+type callAuctionLevel struct {
+	price  decimal.Decimal
+	amount decimal.Decimal
+}
+
+// callAuctionPrice returns the price which trades the most volume if the book is uncrossed now.
+// Of the prices with the same volume it takes the one with the smallest surplus, and then the higher price
+// if buys are left over or the lower price otherwise. The volume is zero if the book is not crossed.
+func (book *orderbook) callAuctionPrice() *models.CallAuctionPrice {
+	bids, asks := book.callAuctionLevels("buy"), book.callAuctionLevels("sell")
+
+	var prices []decimal.Decimal
+	for _, level := range append(append([]*callAuctionLevel{}, bids...), asks...) {
+		prices = append(prices, level.price)
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].LessThan(prices[j])
+	})
+
+	result := &models.CallAuctionPrice{MarketID: book.marketID}
+
+	for _, price := range prices {
+		demand, supply := decimal.Zero, decimal.Zero
+
+		for _, level := range bids {
+			if level.price.GreaterThanOrEqual(price) {
+				demand = demand.Add(level.amount)
+			}
+		}
+
+		for _, level := range asks {
+			if level.price.LessThanOrEqual(price) {
+				supply = supply.Add(level.amount)
+			}
+		}
+
+		volume := decimal.Min(demand, supply)
+		if !volume.IsPositive() {
+			continue
+		}
+
+		surplus := demand.Sub(supply).Abs()
+
+		if volume.GreaterThan(result.Volume) ||
+			(volume.Equal(result.Volume) && surplus.LessThan(result.Surplus)) ||
+			(volume.Equal(result.Volume) && surplus.Equal(result.Surplus) && demand.GreaterThan(supply)) {
+			result.Price = price
+			result.Volume = volume
+			result.Surplus = surplus
+
+			switch {
+			case demand.GreaterThan(supply):
+				result.SurplusSide = "buy"
+			case supply.GreaterThan(demand):
+				result.SurplusSide = "sell"
+			default:
+				result.SurplusSide = ""
+			}
+		}
+	}
+
+	return result
+}
+
+func (book *orderbook) callAuctionLevels(side string) []*callAuctionLevel {
+	amounts := make(map[string]*callAuctionLevel)
+	var levels []*callAuctionLevel
+
+	for _, order := range book.orders {
+		if order.Side != side {
+			continue
+		}
+
+		level, ok := amounts[order.Price.String()]
+		if !ok {
+			level = &callAuctionLevel{price: order.Price}
+			amounts[order.Price.String()] = level
+			levels = append(levels, level)
+		}
+
+		level.amount = level.amount.Add(order.openAmount())
+	}
+
+	return levels
+}
+
+// crossingOrders returns the orders of side which trade at price, in price and time priority.
+func (book *orderbook) crossingOrders(side string, price decimal.Decimal) []*bookOrder {
+	var orders []*bookOrder
+	for _, order := range book.restingOrders() {
+		if order.Side != side {
+			continue
+		}
+
+		if (side == "buy" && order.Price.GreaterThanOrEqual(price)) || (side == "sell" && order.Price.LessThanOrEqual(price)) {
+			orders = append(orders, order)
+		}
+	}
+
+	// restingOrders keeps the time priority between orders of the same price
+	sort.SliceStable(orders, func(i, j int) bool {
+		if side == "buy" {
+			return orders[i].Price.GreaterThan(orders[j].Price)
+		}
+
+		return orders[i].Price.LessThan(orders[j].Price)
+	})
+
+	return orders
+}
+
+// uncross fills the crossed orders left by a call auction, all at the price of the auction and up to its volume.
+// The side with the surplus at the auction price makes, the other side takes. The orders of both sides fill
+// in price and time priority. The filled amounts leave the book and wait for settlement like matched amounts do.
+// It returns a match result for each taker, and the changes of the book.
+func (book *orderbook) uncross() (auction *models.CallAuctionPrice, results []*common.MatchResult, msgs []common.WebSocketMessage) {
+	auction = book.callAuctionPrice()
+	if !auction.Volume.IsPositive() {
+		return
+	}
+
+	takerSide, makerSide := "buy", "sell"
+	if auction.SurplusSide == "buy" {
+		takerSide, makerSide = "sell", "buy"
+	}
+
+	takers := book.crossingOrders(takerSide, auction.Price)
+	makers := book.crossingOrders(makerSide, auction.Price)
+
+	filled := make(map[string]decimal.Decimal)
+	left := auction.Volume
+	next := 0
+
+	for _, taker := range takers {
+		if !left.IsPositive() {
+			break
+		}
+
+		takerOrder := *taker.MemoryOrder
+		result := &common.MatchResult{TakerOrder: &takerOrder}
+
+		takerAmount := decimal.Min(taker.openAmount(), left)
+		filled[taker.ID] = takerAmount
+		left = left.Sub(takerAmount)
+
+		for takerAmount.IsPositive() && next < len(makers) {
+			maker := makers[next]
+
+			amount := decimal.Min(takerAmount, maker.openAmount().Sub(filled[maker.ID]))
+			filled[maker.ID] = filled[maker.ID].Add(amount)
+			takerAmount = takerAmount.Sub(amount)
+
+			makerOrder := *maker.MemoryOrder
+			item := &common.MatchItem{
+				MakerOrder:       &makerOrder,
+				MatchedAmount:    amount,
+				MakerOrderIsDone: filled[maker.ID].Equal(maker.openAmount()),
+			}
+
+			if item.MakerOrderIsDone {
+				next++
+			}
+
+			result.MatchItems = append(result.MatchItems, item)
+		}
+
+		result.TakerOrderIsDone = filled[taker.ID].Equal(taker.openAmount())
+		results = append(results, result)
+	}
+
+	for _, order := range append(takers, makers...) {
+		amount, ok := filled[order.ID]
+		if !ok {
+			continue
+		}
+
+		book.addSettling(order.MemoryOrder, amount, order.Priority, order.DisplayAmount)
+
+		var e *common.OrderbookEvent
+		if amount.Equal(order.openAmount()) {
+			e = book.takeOrder(order.ID)
+		} else {
+			e = book.reduceOrder(order, order.openAmount().Sub(amount))
+		}
+
+		if e != nil {
+			msgs = append(msgs, common.OrderBookChangeMessage(book.marketID, book.Sequence, e.Side, e.Price, e.Amount))
+		}
+	}
+
+	return
+}
+
+// saveUncross saves what the uncross filled in the sql transaction of the event: the orders, and the trades
+// at the auction price with their settlement. The contract matches one taker at a time, so every taker gets
+// its own settlement transactions, split to fit in the gas budget.
+func (m *MarketHandler) saveUncross(tx *dbTx, price decimal.Decimal, results []*common.MatchResult, msgs []common.WebSocketMessage) error {
+	for _, msg := range msgs {
+		err := tx.pushMessage(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	var orders []*models.Order
+	ordersByID := make(map[string]*models.Order)
+
+	fill := func(orderID string, amount decimal.Decimal) (*models.Order, error) {
+		order, ok := ordersByID[orderID]
+		if !ok {
+			order = tx.OrderDao.FindByID(orderID)
+			if order == nil {
+				return nil, fmt.Errorf("cannot find uncross order with id %s", orderID)
+			}
+
+			ordersByID[orderID] = order
+			orders = append(orders, order)
+		}
+
+		order.AvailableAmount = order.AvailableAmount.Sub(amount)
+		order.PendingAmount = order.PendingAmount.Add(amount)
+		return order, nil
+	}
+
+	var settlements []*MatchResultWithOrders
+
+	for _, result := range results {
+		settlement := &MatchResultWithOrders{
+			MatchResult:      result,
+			modelMakerOrders: make(map[string]*models.Order),
+			tradePrice:       price,
+		}
+
+		for _, item := range result.MatchItems {
+			maker, err := fill(item.MakerOrder.ID, item.MatchedAmount)
+			if err != nil {
+				return err
+			}
+
+			settlement.modelMakerOrders[maker.ID] = maker
+
+			settlement.modelTakerOrder, err = fill(result.TakerOrder.ID, item.MatchedAmount)
+			if err != nil {
+				return err
+			}
+		}
+
+		settlements = append(settlements, settlement)
+	}
+
+	err := UpdateOrders(tx, orders)
+	if err != nil {
+		return err
+	}
+
+	for _, settlement := range settlements {
+		_, _, err := processTransactionAndLaunchLogs(tx, settlement, m.blockGasBudget)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishCallAuctionPrice saves the indicative price of the auction in the kv store and sends it on the market channel.
+func (m *MarketHandler) publishCallAuctionPrice() {
+	auction := m.orderbook.callAuctionPrice()
+
+	err := m.kvStore.Set(models.GetMarketCallAuctionPriceKey(m.market.ID), utils.ToJsonString(auction), 0)
+	if err != nil {
+		utils.Errorf("save call auction price of market %s failed: %v", m.market.ID, err)
+	}
+
+	_ = pushMessage(&common.WebSocketMessage{
+		ChannelID: common.GetMarketChannelID(m.market.ID),
+		Payload: &WebsocketCallAuctionPricePayload{
+			Type:             WsTypeCallAuctionPrice,
+			CallAuctionPrice: auction,
+		},
+	})
+}
@@ -0,0 +1,129 @@
+package dex_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+)
+
+// addDeadLetter keeps an event which failed, so the admin can look into it and retry or discard it.
+func addDeadLetter(journalEvent *models.EngineEvent, err error, stack string) {
+	now := time.Now().UTC()
+	deadLetter := &models.DeadLetterEvent{
+		EngineEventID: journalEvent.ID,
+		MarketID:      journalEvent.MarketID,
+		Type:          journalEvent.Type,
+		Payload:       journalEvent.Payload,
+		Error:         err.Error(),
+		Stack:         stack,
+		Attempts:      1,
+		Status:        models.DEAD_LETTER_PENDING,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	saveErr := models.DeadLetterEventDao.InsertEvent(deadLetter)
+	if saveErr != nil {
+		utils.Errorf("save dead letter of event %d failed: %v", journalEvent.ID, saveErr)
+		return
+	}
+
+	utils.Infof("event %d of market %s is dead letter %d", journalEvent.ID, journalEvent.MarketID, deadLetter.ID)
+}
+
+// failDeadLetter counts a retry of the dead letter which failed, the dead letter waits for the admin again.
+func failDeadLetter(deadLetter *models.DeadLetterEvent, err error, stack string) {
+	deadLetter.Attempts++
+	deadLetter.Error = err.Error()
+	deadLetter.Stack = stack
+	deadLetter.Status = models.DEAD_LETTER_PENDING
+	deadLetter.UpdatedAt = time.Now().UTC()
+
+	saveErr := models.DeadLetterEventDao.UpdateEvent(deadLetter)
+	if saveErr != nil {
+		utils.Errorf("save dead letter %d failed: %v", deadLetter.ID, saveErr)
+	}
+}
+
+// retryDeadLetterFailed records that a retry could not be handed to a market, the retry event names the dead letter.
+func retryDeadLetterFailed(data []byte, err error) {
+	var event models.RetryDeadLetterEvent
+	_ = json.Unmarshal(data, &event)
+
+	deadLetter := models.DeadLetterEventDao.FindByID(event.DeadLetterID)
+	if deadLetter == nil {
+		utils.Errorf("cannot find dead letter with id %d", event.DeadLetterID)
+		return
+	}
+
+	failDeadLetter(deadLetter, err, "")
+}
+
+// handleRetryDeadLetter handles the event of a dead letter again. If it fails again, the attempt is counted
+// on the dead letter instead of adding another one.
+func (m *MarketHandler) handleRetryDeadLetter(event *models.RetryDeadLetterEvent) (interface{}, error) {
+	deadLetter := models.DeadLetterEventDao.FindByID(event.DeadLetterID)
+	if deadLetter == nil {
+		return nil, fmt.Errorf("cannot find dead letter with id %d", event.DeadLetterID)
+	}
+
+	if deadLetter.Status != models.DEAD_LETTER_RETRYING {
+		utils.Infof("dead letter %d is %s, retry is ignored", deadLetter.ID, deadLetter.Status)
+		return nil, nil
+	}
+
+	if deadLetter.Type == models.EventRetryDeadLetter || deadLetter.MarketID != m.market.ID {
+		failDeadLetter(deadLetter, fmt.Errorf("dead letter of market %s can't be retried in market %s", deadLetter.MarketID, m.market.ID), "")
+		return nil, nil
+	}
+
+	res, stack, err := m.safeHandleEvent(deadLetter.Payload)
+	if err != nil {
+		utils.Errorf("retry dead letter %d failed: %v", deadLetter.ID, err)
+		failDeadLetter(deadLetter, err, stack)
+		return nil, nil
+	}
+
+	deadLetter.Status = models.DEAD_LETTER_RESOLVED
+	deadLetter.UpdatedAt = time.Now().UTC()
+
+	err = models.DeadLetterEventDao.UpdateEvent(deadLetter)
+	if err != nil {
+		utils.Errorf("save dead letter %d failed: %v", deadLetter.ID, err)
+	}
+
+	return res, nil
+}
+
+// safeHandleEvent handles the event, a panic is turned into an error. The stack is returned with the error.
+func (m *MarketHandler) safeHandleEvent(eventJSON string) (res interface{}, stack string, err error) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if rcvErr, ok := rcv.(error); ok {
+				err = rcvErr
+			} else {
+				err = fmt.Errorf("%v", rcv)
+			}
+		}
+
+		if err != nil {
+			buf := make([]byte, 2048)
+			n := runtime.Stack(buf, false)
+			stack = string(buf[:n])
+		}
+	}()
+
+	var event common.Event
+	err = json.Unmarshal([]byte(eventJSON), &event)
+	if err != nil {
+		return nil, "", fmt.Errorf("unmarshal event failed: %v", err)
+	}
+
+	res, err = m.handleEvent(event, eventJSON)
+	return res, "", err
+}
@@ -0,0 +1,162 @@
+package dex_engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+)
+
+// marketEventBufferSize is the size of the channel between the queue of a market and its handler.
+const marketEventBufferSize = 256
+
+// defaultMarketEventQueueSize is how many events wait for a market in memory at most,
+// it is set by HSK_MARKET_EVENT_QUEUE_SIZE.
+const defaultMarketEventQueueSize = 10000
+
+// spilledEventsRetryInterval is how long the queue waits before it reads the journal again after a failed read.
+const spilledEventsRetryInterval = time.Second
+
+// marketEventQueue keeps the events dispatched to a market until its handler takes them.
+// Push never waits, so a slow market doesn't hold up the event loop and the other markets.
+// Once the market falls behind by the size of the queue, the events pushed to it are left out of memory instead,
+// they are journaled before they are dispatched and the queue reads them back from the journal when it catches up.
+// Events come out in the order they were pushed.
+type marketEventQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []*models.EngineEvent
+	size   int
+	closed bool
+
+	// load reads up to limit journaled events of the market after the event, oldest first
+	load func(afterID int64, limit int) []*models.EngineEvent
+
+	// set while the events pushed to the queue are left in the journal
+	spilled bool
+	// the last event taken into memory, the spilled events are read from the journal after it
+	lastQueuedID int64
+	// the last event left in the journal
+	lastSpilledID int64
+}
+
+func newMarketEventQueue(size int, load func(afterID int64, limit int) []*models.EngineEvent) *marketEventQueue {
+	if size < 1 {
+		size = 1
+	}
+
+	q := &marketEventQueue{size: size, load: load}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// newJournalEventQueue returns the queue of the market which reads the events left out of memory from the journal.
+func newJournalEventQueue(marketID string, size int) *marketEventQueue {
+	return newMarketEventQueue(size, func(afterID int64, limit int) []*models.EngineEvent {
+		return models.EngineEventDao.FindMarketEventsPage(marketID, afterID, limit)
+	})
+}
+
+func (q *marketEventQueue) push(event *models.EngineEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	if q.spilled || len(q.events) >= q.size {
+		if !q.spilled {
+			utils.Infof("market %s falls behind by %d events, they are read from the journal", event.MarketID, q.size)
+		}
+
+		q.spilled = true
+		q.lastSpilledID = event.ID
+		q.cond.Broadcast()
+		return
+	}
+
+	q.events = append(q.events, event)
+	q.lastQueuedID = event.ID
+	q.cond.Broadcast()
+}
+
+// close stops the queue from taking new events, the events already pushed to it are still popped.
+func (q *marketEventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// pop waits for the next event, it returns false once the queue is closed and empty.
+func (q *marketEventQueue) pop() (*models.EngineEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.events) == 0 && !q.spilled && !q.closed {
+			q.cond.Wait()
+		}
+
+		if len(q.events) > 0 {
+			event := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+
+			return event, true
+		}
+
+		if !q.spilled {
+			return nil, false
+		}
+
+		q.loadSpilled()
+	}
+}
+
+// loadSpilled reads the next spilled events from the journal into memory. The lock is released meanwhile,
+// so push doesn't wait on the database. Once the spilled events are all read, the queue takes events into memory again.
+func (q *marketEventQueue) loadSpilled() {
+	afterID := q.lastQueuedID
+
+	q.mu.Unlock()
+	events := q.load(afterID, q.size)
+	q.mu.Lock()
+
+	if len(events) > 0 {
+		q.events = append(q.events, events...)
+		q.lastQueuedID = events[len(events)-1].ID
+	}
+
+	if q.lastQueuedID >= q.lastSpilledID {
+		q.spilled = false
+		return
+	}
+
+	if len(events) == 0 {
+		// the spilled events are journaled before they are pushed, so the read failed
+		utils.Errorf("read spilled events after %d failed, retry in %s", afterID, spilledEventsRetryInterval)
+
+		q.mu.Unlock()
+		time.Sleep(spilledEventsRetryInterval)
+		q.mu.Lock()
+	}
+}
+
+// forward moves the events of the queue to the handler channel, and closes the channel after the queue is closed
+// and all its events are forwarded.
+func (q *marketEventQueue) forward(eventChan chan<- *models.EngineEvent) {
+	defer close(eventChan)
+
+	for {
+		event, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		eventChan <- event
+	}
+}
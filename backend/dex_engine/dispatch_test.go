@@ -0,0 +1,165 @@
+package dex_engine
+
+import (
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMarketEventQueueKeepsOrder(t *testing.T) {
+	queue := newMarketEventQueue(marketEventBufferSize*2, nil)
+	eventChan := make(chan *models.EngineEvent)
+
+	// nobody reads eventChan yet, pushing must not block
+	for i := int64(1); i <= marketEventBufferSize*2; i++ {
+		queue.push(&models.EngineEvent{ID: i})
+	}
+	queue.close()
+	queue.push(&models.EngineEvent{ID: -1})
+
+	go queue.forward(eventChan)
+
+	var ids []int64
+	for event := range eventChan {
+		ids = append(ids, event.ID)
+	}
+
+	assert.Len(t, ids, marketEventBufferSize*2)
+	for i, id := range ids {
+		assert.EqualValues(t, i+1, id)
+	}
+}
+
+// testJournal holds the events pushed to the queues of the markets, like the engine journal.
+type testJournal struct {
+	mu     sync.Mutex
+	events []*models.EngineEvent
+	reads  int
+}
+
+func (j *testJournal) append(marketID string) *models.EngineEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	event := &models.EngineEvent{ID: int64(len(j.events) + 1), MarketID: marketID}
+	j.events = append(j.events, event)
+	return event
+}
+
+func (j *testJournal) load(marketID string) func(afterID int64, limit int) []*models.EngineEvent {
+	return func(afterID int64, limit int) []*models.EngineEvent {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		j.reads++
+
+		var events []*models.EngineEvent
+		for _, event := range j.events {
+			if event.MarketID == marketID && event.ID > afterID && len(events) < limit {
+				events = append(events, event)
+			}
+		}
+
+		return events
+	}
+}
+
+func TestMarketEventQueueIsBounded(t *testing.T) {
+	journal := &testJournal{}
+	queue := newMarketEventQueue(2, journal.load("HOT-DAI"))
+
+	// nobody pops the queue, the events past its size are left in the journal
+	for i := 0; i < 5; i++ {
+		queue.push(journal.append("HOT-DAI"))
+	}
+	assert.Len(t, queue.events, 2)
+	assert.True(t, queue.spilled)
+
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		event, _ := queue.pop()
+		ids = append(ids, event.ID)
+	}
+	assert.EqualValues(t, []int64{1, 2, 3, 4, 5}, ids)
+
+	// once it caught up, events are kept in memory again
+	assert.False(t, queue.spilled)
+	reads := journal.reads
+
+	queue.push(journal.append("HOT-DAI"))
+	queue.close()
+
+	event, ok := queue.pop()
+	assert.True(t, ok)
+	assert.EqualValues(t, 6, event.ID)
+	assert.Equal(t, reads, journal.reads)
+
+	_, ok = queue.pop()
+	assert.False(t, ok)
+}
+
+func TestMarketEventQueueIsReadUpAfterClose(t *testing.T) {
+	journal := &testJournal{}
+	queue := newMarketEventQueue(1, journal.load("HOT-DAI"))
+
+	for i := 0; i < 3; i++ {
+		queue.push(journal.append("HOT-DAI"))
+	}
+
+	// the events dispatched before the stop are still handled
+	queue.close()
+	queue.push(journal.append("HOT-DAI"))
+
+	eventChan := make(chan *models.EngineEvent)
+	go queue.forward(eventChan)
+
+	var ids []int64
+	for event := range eventChan {
+		ids = append(ids, event.ID)
+	}
+
+	assert.EqualValues(t, []int64{1, 2, 3}, ids)
+}
+
+func TestFullMarketDoesNotDelayOthers(t *testing.T) {
+	journal := &testJournal{}
+	slow := &MarketHandler{events: newMarketEventQueue(1, journal.load("HOT-DAI"))}
+	fast := &MarketHandler{events: newMarketEventQueue(1, journal.load("WETH-DAI"))}
+
+	engine := &DexEngine{marketHandlerMap: map[string]*MarketHandler{"HOT-DAI": slow, "WETH-DAI": fast}}
+
+	dispatch := func(marketID string) {
+		journalEvent := journal.append(marketID)
+		engine.dispatchEvent(common.Event{Type: common.EventNewOrder, MarketID: marketID}, nil, journalEvent, nil)
+	}
+
+	dispatched := make(chan struct{})
+	go func() {
+		// nobody handles the events of HOT-DAI
+		for i := 0; i < 10; i++ {
+			dispatch("HOT-DAI")
+		}
+
+		dispatch("WETH-DAI")
+		close(dispatched)
+	}()
+
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("a full market should not hold up dispatching")
+	}
+
+	event, ok := fast.events.pop()
+	assert.True(t, ok)
+	assert.EqualValues(t, 11, event.ID)
+
+	// the events of the full market are all handled once it catches up
+	for i := int64(1); i <= 10; i++ {
+		event, _ := slow.events.pop()
+		assert.EqualValues(t, i, event.ID)
+	}
+}
@@ -7,11 +7,9 @@ import (
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
 	"github.com/HydroProtocol/hydro-sdk-backend/common"
-	"github.com/HydroProtocol/hydro-sdk-backend/engine"
 	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
 	"github.com/HydroProtocol/hydro-sdk-backend/utils"
 	"os"
-	"strings"
 	"sync"
 )
 
@@ -30,19 +28,6 @@ func (handler RedisOrderBookSnapshotHandler) Update(key string, bookSnapshot *co
 	return sync.WaitGroup{}
 }
 
-type RedisOrderBookActivitiesHandler struct {
-}
-
-func (handler RedisOrderBookActivitiesHandler) Update(webSocketMessages []common.WebSocketMessage) sync.WaitGroup {
-	for _, msg := range webSocketMessages {
-		if strings.HasPrefix(msg.ChannelID, "Market#") {
-			pushMessage(msg)
-		}
-	}
-
-	return sync.WaitGroup{}
-}
-
 type DexEngine struct {
 	// global ctx, if this ctx is canceled, queue handlers should exit in a short time.
 	ctx context.Context
@@ -54,40 +39,29 @@ type DexEngine struct {
 	// Wait for all queue handler exit gracefully
 	Wg sync.WaitGroup
 
-	HydroEngine *engine.Engine
+	// Wait for market handlers only, a restart waits on it before loading markets again
+	marketsWg sync.WaitGroup
+
+	// orderbook snapshots of all markets are saved here
+	kvStore common.IKVStore
 }
 
 func NewDexEngine(ctx context.Context) *DexEngine {
-	// init redis
-	redis := connection.NewRedisClient(os.Getenv("HSK_REDIS_URL"))
+	// init redis, or the memory of the process if HSK_REDIS_URL is connection.MemoryURL
+	backend := connection.NewBackend(ctx, os.Getenv("HSK_REDIS_URL"))
 
 	// init websocket queue
-	wsQueue, _ := common.InitQueue(
-		&common.RedisQueueConfig{
-			Name:   common.HYDRO_WEBSOCKET_MESSAGES_QUEUE_KEY,
-			Ctx:    ctx,
-			Client: redis,
-		},
-	)
+	wsQueue, _ := backend.Queue(common.HYDRO_WEBSOCKET_MESSAGES_QUEUE_KEY)
 	InitWsQueue(wsQueue)
 
 	// init event queue
-	eventQueue, _ := common.InitQueue(
-		&common.RedisQueueConfig{
-			Name:   common.HYDRO_ENGINE_EVENTS_QUEUE_KEY,
-			Client: redis,
-			Ctx:    ctx,
-		})
-
-	e := engine.NewEngine(context.Background())
+	eventQueue, _ := backend.Queue(common.HYDRO_ENGINE_EVENTS_QUEUE_KEY)
 
-	// setup handler for hydro engine
-	kvStore, _ := common.InitKVStore(&common.RedisKVStoreConfig{Ctx: ctx, Client: redis})
-	snapshotHandler := RedisOrderBookSnapshotHandler{kvStore: kvStore}
-	e.RegisterOrderBookSnapshotHandler(snapshotHandler)
+	kvStore, _ := backend.KVStore()
 
-	activityHandler := RedisOrderBookActivitiesHandler{}
-	e.RegisterOrderBookActivitiesHandler(activityHandler)
+	// messages committed before the last stop may not be relayed yet
+	relayPendingMessages()
+	go relayOutboxPeriodically(ctx)
 
 	engine := &DexEngine{
 		ctx:              ctx,
@@ -95,7 +69,7 @@ func NewDexEngine(ctx context.Context) *DexEngine {
 		marketHandlerMap: make(map[string]*MarketHandler),
 		Wg:               sync.WaitGroup{},
 
-		HydroEngine: e,
+		kvStore: kvStore,
 	}
 
 	markets := models.MarketDao.FindPublishedMarkets()
@@ -128,11 +102,16 @@ func (e *DexEngine) newMarket(marketId string) (marketHandler *MarketHandler, er
 		return
 	}
 
-	marketHandler, err = NewMarketHandler(e.ctx, market, e.HydroEngine)
+	marketHandler, err = NewMarketHandler(e.ctx, market, e.kvStore)
 	if err != nil {
 		return
 	}
 
+	marketHandler.eventQueue = e.eventQueue
+
+	// the config the market is loaded with is journaled, so a replay uses it from here on
+	e.journalMarketConfig(marketHandler)
+
 	e.marketHandlerMap[market.ID] = marketHandler
 	utils.Infof("market %s init done", marketHandler.market.ID)
 	return
@@ -153,68 +132,173 @@ func (e *DexEngine) closeMarket(marketId string) {
 
 func runMarket(e *DexEngine, marketHandler *MarketHandler) {
 	e.Wg.Add(1)
+	e.marketsWg.Add(1)
 
 	go func() {
 		defer e.Wg.Done()
+		defer e.marketsWg.Done()
 
 		utils.Infof("%s market handler is running", marketHandler.market.ID)
 		defer utils.Infof("%s market handler is stopped", marketHandler.market.ID)
 
 		marketHandler.Run()
 	}()
+
+	// events are moved to the handler in their own goroutine, so the event loop never waits on a market
+	go marketHandler.events.forward(marketHandler.eventChan)
 }
 
+// journalBatchSize is how many events are journaled in one sql transaction at most.
+const journalBatchSize = 100
+
 func (e *DexEngine) start() {
 	for i := range e.marketHandlerMap {
 		marketHandler := e.marketHandlerMap[i]
 		runMarket(e, marketHandler)
 	}
 
+	// events are popped in their own goroutine, and the events popped while a batch is journaled
+	// are journaled together in the next one
+	popped := make(chan []byte, journalBatchSize)
+
+	// the event loops are waited as well, so Wg doesn't drop to zero while markets restart
+	e.Wg.Add(2)
+
 	go func() {
+		defer e.Wg.Done()
+		e.popEvents(popped)
+	}()
+
+	go func() {
+		defer e.Wg.Done()
+
 		for {
-			select {
-			case <-e.ctx.Done():
+			batch, ok := nextEventBatch(popped)
+			if !ok {
 				for _, handler := range e.marketHandlerMap {
-					close(handler.eventChan)
+					handler.Stop()
 				}
 				return
-			default:
-				data, err := e.eventQueue.Pop()
-				if err != nil {
-					panic(err)
-				}
-				var event common.Event
-				err = json.Unmarshal(data, &event)
-				if err != nil {
-					utils.Errorf("wrong event format: %+v", err)
-					continue
-				}
-
-				switch event.Type {
-				case common.EventOpenMarket:
-					marketHandler, err := e.newMarket(event.MarketID)
-					if err == nil {
-						runMarket(e, marketHandler)
-					} else {
-						utils.Errorf(err.Error())
-					}
-					break
-				case common.EventCloseMarket:
-					e.closeMarket(event.MarketID)
-					break
-				default:
-					marketHandler, ok := e.marketHandlerMap[event.MarketID]
-					if !ok {
-						utils.Errorf("engine not support market [%s]", event.MarketID)
-					} else {
-						marketHandler.eventChan <- data
-					}
-				}
 			}
+
+			e.dispatchEvents(batch)
 		}
 	}()
 }
 
+// popEvents moves the events of the event queue to popped, and closes it once the engine is stopped.
+func (e *DexEngine) popEvents(popped chan<- []byte) {
+	defer close(popped)
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		default:
+		}
+
+		data, err := e.eventQueue.Pop()
+		if err == common.EXIT {
+			continue
+		} else if err != nil {
+			panic(err)
+		}
+
+		select {
+		case popped <- data:
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// nextEventBatch waits for an event, and takes the events popped after it as well, up to journalBatchSize.
+// It returns false once popped is closed and empty.
+func nextEventBatch(popped <-chan []byte) ([][]byte, bool) {
+	data, ok := <-popped
+	if !ok {
+		return nil, false
+	}
+
+	batch := [][]byte{data}
+
+	for len(batch) < journalBatchSize {
+		select {
+		case data, ok := <-popped:
+			if !ok {
+				return batch, true
+			}
+
+			batch = append(batch, data)
+		default:
+			return batch, true
+		}
+	}
+
+	return batch, true
+}
+
+// dispatchEvents journals the events, and then dispatches them in their order.
+func (e *DexEngine) dispatchEvents(batch [][]byte) {
+	events := make([]common.Event, len(batch))
+	formatErrs := make([]error, len(batch))
+	journalEvents := make([]*models.EngineEvent, len(batch))
+
+	for i, data := range batch {
+		formatErrs[i] = json.Unmarshal(data, &events[i])
+		journalEvents[i] = newJournalEvent(events[i], data)
+	}
+
+	if e.journalEvents(journalEvents) == nil {
+		return
+	}
+
+	for i, journalEvent := range journalEvents {
+		e.dispatchEvent(events[i], batch[i], journalEvent, formatErrs[i])
+	}
+}
+
+func (e *DexEngine) dispatchEvent(event common.Event, data []byte, journalEvent *models.EngineEvent, formatErr error) {
+	if formatErr != nil {
+		utils.Errorf("wrong event format: %+v", formatErr)
+		addDeadLetter(journalEvent, fmt.Errorf("wrong event format: %v", formatErr), "")
+		return
+	}
+
+	switch event.Type {
+	case common.EventOpenMarket:
+		marketHandler, err := e.newMarket(event.MarketID)
+		if err == nil {
+			runMarket(e, marketHandler)
+		} else {
+			utils.Errorf(err.Error())
+		}
+	case common.EventCloseMarket:
+		e.closeMarket(event.MarketID)
+	case common.EventRestartEngine:
+		var restartEvent RestartEngineEvent
+		_ = json.Unmarshal(data, &restartEvent)
+		e.restart(restartEvent.RestartID)
+	default:
+		marketHandler, ok := e.marketHandlerMap[event.MarketID]
+		if !ok {
+			err := fmt.Errorf("engine not support market [%s]", event.MarketID)
+			utils.Errorf(err.Error())
+
+			if event.Type == models.EventRetryDeadLetter {
+				retryDeadLetterFailed(data, err)
+			} else {
+				addDeadLetter(journalEvent, err, "")
+			}
+
+			// the event is done with, it is not handled when the market is loaded later
+			saveHandledEvent(journalEvent)
+		} else {
+			marketHandler.Dispatch(journalEvent)
+		}
+	}
+}
+
 var hydroProtocol = &ethereum.EthereumHydroProtocol{}
 
 func Run(ctx context.Context, startMetrics func()) {
@@ -0,0 +1,163 @@
+package dex_engine
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+)
+
+// expiryCheckInterval is how often a market looks for expired orders.
+const expiryCheckInterval = time.Second
+
+// expiryHorizon bounds the expiries a market keeps. Orders are signed with an expiry far in the future by default,
+// so only good-til-time orders and orders which expire within the horizon are scheduled. The orders are scheduled
+// again from the database when half of the horizon has passed.
+const expiryHorizon = 24 * time.Hour
+
+// minExpiriesCompaction is how many expiries a market keeps at least before it drops the ones of orders which
+// left the books, they are dropped when there are twice as many expiries as orders in the books.
+const minExpiriesCompaction = 1000
+
+type orderExpiry struct {
+	orderID   string
+	expiredAt int64
+}
+
+// expiryQueue keeps the expiry of orders, the one which expires first is at the top.
+type expiryQueue []*orderExpiry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].expiredAt < q[j].expiredAt }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(*orderExpiry)) }
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+// getOrderExpiredAt returns the expiry signed in the order data, in unix seconds. It is 0 if the order has no expiry.
+func getOrderExpiredAt(order *models.Order) int64 {
+	return int64(ethereum.GetOrderExpireTsFromOrderData(order.GetOrderJson().Data))
+}
+
+// scheduleExpiry makes the market cancel the order when its signed expiry passes,
+// the settlement of an expired order would be reverted by the exchange contract.
+func (m *MarketHandler) scheduleExpiry(order *models.Order) {
+	expiredAt := getOrderExpiredAt(order)
+	if expiredAt <= 0 {
+		return
+	}
+
+	if order.TimeInForce != models.TIME_IN_FORCE_GTT && expiredAt > m.expiriesUntil {
+		return
+	}
+
+	heap.Push(&m.expiries, &orderExpiry{orderID: order.ID, expiredAt: expiredAt})
+}
+
+// scheduleExpiries schedules all orders waiting in the books, it is used after the books are loaded.
+func (m *MarketHandler) scheduleExpiries() {
+	m.scheduleExpiriesUntil(time.Now().Add(expiryHorizon))
+}
+
+func (m *MarketHandler) scheduleExpiriesUntil(until time.Time) {
+	m.expiries = expiryQueue{}
+	m.expiriesUntil = until.Unix()
+
+	for _, order := range models.OrderDao.FindMarketPendingOrders(m.market.ID) {
+		m.scheduleExpiry(order)
+	}
+
+	for _, order := range models.OrderDao.FindMarketUntriggeredOrders(m.market.ID) {
+		m.scheduleExpiry(order)
+	}
+}
+
+// isExpired returns true if the order expired before the event which is handled now was received.
+// The journaled time is used, so events are handled the same way when they are replayed.
+func (m *MarketHandler) isExpired(order *models.Order) bool {
+	if m.eventTime.IsZero() {
+		return false
+	}
+
+	expiredAt := getOrderExpiredAt(order)
+	return expiredAt > 0 && expiredAt <= m.eventTime.Unix()
+}
+
+// expireOrders asks the engine to cancel the orders which expired before now.
+// The cancel goes through the event queue, so it is journaled like a cancel sent by the trader.
+func (m *MarketHandler) expireOrders(now time.Time) {
+	if m.eventQueue == nil {
+		return
+	}
+
+	if now.Add(expiryHorizon/2).Unix() >= m.expiriesUntil {
+		m.scheduleExpiriesUntil(now.Add(expiryHorizon))
+	} else {
+		m.compactExpiries()
+	}
+
+	for m.expiries.Len() > 0 && m.expiries[0].expiredAt <= now.Unix() {
+		expiry := heap.Pop(&m.expiries).(*orderExpiry)
+
+		bookOrder, inBook := m.orderbook.getOrder(expiry.orderID)
+		_, inStopbook := m.stopbook.getOrder(expiry.orderID)
+		if !inBook && !inStopbook {
+			continue
+		}
+
+		event := common.CancelOrderEvent{
+			Event: common.Event{
+				Type:     common.EventCancelOrder,
+				MarketID: m.market.ID,
+			},
+			ID: expiry.orderID,
+		}
+
+		if inBook {
+			event.Price = bookOrder.Price.String()
+			event.Side = bookOrder.Side
+		}
+
+		err := m.eventQueue.Push([]byte(utils.ToJsonString(event)))
+		if err != nil {
+			utils.Errorf("push expire order %s event failed: %v", expiry.orderID, err)
+			heap.Push(&m.expiries, expiry)
+			return
+		}
+
+		utils.Infof("market %s order %s expired at %d", m.market.ID, expiry.orderID, expiry.expiredAt)
+	}
+}
+
+// compactExpiries drops the expiries of orders which were filled, canceled or replaced,
+// once they outnumber the orders in the books.
+func (m *MarketHandler) compactExpiries() {
+	if m.expiries.Len() < minExpiriesCompaction || m.expiries.Len() < 2*(len(m.orderbook.orders)+len(m.stopbook.orders)) {
+		return
+	}
+
+	expiries := m.expiries[:0]
+	for _, expiry := range m.expiries {
+		_, inBook := m.orderbook.getOrder(expiry.orderID)
+		_, inStopbook := m.stopbook.getOrder(expiry.orderID)
+		if inBook || inStopbook {
+			expiries = append(expiries, expiry)
+		}
+	}
+
+	for i := len(expiries); i < len(m.expiries); i++ {
+		m.expiries[i] = nil
+	}
+
+	m.expiries = expiries
+	heap.Init(&m.expiries)
+}
@@ -0,0 +1,84 @@
+package dex_engine
+
+import (
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+)
+
+// appendJournal writes the event into the journal, the id of the entry is the sequence of the event.
+func appendJournal(event common.Event, data []byte) (*models.EngineEvent, error) {
+	journalEvent := newJournalEvent(event, data)
+
+	err := models.EngineEventDao.InsertEvent(journalEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	return journalEvent, nil
+}
+
+// appendJournalEvents writes the events into the journal in one sql transaction, in their order.
+func appendJournalEvents(journalEvents []*models.EngineEvent) error {
+	return models.RunInTransaction(func(tx *models.Tx) error {
+		for _, journalEvent := range journalEvents {
+			// the ids of a rolled back try are not used
+			journalEvent.ID = 0
+
+			err := tx.EngineEventDao.InsertEvent(journalEvent)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// journalMarketConfig appends the config of a market the engine loads to the journal.
+// It is dispatched to the market like any other event, so the market saves it as handled.
+func (e *DexEngine) journalMarketConfig(marketHandler *MarketHandler) {
+	event := models.MarketConfigEvent{
+		Event: common.Event{
+			Type:     models.EventMarketConfig,
+			MarketID: marketHandler.market.ID,
+		},
+		Market: marketHandler.market,
+	}
+
+	journalEvents := e.journalEvents([]*models.EngineEvent{newJournalEvent(event.Event, []byte(utils.ToJsonString(event)))})
+	if journalEvents != nil {
+		marketHandler.Dispatch(journalEvents[0])
+	}
+}
+
+func newJournalEvent(event common.Event, data []byte) *models.EngineEvent {
+	return &models.EngineEvent{
+		MarketID:  event.MarketID,
+		Type:      event.Type,
+		Payload:   string(data),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// journalEvents appends the events to the journal before they are dispatched.
+// The events are already popped from the queue, so it retries until the journal is written.
+// It returns nil if the engine is stopped in the meantime.
+func (e *DexEngine) journalEvents(journalEvents []*models.EngineEvent) []*models.EngineEvent {
+	for {
+		err := appendJournalEvents(journalEvents)
+		if err == nil {
+			return journalEvents
+		}
+
+		utils.Errorf("write event journal failed, retry in 1s: %v", err)
+
+		select {
+		case <-e.ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
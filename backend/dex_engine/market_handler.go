@@ -6,10 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/HydroProtocol/hydro-sdk-backend/engine"
 	"math/big"
 	"os"
-	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
@@ -19,161 +19,645 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// defaultSnapshotInterval is how many events a market handles between two snapshots.
+// It can be changed by HSK_ENGINE_SNAPSHOT_INTERVAL.
+const defaultSnapshotInterval = 1000
+
+// defaultBlockGasBudget is the gas a single settlement transaction may use.
+// It can be changed by HSK_BLOCK_GAS_BUDGET, keep it below the block gas limit of the network.
+const defaultBlockGasBudget = 6000000
+
+// defaultMatchGasUsed is used for markets without a GasUsedEstimation.
+const defaultMatchGasUsed = 250000
+
 type MarketHandler struct {
-	ctx         context.Context
-	market      *models.Market
-	eventChan   chan []byte
-	hydroEngine *engine.Engine
+	ctx       context.Context
+	market    *models.Market
+	eventChan chan *models.EngineEvent
+
+	// events dispatched by the engine wait here until they are forwarded to eventChan
+	events *marketEventQueue
+
+	orderbook *orderbook
+	stopbook  *stopbook
+	kvStore   common.IKVStore
+
+	// ID of the last event handled by this market, saved in the snapshot
+	lastEventID         int64
+	eventsSinceSnapshot int
+	snapshotInterval    int
+
+	// events journaled before the engine stopped which the market didn't handle, they are handled first when it runs
+	unhandledEvents []*models.EngineEvent
+
+	// matches of a taker order are split into transactions which fit in this budget
+	blockGasBudget int
+
+	// orders are canceled through the event queue when their signed expiry passes,
+	// expiry is off if there is no queue
+	expiries   expiryQueue
+	eventQueue common.IQueue
+	// orders which expire later are scheduled when the horizon moves
+	expiriesUntil int64
+
+	// time the event which is handled now was journaled
+	eventTime time.Time
 }
 
-// Run is synchronous, it will be improved in the later releases.
+// Run handles the events of the market one by one. Every market runs in its own goroutine,
+// so markets are handled in parallel while the events of a market keep their order.
 func (m *MarketHandler) Run() {
-	for data := range m.eventChan {
-		_ = handleEvent(m, string(data))
+	for _, journalEvent := range m.unhandledEvents {
+		utils.Infof("market %s handles event %d journaled before the last stop", m.market.ID, journalEvent.ID)
+		_ = handleEvent(m, journalEvent)
 	}
-	utils.Infof("market %s stopped", m.market.ID)
-}
 
-func (m *MarketHandler) Stop() {
-	close(m.eventChan)
-}
-
-// handleEvent recover any panic which is caused by event.
-// It will log event and response as well.
-func handleEvent(marketHandler *MarketHandler, eventJSON string) (err error) {
-	var event common.Event
+	m.unhandledEvents = nil
 
-	defer func() {
-		if rcv := recover(); rcv != nil {
-			err = rcv.(error)
-		}
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
 
-		if err != nil {
-			buf := make([]byte, 2048)
-			n := runtime.Stack(buf, false)
-			stackInfo := fmt.Sprintf("%s", buf[:n])
+	for {
+		select {
+		case journalEvent, ok := <-m.eventChan:
+			if !ok {
+				m.saveSnapshot()
+				utils.Infof("market %s stopped", m.market.ID)
+				return
+			}
 
-			utils.Errorf("Errorf: %+v", err)
-			utils.Errorf(stackInfo)
+			_ = handleEvent(m, journalEvent)
+		case now := <-ticker.C:
+			m.expireOrders(now)
 		}
+	}
+}
 
-	}()
+// Dispatch queues an event for the market without waiting for the handler.
+func (m *MarketHandler) Dispatch(journalEvent *models.EngineEvent) {
+	m.events.push(journalEvent)
+}
 
-	err = json.Unmarshal([]byte(eventJSON), &event)
+// Stop lets the handler finish the events already dispatched to it, and then stops it.
+func (m *MarketHandler) Stop() {
+	m.events.close()
+}
 
+// handleEvent recover any panic which is caused by event.
+// It will log event and response as well. An event which fails is kept as a dead letter.
+// The event is saved as handled afterwards, so it is not handled again when the market is loaded.
+func handleEvent(marketHandler *MarketHandler, journalEvent *models.EngineEvent) (err error) {
+	marketHandler.lastEventID = journalEvent.ID
+	marketHandler.eventTime = journalEvent.CreatedAt
+
+	_, stack, err := marketHandler.safeHandleEvent(journalEvent.Payload)
 	if err != nil {
-		utils.Errorf("Unmarshal event failed %s", eventJSON)
-		return err
+		utils.Errorf("Errorf: %+v", err)
+		utils.Errorf(stack)
+
+		addDeadLetter(journalEvent, err, stack)
 	}
 
-	_, err = marketHandler.handleEvent(event, eventJSON)
+	saveHandledEvent(journalEvent)
 
 	return err
 }
 
-func (m *MarketHandler) handleEvent(event common.Event, eventJSON string) (interface{}, error) {
+func saveHandledEvent(journalEvent *models.EngineEvent) {
+	saveErr := models.EngineEventDao.SaveMarketHandledEvent(journalEvent.MarketID, journalEvent.ID)
+	if saveErr != nil {
+		utils.Errorf("save event %d of market %s as handled failed: %v", journalEvent.ID, journalEvent.MarketID, saveErr)
+	}
+}
+
+func (m *MarketHandler) handleEvent(event common.Event, eventJSON string) (res interface{}, err error) {
 	switch event.Type {
 	case common.EventNewOrder:
 		var e common.NewOrderEvent
 		_ = json.Unmarshal([]byte(eventJSON), &e)
-		res, _ := m.handleNewOrder(&e)
-		return res, nil
+		res, _, err = m.handleNewOrder(&e)
 	case common.EventCancelOrder:
 		var e common.CancelOrderEvent
 		_ = json.Unmarshal([]byte(eventJSON), &e)
-		res, err := m.handleCancelOrder(&e)
-		return res, err
+		res, err = m.handleCancelOrder(&e)
+	case models.EventChangeMarketState:
+		var e models.ChangeMarketStateEvent
+		_ = json.Unmarshal([]byte(eventJSON), &e)
+		res, err = m.handleChangeMarketState(&e)
+	case models.EventAmendOrder:
+		var e models.AmendOrderEvent
+		_ = json.Unmarshal([]byte(eventJSON), &e)
+		res, err = m.handleAmendOrder(&e)
+	case models.EventReplaceOrder:
+		var e models.ReplaceOrderEvent
+		_ = json.Unmarshal([]byte(eventJSON), &e)
+		res, _, err = m.handleReplaceOrder(&e)
+	case models.EventBulkCancelOrders:
+		var e models.BulkCancelOrdersEvent
+		_ = json.Unmarshal([]byte(eventJSON), &e)
+		res, err = m.handleBulkCancelOrders(&e)
 	case common.EventConfirmTransaction:
-		var e common.ConfirmTransactionEvent
+		var e models.ConfirmTransactionEvent
 		_ = json.Unmarshal([]byte(eventJSON), &e)
-		res, err := m.handleTransactionResult(&e)
-		return res, err
+		res, err = m.handleTransactionResult(&e)
+	case models.EventMarketConfig:
+		var e models.MarketConfigEvent
+		_ = json.Unmarshal([]byte(eventJSON), &e)
+		res, err = m.handleMarketConfig(&e)
+	case models.EventRetryDeadLetter:
+		var e models.RetryDeadLetterEvent
+		_ = json.Unmarshal([]byte(eventJSON), &e)
+		res, err = m.handleRetryDeadLetter(&e)
 	default:
 		return nil, fmt.Errorf("unsupport event for market %s %s", m.market.ID, eventJSON)
 	}
+
+	m.eventsSinceSnapshot++
+	if m.eventsSinceSnapshot >= m.snapshotInterval {
+		m.saveSnapshot()
+	}
+
+	return res, err
 }
 
-func (m MarketHandler) handleNewOrder(event *common.NewOrderEvent) (transaction *models.Transaction, launchLog *models.LaunchLog) {
+// publishOrderbook saves the aggregated book, the api and websocket servers read it from the kv store.
+func (m *MarketHandler) publishOrderbook() {
+	snapshot := m.orderbook.SnapshotV2()
+	snapshot.Sequence = m.orderbook.Sequence
+
+	RedisOrderBookSnapshotHandler{kvStore: m.kvStore}.Update(common.GetMarketOrderbookSnapshotV2Key(m.market.ID), snapshot)
+
+	if m.isCallAuction() {
+		m.publishCallAuctionPrice()
+	}
+}
+
+func (m *MarketHandler) handleNewOrder(event *common.NewOrderEvent) (transactions []*models.Transaction, launchLogs []*models.LaunchLog, err error) {
 	eventOrderString := event.Order
 	var eventOrder models.Order
 	_ = json.Unmarshal([]byte(eventOrderString), &eventOrder)
 
-	eventMemoryOrder := &common.MemoryOrder{
-		ID:           eventOrder.ID,
-		MarketID:     eventOrder.MarketID,
-		Price:        eventOrder.Price,
-		Amount:       eventOrder.Amount,
-		Side:         eventOrder.Side,
-		GasFeeAmount: eventOrder.GasFeeAmount,
-		MakerFeeRate: eventOrder.MakerFeeRate,
-		TakerFeeRate: eventOrder.TakerFeeRate,
+	if eventOrder.Status == models.ORDER_UNTRIGGERED {
+		if reason := m.marketStateRejectReason(nil); reason != "" {
+			return nil, nil, m.rejectOrder(&eventOrder, InsertOrder, reason)
+		}
+
+		err = m.handleNewStopOrder(&eventOrder)
+	} else {
+		transactions, launchLogs, err = m.matchOrder(&eventOrder, InsertOrder, nil)
+	}
+
+	if err == nil {
+		m.scheduleExpiry(&eventOrder)
+	}
+
+	return transactions, launchLogs, err
+}
+
+// rejectReason returns why the order must not be matched, or an empty string if it can be.
+func (m *MarketHandler) rejectReason(order *models.Order, memoryOrder *common.MemoryOrder) string {
+	if reason := m.marketStateRejectReason(memoryOrder); reason != "" {
+		return reason
+	}
+
+	if m.isCallAuction() {
+		if reason := callAuctionRejectReason(order); reason != "" {
+			return reason
+		}
+	}
+
+	if isPostOnly(order) && m.orderbook.CanMatch(memoryOrder) {
+		return OrderRejectedPostOnly
+	}
+
+	if m.isExpired(order) {
+		return OrderRejectedExpired
+	}
+
+	if order.TimeInForce == models.TIME_IN_FORCE_FOK && !m.orderbook.canFill(memoryOrder) {
+		return OrderRejectedFillOrKill
+	}
+
+	return ""
+}
+
+// marketStateRejectReason returns why the trading state of the market doesn't take the order.
+// memoryOrder is nil for stop orders, they don't match when they are placed.
+func (m *MarketHandler) marketStateRejectReason(memoryOrder *common.MemoryOrder) string {
+	switch m.market.TradingState {
+	case models.MARKET_STATE_HALTED:
+		return OrderRejectedMarketHalted
+	case models.MARKET_STATE_CANCEL_ONLY:
+		return OrderRejectedMarketCancelOnly
+	case models.MARKET_STATE_POST_ONLY:
+		if memoryOrder != nil && m.orderbook.CanMatch(memoryOrder) {
+			return OrderRejectedMarketPostOnly
+		}
+	}
+
+	return ""
+}
+
+// handleChangeMarketState changes the trading state of the market and tells the market channel about it.
+// A book left crossed by a call auction is uncrossed once the market takes orders again, the uncross is saved
+// with the change of the state and the market takes the new state once both are committed.
+func (m *MarketHandler) handleChangeMarketState(event *models.ChangeMarketStateEvent) (interface{}, error) {
+	utils.Infof("market %s trading state changes from %s to %s", m.market.ID, m.market.TradingState, event.TradingState)
+
+	var auction *models.CallAuctionPrice
+	var results []*common.MatchResult
+	var msgs []common.WebSocketMessage
+
+	if takesOrders(event.TradingState) {
+		auction, results, msgs = m.orderbook.uncross()
+
+		if len(results) > 0 {
+			utils.Infof("market %s uncross at %s, volume %s, %d orders take", m.market.ID, auction.Price, auction.Volume, len(results))
+		}
+	}
+
+	err := runInTransaction(func(tx *dbTx) error {
+		err := sendMarketStateChangeMessage(tx, m.market.ID, event.TradingState)
+		if err != nil {
+			return err
+		}
+
+		if len(results) == 0 {
+			return nil
+		}
+
+		return m.saveUncross(tx, auction.Price, results, msgs)
+	})
+
+	if err != nil {
+		if len(results) > 0 {
+			m.resyncOrderbook()
+		}
+
+		return nil, err
+	}
+
+	m.market.TradingState = event.TradingState
+
+	if m.isCallAuction() {
+		m.publishCallAuctionPrice()
+	}
+
+	if len(results) > 0 {
+		m.publishOrderbook()
+	}
+
+	return nil, nil
+}
+
+// handleMarketConfig takes the config of the market in the event, the trading state stays as it is.
+// The amount decimals apply to the orders which are put in the book from now on.
+func (m *MarketHandler) handleMarketConfig(event *models.MarketConfigEvent) (interface{}, error) {
+	if event.Market == nil || event.Market.ID != m.market.ID {
+		return nil, fmt.Errorf("market config event of market %s has no config of the market", m.market.ID)
 	}
 
-	utils.Debugf("%s NEW_ORDER  price: %s amount: %s %4s", event.MarketID, eventOrder.Price.StringFixed(5), eventOrder.Amount.StringFixed(5), eventOrder.Side)
+	market := *event.Market
+	market.TradingState = m.market.TradingState
 
-	matchResult, hasMatch := m.hydroEngine.HandleNewOrder(eventMemoryOrder)
-	if hasMatch {
-		resultWithOrders := NewMatchResultWithOrders(&eventOrder, &matchResult)
+	m.market = &market
+	m.orderbook.amountDecimals = market.AmountDecimals
+	return nil, nil
+}
 
-		for i := range resultWithOrders.MatchItems {
-			item := resultWithOrders.MatchItems[i]
-			makerOrder := resultWithOrders.modelMakerOrders[item.MakerOrder.ID]
+// rejectOrder saves the order as canceled without touching the book, and tells the trader why it was rejected.
+func (m *MarketHandler) rejectOrder(order *models.Order, saveOrder func(tx *dbTx, order *models.Order) error, reason string) error {
+	utils.Infof("market %s order %s rejected: %s", m.market.ID, order.ID, reason)
 
-			makerOrder.AvailableAmount = makerOrder.AvailableAmount.Sub(item.MatchedAmount)
-			eventOrder.AvailableAmount = eventOrder.AvailableAmount.Sub(item.MatchedAmount)
+	order.CanceledAmount = order.Amount
+	order.AvailableAmount = decimal.Zero
+	order.AutoSetStatusByAmounts()
 
-			if item.MatchShouldBeCanceled {
-				makerOrder.CanceledAmount = makerOrder.CanceledAmount.Add(item.MatchedAmount)
-				eventOrder.CanceledAmount = eventOrder.CanceledAmount.Add(item.MatchedAmount)
-			} else {
-				makerOrder.PendingAmount = makerOrder.PendingAmount.Add(item.MatchedAmount)
-				eventOrder.PendingAmount = eventOrder.PendingAmount.Add(item.MatchedAmount)
+	return runInTransaction(func(tx *dbTx) error {
+		err := saveOrder(tx, order)
+		if err != nil {
+			return err
+		}
+
+		return sendOrderRejectedMessage(tx, order, reason)
+	})
+}
+
+// handleNewStopOrder saves an untriggered stop order, it waits in the stop book until the last trade price reaches it.
+func (m *MarketHandler) handleNewStopOrder(order *models.Order) error {
+	utils.Debugf("%s NEW_STOP_ORDER  stop price: %s amount: %s %4s", order.MarketID, order.StopPrice.StringFixed(5), order.Amount.StringFixed(5), order.Side)
+
+	err := runInTransaction(func(tx *dbTx) error {
+		return InsertOrder(tx, order)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.stopbook.insertOrder(order)
+
+	return nil
+}
+
+// triggerStopOrders turns the stop orders reached by a trade at price into pending orders and matches them.
+func (m *MarketHandler) triggerStopOrders(price decimal.Decimal) {
+	// the stop orders keep waiting while the market doesn't match, a trade after it is back to trading triggers them
+	if !m.market.IsMatching() {
+		return
+	}
+
+	m.matchTriggeredOrders(m.stopbook.trigger(price), price, UpdateOrder)
+}
+
+// matchTriggeredOrders matches the triggered stop orders one by one and saves them with saveOrder.
+func (m *MarketHandler) matchTriggeredOrders(stopOrders []*stopOrder, price decimal.Decimal, saveOrder func(tx *dbTx, order *models.Order) error) {
+	for _, stopOrder := range stopOrders {
+		order := models.OrderDao.FindByID(stopOrder.ID)
+		if order == nil {
+			utils.Errorf("cannot find triggered stop order with id %s", stopOrder.ID)
+			continue
+		}
+
+		utils.Infof("market %s stop order %s triggered at %s", m.market.ID, order.ID, price)
+
+		order.Status = common.ORDER_PENDING
+		_, _, err := m.matchOrder(order, saveOrder, nil)
+		if err != nil {
+			// the books are rebuilt from the database, the stop orders which were not matched are waiting again
+			utils.Errorf("match triggered stop order %s failed: %v", order.ID, err)
+			return
+		}
+	}
+}
+
+// matchOrder matches the order against the book, and saves it with saveOrder together with the result of the match.
+// If before is not nil, it is run first in the same sql transaction, also when the order is rejected.
+// When saving fails the books are rebuilt from the database, the caller may have taken the order out of them already.
+func (m *MarketHandler) matchOrder(order *models.Order, saveOrder func(tx *dbTx, order *models.Order) error, before func(tx *dbTx) error) (transactions []*models.Transaction, launchLogs []*models.LaunchLog, err error) {
+	eventOrder := *order
+	eventMemoryOrder := newMemoryOrder(&eventOrder)
+
+	if reason := m.rejectReason(&eventOrder, eventMemoryOrder); reason != "" {
+		save := saveOrder
+		if before != nil {
+			save = func(tx *dbTx, order *models.Order) error {
+				err := before(tx)
+				if err != nil {
+					return err
+				}
+
+				return saveOrder(tx, order)
 			}
+		}
+
+		err = m.rejectOrder(&eventOrder, save, reason)
+		if err != nil {
+			m.resyncOrderbook()
+		}
+
+		return nil, nil, err
+	}
+
+	return m.matchAndSaveOrder(&eventOrder, eventMemoryOrder, saveOrder, before)
+}
 
-			if item.MakerOrderIsDone {
-				makerOrder.CanceledAmount = makerOrder.Amount.Sub(makerOrder.ConfirmedAmount.Add(makerOrder.PendingAmount))
-				makerOrder.AvailableAmount = decimal.Zero
+// matchAndSaveOrder matches the order without checking it, the order was checked or it rests in the book already.
+func (m *MarketHandler) matchAndSaveOrder(eventOrder *models.Order, eventMemoryOrder *common.MemoryOrder, saveOrder func(tx *dbTx, order *models.Order) error, before func(tx *dbTx) error) (transactions []*models.Transaction, launchLogs []*models.LaunchLog, err error) {
+	utils.Debugf("%s NEW_ORDER  price: %s amount: %s %4s", eventOrder.MarketID, eventOrder.Price.StringFixed(5), eventOrder.Amount.StringFixed(5), eventOrder.Side)
+
+	selfTrade, matchResult, hasMatch := m.matchInBook(eventOrder, eventMemoryOrder)
+
+	// the whole match result is saved in one sql transaction
+	err = runInTransaction(func(tx *dbTx) error {
+		transactions, launchLogs = nil, nil
+
+		if before != nil {
+			err := before(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, msg := range append(selfTrade.OrderBookActivities, matchResult.OrderBookActivities...) {
+			err := tx.pushMessage(msg)
+			if err != nil {
+				return err
 			}
+		}
+
+		err := cancelSelfTrade(tx, eventOrder, selfTrade)
+		if err != nil {
+			return err
+		}
+
+		if hasMatch {
+			resultWithOrders := NewMatchResultWithOrders(eventOrder, &matchResult)
 
-			_ = UpdateOrder(makerOrder)
+			for i := range resultWithOrders.MatchItems {
+				item := resultWithOrders.MatchItems[i]
+				makerOrder := resultWithOrders.modelMakerOrders[item.MakerOrder.ID]
 
-			utils.Debugf("  [Take Liquidity] price: %s amount: %s (%s) ", item.MakerOrder.Price.StringFixed(5), item.MatchedAmount.StringFixed(5), item.MakerOrder.ID)
+				makerOrder.AvailableAmount = makerOrder.AvailableAmount.Sub(item.MatchedAmount)
+				eventOrder.AvailableAmount = eventOrder.AvailableAmount.Sub(item.MatchedAmount)
+
+				if item.MatchShouldBeCanceled {
+					makerOrder.CanceledAmount = makerOrder.CanceledAmount.Add(item.MatchedAmount)
+					eventOrder.CanceledAmount = eventOrder.CanceledAmount.Add(item.MatchedAmount)
+				} else {
+					makerOrder.PendingAmount = makerOrder.PendingAmount.Add(item.MatchedAmount)
+					eventOrder.PendingAmount = eventOrder.PendingAmount.Add(item.MatchedAmount)
+				}
+
+				if item.MakerOrderIsDone {
+					makerOrder.CanceledAmount = makerOrder.Amount.Sub(makerOrder.ConfirmedAmount.Add(makerOrder.PendingAmount))
+					makerOrder.AvailableAmount = decimal.Zero
+				}
+
+				err := UpdateOrder(tx, makerOrder)
+				if err != nil {
+					return err
+				}
+
+				utils.Debugf("  [Take Liquidity] price: %s amount: %s (%s) ", item.MakerOrder.Price.StringFixed(5), item.MatchedAmount.StringFixed(5), item.MakerOrder.ID)
+			}
+
+			if matchResult.ExistMatchToBeExecuted() {
+				var err error
+				transactions, launchLogs, err = processTransactionAndLaunchLogs(tx, resultWithOrders, m.blockGasBudget)
+				if err != nil {
+					return err
+				}
+			}
 		}
 
+		// the part which is not filled is canceled, it may be the whole order if nothing matched
 		if matchResult.TakerOrderIsDone {
 			eventOrder.CanceledAmount = eventOrder.Amount.Sub(eventOrder.ConfirmedAmount.Add(eventOrder.PendingAmount))
 			eventOrder.AvailableAmount = decimal.Zero
+			eventOrder.AutoSetStatusByAmounts()
+		}
+
+		return saveOrder(tx, eventOrder)
+	})
+
+	if err != nil {
+		m.resyncOrderbook()
+		return nil, nil, err
+	}
+
+	m.publishOrderbook()
+
+	return transactions, launchLogs, nil
+}
+
+// matchInBook keeps the order from trading with its own trader, then matches it against the book.
+// Market orders are limited to the max slippage of the market, measured from the best price after self trade prevention.
+// The api sets the self trade prevention mode of the market on orders which don't choose one,
+// so the mode is journaled with the order and a replay doesn't depend on the current market settings.
+func (m *MarketHandler) matchInBook(order *models.Order, memoryOrder *common.MemoryOrder) (selfTrade *selfTradePrevention, matchResult common.MatchResult, hasMatch bool) {
+	// orders collect in the book during a call auction, they are matched when the book is uncrossed
+	if m.isCallAuction() {
+		return &selfTradePrevention{}, m.orderbook.restOrder(memoryOrder, order.DisplayAmount), false
+	}
+
+	selfTrade = m.orderbook.preventSelfTrade(memoryOrder, order.SelfTradePrevention)
+
+	if !memoryOrder.Amount.IsPositive() {
+		matchResult.TakerOrderIsDone = true
+		return
+	}
+
+	rest := restsInBook(order) && !selfTrade.TakerOrderIsDone
+
+	// the part of a market order which can't be matched within the max slippage is canceled instead of resting in the book
+	if isMarketOrder(order) && m.market.MarketOrderMaxSlippage.IsPositive() {
+		m.orderbook.limitSlippage(memoryOrder, m.market.MarketOrderMaxSlippage)
+		rest = false
+	}
+
+	matchResult, hasMatch = m.orderbook.matchNewOrder(memoryOrder, rest, order.DisplayAmount)
+	return
+}
+
+// cancelSelfTrade saves the amounts canceled by self trade prevention, the taker order is saved by the caller.
+func cancelSelfTrade(tx *dbTx, takerOrder *models.Order, selfTrade *selfTradePrevention) error {
+	for _, canceled := range selfTrade.CanceledMakerOrders {
+		makerOrder := models.OrderDao.FindByID(canceled.ID)
+		if makerOrder == nil {
+			return fmt.Errorf("cannot find self trade order with id %s", canceled.ID)
+		}
+
+		makerOrder.AvailableAmount = makerOrder.AvailableAmount.Sub(canceled.CanceledAmount)
+		makerOrder.CanceledAmount = makerOrder.CanceledAmount.Add(canceled.CanceledAmount)
+		makerOrder.AutoSetStatusByAmounts()
+
+		err := UpdateOrder(tx, makerOrder)
+		if err != nil {
+			return err
+		}
+
+		utils.Infof("  [Self Trade] order %s canceled amount: %s (%s)", makerOrder.ID, canceled.CanceledAmount.StringFixed(5), takerOrder.ID)
+	}
+
+	takerOrder.AvailableAmount = takerOrder.AvailableAmount.Sub(selfTrade.TakerCanceledAmount)
+	takerOrder.CanceledAmount = takerOrder.CanceledAmount.Add(selfTrade.TakerCanceledAmount)
+
+	return nil
+}
+
+// resyncOrderbook rebuilds the book from the database after the changes of an event were rolled back.
+func (m *MarketHandler) resyncOrderbook() {
+	sequence := m.orderbook.Sequence
+
+	m.orderbook = newOrderbook(m.market.ID, m.market.AmountDecimals)
+	m.stopbook = newStopbook()
+	m.orderbook.Sequence = sequence
+	m.rebuildOrderbook()
+	m.scheduleExpiries()
+}
+
+// processTransactionAndLaunchLogs settles the match result with one or more transactions.
+// There is a gas limit of a block, so the matches are split into chunks which fit in the gas budget,
+// each chunk gets its own transaction, launch log and trades.
+func processTransactionAndLaunchLogs(tx *dbTx, matchResult *MatchResultWithOrders, gasBudget int) (transactions []*models.Transaction, launchLogs []*models.LaunchLog, err error) {
+	market := models.MarketDao.FindMarketByID(matchResult.modelTakerOrder.MarketID)
+
+	gasPerMatch := market.GasUsedEstimation
+	if gasPerMatch <= 0 {
+		gasPerMatch = defaultMatchGasUsed
+	}
+
+	for _, chunk := range splitMatchItems(matchResult.MatchItems, gasPerMatch, gasBudget) {
+		transaction, launchLog, err := processTransactionAndLaunchLog(tx, matchResult, market, chunk, gasPerMatch)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		if matchResult.ExistMatchToBeExecuted() {
-			transaction, launchLog = processTransactionAndLaunchLog(resultWithOrders)
-			trades := newTradesByMatchResult(resultWithOrders, transaction.ID)
+		trades := newTradesByMatchResult(matchResult, chunk, transaction.ID)
 
-			for _, trade := range trades {
-				_ = InsertTrade(trade)
+		for _, trade := range trades {
+			err = InsertTrade(tx, trade)
+			if err != nil {
+				return nil, nil, err
 			}
 		}
+
+		transactions = append(transactions, transaction)
+		launchLogs = append(launchLogs, launchLog)
 	}
 
-	_ = InsertOrder(&eventOrder)
+	return
+}
+
+// settlementChunk is the part of a match result which is settled by a single transaction.
+type settlementChunk struct {
+	matchItems []*common.MatchItem
 
-	return transaction, launchLog
+	// index of the first item in the match result, trades of the chunk continue the sequence from it
+	offset int
+
+	// items which are sent to the chain, canceled matches are not
+	settledItems int
 }
 
-// If there are many items in the match result, it can't settle them in a single transaction, since there is a gas limit of a block.
-// Will separate the matches into different transactions in another  release.
-func processTransactionAndLaunchLog(matchResult *MatchResultWithOrders) (*models.Transaction, *models.LaunchLog) {
+// splitMatchItems groups the match items into chunks of at most gasBudget/gasPerMatch settled items.
+// A canceled match stays in the chunk of the items before it.
+func splitMatchItems(items []*common.MatchItem, gasPerMatch, gasBudget int) []*settlementChunk {
+	maxItems := gasBudget / gasPerMatch
+	if maxItems < 1 {
+		maxItems = 1
+	}
+
+	var chunks []*settlementChunk
+	var chunk *settlementChunk
+
+	for i, item := range items {
+		if chunk == nil || (!item.MatchShouldBeCanceled && chunk.settledItems == maxItems) {
+			chunk = &settlementChunk{offset: i}
+			chunks = append(chunks, chunk)
+		}
+
+		chunk.matchItems = append(chunk.matchItems, item)
+
+		if !item.MatchShouldBeCanceled {
+			chunk.settledItems++
+		}
+	}
+
+	return chunks
+}
+
+func processTransactionAndLaunchLog(tx *dbTx, matchResult *MatchResultWithOrders, market *models.Market, chunk *settlementChunk, gasPerMatch int) (*models.Transaction, *models.LaunchLog, error) {
 	takerOrder := matchResult.modelTakerOrder
 	hydroTakerOrder := getHydroOrderFromModelOrder(takerOrder.GetOrderJson())
 
 	var hydroMakerOrders []*sdk.Order
 	var baseTokenFilledAmounts []*big.Int
 
-	market := models.MarketDao.FindMarketByID(takerOrder.MarketID)
-
 	baseTokenDecimal := market.BaseTokenDecimals
 
-	for _, item := range matchResult.MatchItems {
+	for _, item := range chunk.matchItems {
 		if item.MatchShouldBeCanceled {
 			//skip if match should be canceled
 			continue
@@ -188,7 +672,10 @@ func processTransactionAndLaunchLog(matchResult *MatchResultWithOrders) (*models
 		baseTokenFilledAmt := utils.DecimalToBigInt(baseTokenHugeAmt)
 		baseTokenFilledAmounts = append(baseTokenFilledAmounts, baseTokenFilledAmt)
 
-		_ = UpdateOrder(modelMakerOrder)
+		err := UpdateOrder(tx, modelMakerOrder)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	transaction := &models.Transaction{
@@ -201,10 +688,10 @@ func processTransactionAndLaunchLog(matchResult *MatchResultWithOrders) (*models
 		ExecutedAt: time.Now().UTC(),
 		CreatedAt:  time.Now().UTC(),
 	}
-	err := models.TransactionDao.InsertTransaction(transaction)
+	err := tx.TransactionDao.InsertTransaction(transaction)
 
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
 	launchLog := &models.LaunchLog{
@@ -214,27 +701,33 @@ func processTransactionAndLaunchLog(matchResult *MatchResultWithOrders) (*models
 		From:      os.Getenv("HSK_RELAYER_ADDRESS"),
 		To:        os.Getenv("HSK_HYBRID_EXCHANGE_ADDRESS"),
 		Value:     decimal.Zero,
-		GasLimit:  int64(len(matchResult.MatchItems) * 250000),
+		GasLimit:  int64(chunk.settledItems * gasPerMatch),
 		Data:      utils.Bytes2HexP(hydroProtocol.GetMatchOrderCallData(hydroTakerOrder, hydroMakerOrders, baseTokenFilledAmounts)),
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
 	}
 
-	err = models.LaunchLogDao.InsertLaunchLog(launchLog)
+	err = tx.LaunchLogDao.InsertLaunchLog(launchLog)
 
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	return transaction, launchLog
+	return transaction, launchLog, nil
 }
 
-func newTradesByMatchResult(matchResult *MatchResultWithOrders, transactionID int64) []*models.Trade {
+func newTradesByMatchResult(matchResult *MatchResultWithOrders, chunk *settlementChunk, transactionID int64) []*models.Trade {
 	var trades []*models.Trade
 	takerOrder := matchResult.modelTakerOrder
 
-	for i, item := range matchResult.MatchItems {
+	for i, item := range chunk.matchItems {
 		modelMakerOrder := matchResult.modelMakerOrders[item.MakerOrder.ID]
+
+		price := modelMakerOrder.Price
+		if matchResult.tradePrice.IsPositive() {
+			price = matchResult.tradePrice
+		}
+
 		trade := &models.Trade{
 			TransactionID:   transactionID,
 			TransactionHash: "",
@@ -245,9 +738,9 @@ func newTradesByMatchResult(matchResult *MatchResultWithOrders, transactionID in
 			TakerSide:       takerOrder.Side,
 			MakerOrderID:    modelMakerOrder.ID,
 			TakerOrderID:    takerOrder.ID,
-			Sequence:        i,
+			Sequence:        chunk.offset + i,
 			Amount:          item.MatchedAmount,
-			Price:           modelMakerOrder.Price,
+			Price:           price,
 			CreatedAt:       time.Now().UTC(),
 		}
 		trades = append(trades, trade)
@@ -262,108 +755,418 @@ func (m *MarketHandler) handleCancelOrder(event *common.CancelOrderEvent) (inter
 		return nil, errors.New(fmt.Sprintf("cannot find order with id %s", event.ID))
 	}
 
-	bookOrder := &common.MemoryOrder{
-		MarketID: m.market.ID,
-		ID:       order.ID,
-		Price:    order.Price,
-		Side:     order.Side,
-		Amount:   order.AvailableAmount,
-	}
-	msg, success := m.hydroEngine.HandleCancelOrder(bookOrder)
-	if success {
-		pushMessage(msg)
-	}
+	e := m.orderbook.removeOrder(order.ID)
+	m.stopbook.removeOrder(order.ID)
 
 	order.CanceledAmount = order.CanceledAmount.Add(order.AvailableAmount)
 	order.AvailableAmount = decimal.Zero
 	order.AutoSetStatusByAmounts()
 
-	err := UpdateOrder(order)
+	err := runInTransaction(func(tx *dbTx) error {
+		if e != nil {
+			msg := common.OrderBookChangeMessage(m.market.ID, m.orderbook.Sequence, e.Side, e.Price, e.Amount)
+
+			err := tx.pushMessage(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		return UpdateOrder(tx, order)
+	})
+
+	if err != nil {
+		m.resyncOrderbook()
+		return nil, err
+	}
+
+	if e != nil {
+		m.publishOrderbook()
+	}
+
+	return order, nil
+}
+
+// handleAmendOrder lowers the amount left open of an order. The order keeps its time priority,
+// the amount taken from it is canceled.
+func (m *MarketHandler) handleAmendOrder(event *models.AmendOrderEvent) (interface{}, error) {
+	order := models.OrderDao.FindByID(event.ID)
+	if order == nil {
+		return nil, fmt.Errorf("cannot find order with id %s", event.ID)
+	}
+
+	availableAmount, err := decimal.NewFromString(event.AvailableAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	e, amendedAmount := m.amendInBook(order.ID, availableAmount)
+	if !amendedAmount.IsPositive() {
+		utils.Infof("market %s order %s is not amended to %s, available amount is %s", m.market.ID, order.ID, availableAmount, order.AvailableAmount)
+		return order, nil
+	}
+
+	order.AvailableAmount = order.AvailableAmount.Sub(amendedAmount)
+	order.CanceledAmount = order.CanceledAmount.Add(amendedAmount)
+
+	err = runInTransaction(func(tx *dbTx) error {
+		if e != nil {
+			msg := common.OrderBookChangeMessage(m.market.ID, m.orderbook.Sequence, e.Side, e.Price, e.Amount)
+
+			err := tx.pushMessage(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		return UpdateOrder(tx, order)
+	})
+
+	if err != nil {
+		m.resyncOrderbook()
+		return nil, err
+	}
+
+	if e != nil {
+		m.publishOrderbook()
+	}
+
+	return order, nil
+}
+
+// amendInBook lowers the open amount of a resting or untriggered order to availableAmount.
+// It returns the amount taken from the order, which is zero if the order is not open or the amount is not lower,
+// and the book event if the shown part of an order resting in the book changed.
+func (m *MarketHandler) amendInBook(orderID string, availableAmount decimal.Decimal) (*common.OrderbookEvent, decimal.Decimal) {
+	if !availableAmount.IsPositive() {
+		return nil, decimal.Zero
+	}
+
+	if bookOrder, ok := m.orderbook.getOrder(orderID); ok {
+		if availableAmount.GreaterThanOrEqual(bookOrder.openAmount()) {
+			return nil, decimal.Zero
+		}
+
+		amendedAmount := bookOrder.openAmount().Sub(availableAmount)
+		e := m.orderbook.reduceOrder(bookOrder, availableAmount)
+
+		return e, amendedAmount
+	}
+
+	if stopOrder, ok := m.stopbook.getOrder(orderID); ok {
+		if availableAmount.GreaterThanOrEqual(stopOrder.Amount) {
+			return nil, decimal.Zero
+		}
+
+		amendedAmount := stopOrder.Amount.Sub(availableAmount)
+		stopOrder.Amount = availableAmount
+
+		return nil, amendedAmount
+	}
+
+	return nil, decimal.Zero
+}
+
+// handleReplaceOrder cancels an order and places a new order of the same trader in one event,
+// the cancel and the new order are saved in the same sql transaction. If the replaced order is not open any more,
+// because it was filled or canceled, the new order is rejected.
+func (m *MarketHandler) handleReplaceOrder(event *models.ReplaceOrderEvent) (transactions []*models.Transaction, launchLogs []*models.LaunchLog, err error) {
+	var newOrder models.Order
+	_ = json.Unmarshal([]byte(event.Order), &newOrder)
+
+	// the replaced order is kept if the market takes no new orders
+	if reason := m.marketStateRejectReason(nil); reason != "" {
+		return nil, nil, m.rejectOrder(&newOrder, InsertOrder, reason)
+	}
+
+	replacedOrder := models.OrderDao.FindByID(event.ReplacedOrderID)
+	if replacedOrder == nil || replacedOrder.TraderAddress != newOrder.TraderAddress || !m.isOpen(replacedOrder.ID) {
+		return nil, nil, m.rejectOrder(&newOrder, InsertOrder, OrderRejectedReplacedOrderNotOpen)
+	}
+
+	e := m.orderbook.removeOrder(replacedOrder.ID)
+	m.stopbook.removeOrder(replacedOrder.ID)
+
+	replacedOrder.CanceledAmount = replacedOrder.CanceledAmount.Add(replacedOrder.AvailableAmount)
+	replacedOrder.AvailableAmount = decimal.Zero
+	replacedOrder.AutoSetStatusByAmounts()
 
-	return order, err
+	var bookMessages []common.WebSocketMessage
+	if e != nil {
+		bookMessages = append(bookMessages, common.OrderBookChangeMessage(m.market.ID, m.orderbook.Sequence, e.Side, e.Price, e.Amount))
+	}
+
+	cancelReplacedOrder := func(tx *dbTx) error {
+		for _, msg := range bookMessages {
+			err := tx.pushMessage(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		return UpdateOrder(tx, replacedOrder)
+	}
+
+	if newOrder.Status == models.ORDER_UNTRIGGERED {
+		err = runInTransaction(func(tx *dbTx) error {
+			err := cancelReplacedOrder(tx)
+			if err != nil {
+				return err
+			}
+
+			return InsertOrder(tx, &newOrder)
+		})
+
+		if err != nil {
+			m.resyncOrderbook()
+			return nil, nil, err
+		}
+
+		m.stopbook.insertOrder(&newOrder)
+		m.publishOrderbook()
+	} else {
+		transactions, launchLogs, err = m.matchOrder(&newOrder, InsertOrder, cancelReplacedOrder)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	m.scheduleExpiry(&newOrder)
+
+	return transactions, launchLogs, nil
 }
 
-func (m *MarketHandler) handleTransactionResult(event *common.ConfirmTransactionEvent) (interface{}, error) {
+// isOpen returns true if the order is resting in the book or waiting in the stop book.
+func (m *MarketHandler) isOpen(orderID string) bool {
+	_, inBook := m.orderbook.getOrder(orderID)
+	_, inStopbook := m.stopbook.getOrder(orderID)
+
+	return inBook || inStopbook
+}
+
+// handleBulkCancelOrders cancels all orders of the event in one sql transaction.
+// Orders which are not open any more, or belong to another market, are skipped.
+func (m *MarketHandler) handleBulkCancelOrders(event *models.BulkCancelOrdersEvent) (interface{}, error) {
+	var orders []*models.Order
+	var bookMessages []common.WebSocketMessage
+
+	for _, order := range models.OrderDao.FindByIDs(event.IDs) {
+		if order.MarketID != m.market.ID || (order.Status != common.ORDER_PENDING && order.Status != models.ORDER_UNTRIGGERED) {
+			continue
+		}
+
+		// every removed order changes the sequence of the book, the message is built right away to get its sequence
+		e := m.orderbook.removeOrder(order.ID)
+		if e != nil {
+			msg := common.OrderBookChangeMessage(m.market.ID, m.orderbook.Sequence, e.Side, e.Price, e.Amount)
+			bookMessages = append(bookMessages, msg)
+		}
+		m.stopbook.removeOrder(order.ID)
+
+		order.CanceledAmount = order.CanceledAmount.Add(order.AvailableAmount)
+		order.AvailableAmount = decimal.Zero
+		order.AutoSetStatusByAmounts()
+
+		orders = append(orders, order)
+	}
+
+	utils.Infof("market %s bulk cancel %d of %d orders", m.market.ID, len(orders), len(event.IDs))
+
+	err := runInTransaction(func(tx *dbTx) error {
+		for _, msg := range bookMessages {
+			err := tx.pushMessage(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		return UpdateOrders(tx, orders)
+	})
+
+	if err != nil {
+		m.resyncOrderbook()
+		return nil, err
+	}
+
+	if len(bookMessages) > 0 {
+		m.publishOrderbook()
+	}
+
+	return orders, nil
+}
+
+// handleTransactionResult saves the result of a settlement transaction. When it failed, only the orders at fault
+// are canceled, the amounts of the other orders go back to the book with the priority they had.
+func (m *MarketHandler) handleTransactionResult(event *models.ConfirmTransactionEvent) (interface{}, error) {
 	executedAt := time.Unix(int64(event.Timestamp), 0)
+
 	transaction := models.TransactionDao.FindTransactionByHash(event.Hash)
-	transaction.Status = event.Status
-	transaction.ExecutedAt = executedAt
-	_ = models.TransactionDao.UpdateTransaction(transaction)
+	if transaction == nil {
+		return nil, fmt.Errorf("cannot find transaction with hash %s", event.Hash)
+	}
 
-	_ = models.LaunchLogDao.UpdateLaunchLogsStatusByItemID(event.Status, transaction.ID)
+	// the watcher may confirm a transaction more than once, only the first result is applied
+	if transaction.Status != common.STATUS_PENDING {
+		utils.Infof("market %s transaction %s is already %s, skip", m.market.ID, event.Hash, transaction.Status)
+		return nil, nil
+	}
 
 	trades := models.TradeDao.FindTradesByHash(event.Hash)
-	takerOrder := models.OrderDao.FindByID(trades[0].TakerOrderID)
+	if len(trades) == 0 {
+		return nil, fmt.Errorf("cannot find trades of transaction %s", event.Hash)
+	}
 
-	for _, trade := range trades {
-		makerOrder := models.OrderDao.FindByID(trade.MakerOrderID)
-		takerOrder.PendingAmount = takerOrder.PendingAmount.Sub(trade.Amount)
-		makerOrder.PendingAmount = makerOrder.PendingAmount.Sub(trade.Amount)
+	var failure *settlementFailure
+	if event.Status == common.STATUS_FAILED {
+		failure = settlementFailureOf(transaction, event, trades)
+		utils.Infof("market %s transaction %s failed, reason: %s, faulty orders: %v", m.market.ID, event.Hash, failure.Reason, failure.FaultyOrders)
+	}
 
-		switch event.Status {
-		case common.STATUS_FAILED:
-			takerOrder.CanceledAmount = takerOrder.CanceledAmount.Add(trade.Amount)
-			makerOrder.CanceledAmount = makerOrder.CanceledAmount.Add(trade.Amount)
-		case common.STATUS_SUCCESSFUL:
-			takerOrder.ConfirmedAmount = takerOrder.ConfirmedAmount.Add(trade.Amount)
-			makerOrder.ConfirmedAmount = makerOrder.ConfirmedAmount.Add(trade.Amount)
+	returned, bookMessages := m.settleInBook(trades, failure)
+
+	err := runInTransaction(func(tx *dbTx) error {
+		transaction.Status = event.Status
+		transaction.ExecutedAt = executedAt
+
+		if failure != nil {
+			transaction.FailureReason = failure.Reason
+			transaction.FaultyOrders = strings.Join(failure.FaultyOrders, ",")
 		}
 
-		makerOrder.AutoSetStatusByAmounts()
-		_ = UpdateOrder(makerOrder)
+		err := tx.TransactionDao.UpdateTransaction(transaction)
+		if err != nil {
+			return err
+		}
+
+		err = tx.LaunchLogDao.UpdateLaunchLogsStatusByItemID(event.Status, transaction.ID)
+		if err != nil {
+			return err
+		}
+
+		var orders []*models.Order
+		ordersByID := make(map[string]*models.Order)
+
+		for _, trade := range trades {
+			for _, orderID := range []string{trade.TakerOrderID, trade.MakerOrderID} {
+				order, ok := ordersByID[orderID]
+				if !ok {
+					order = tx.OrderDao.FindByID(orderID)
+					if order == nil {
+						return fmt.Errorf("cannot find order with id %s", orderID)
+					}
+
+					ordersByID[orderID] = order
+					orders = append(orders, order)
+				}
+
+				order.PendingAmount = order.PendingAmount.Sub(trade.Amount)
+
+				switch {
+				case event.Status == common.STATUS_SUCCESSFUL:
+					order.ConfirmedAmount = order.ConfirmedAmount.Add(trade.Amount)
+				case returned[orderID]:
+					order.AvailableAmount = order.AvailableAmount.Add(trade.Amount)
+				default:
+					order.CanceledAmount = order.CanceledAmount.Add(trade.Amount)
+				}
+			}
+
+			trade.Status = event.Status
+			trade.ExecutedAt = executedAt
+			err = UpdateTrade(tx, trade)
+			if err != nil {
+				return err
+			}
+		}
+
+		if event.Status == common.STATUS_SUCCESSFUL {
+			err = tx.CandleDao.AddTrades(tradesInSequence(trades))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, order := range orders {
+			// an order at fault is canceled, with the part of it which is still in the book
+			if failure != nil && failure.isFaulty(order.ID) {
+				order.CanceledAmount = order.CanceledAmount.Add(order.AvailableAmount)
+				order.AvailableAmount = decimal.Zero
+			}
+
+			order.AutoSetStatusByAmounts()
+			err = UpdateOrder(tx, order)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, msg := range bookMessages {
+			err = tx.pushMessage(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 
-		trade.Status = event.Status
-		trade.ExecutedAt = time.Unix(int64(event.Timestamp), 0)
-		_ = UpdateTrade(trade)
+	if err != nil {
+		m.resyncOrderbook()
+		return nil, err
 	}
 
-	takerOrder.AutoSetStatusByAmounts()
-	_ = UpdateOrder(takerOrder)
+	if len(bookMessages) > 0 {
+		m.publishOrderbook()
+	}
+
+	if event.Status == common.STATUS_SUCCESSFUL {
+		m.triggerStopOrders(lastTradePrice(trades))
+	}
 
 	return nil, nil
 }
 
-func NewMarketHandler(ctx context.Context, market *models.Market, engine *engine.Engine) (*MarketHandler, error) {
-	orders := models.OrderDao.FindMarketPendingOrders(market.ID)
-
-	// re-insert available orders into HydroEngine
-	for _, order := range orders {
-		if order.AvailableAmount.LessThanOrEqual(decimal.Zero) {
-			continue
-		}
+// lastTradePrice returns the price of the last trade of a transaction.
+func lastTradePrice(trades []*models.Trade) decimal.Decimal {
+	last := trades[0]
 
-		bookOrder := common.MemoryOrder{
-			MarketID: order.MarketID,
-			ID:       order.ID,
-			Price:    order.Price,
-			Amount:   order.AvailableAmount,
-			Side:     order.Side,
+	for _, trade := range trades {
+		if trade.Sequence > last.Sequence {
+			last = trade
 		}
-		msg := engine.ReInsertOrder(&bookOrder)
-		pushMessage(msg)
 	}
 
+	return last.Price
+}
+
+// tradesInSequence returns the trades of a transaction in the order they were matched.
+func tradesInSequence(trades []*models.Trade) []*models.Trade {
+	sorted := make([]*models.Trade, len(trades))
+	copy(sorted, trades)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Sequence < sorted[j].Sequence
+	})
+
+	return sorted
+}
+
+func NewMarketHandler(ctx context.Context, market *models.Market, kvStore common.IKVStore) (*MarketHandler, error) {
 	marketHandler := MarketHandler{
 		market:    market,
-		eventChan: make(chan []byte),
+		eventChan: make(chan *models.EngineEvent, marketEventBufferSize),
+		events:    newJournalEventQueue(market.ID, utils.ParseInt(os.Getenv("HSK_MARKET_EVENT_QUEUE_SIZE"), defaultMarketEventQueueSize)),
 		ctx:       ctx,
+		orderbook: newOrderbook(market.ID, market.AmountDecimals),
+		stopbook:  newStopbook(),
+		kvStore:   kvStore,
+
+		snapshotInterval: utils.ParseInt(os.Getenv("HSK_ENGINE_SNAPSHOT_INTERVAL"), defaultSnapshotInterval),
+		blockGasBudget:   utils.ParseInt(os.Getenv("HSK_BLOCK_GAS_BUDGET"), defaultBlockGasBudget),
+	}
 
-		hydroEngine: engine,
-	}
-
-	//// todo if Load Snapshot is necessary
-	//res, err := kvStore.Get(common.GetMarketOrderbookSnapshotV2Key(market.ID))
-	//if err == common.KVStoreEmpty {
-	//	// do nothing
-	//} else if err != nil {
-	//	panic(fmt.Errorf("get snapshot error %v", err))
-	//}
-	//
-	//var snapshot struct {
-	//	Sequence uint64 `json:"sequence"`
-	//}
-	//
-	//_ = json.Unmarshal([]byte(res), &snapshot)
+	marketHandler.restoreOrderbook()
+	marketHandler.scheduleExpiries()
 
 	return &marketHandler, nil
 }
@@ -6,9 +6,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
 	"github.com/HydroProtocol/hydro-sdk-backend/common"
-	"github.com/HydroProtocol/hydro-sdk-backend/engine"
 	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
 	"github.com/HydroProtocol/hydro-sdk-backend/utils"
 	"github.com/shopspring/decimal"
@@ -23,6 +23,7 @@ import (
 type marketHandlerSuite struct {
 	suite.Suite
 	marketHandler *MarketHandler
+	kvStore       *common.MockKVStore
 }
 
 const fakeAccount1 = "0x31ebd457b999bf99759602f5ece5aa5033cb56b3"
@@ -76,10 +77,9 @@ func (s *marketHandlerSuite) SetupTest() {
 	kvStore.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	kvStore.On("Get", mock.Anything).Return("", common.KVStoreEmpty)
 	marketHotDai := models.MarketHotDai()
-	marketHandler, _ := NewMarketHandler(context.Background(), marketHotDai, engine.NewEngine(context.Background()))
+	marketHandler, _ := NewMarketHandler(context.Background(), marketHotDai, kvStore)
 	s.marketHandler = marketHandler
-
-	s.marketHandler.hydroEngine.RegisterOrderBookActivitiesHandler(RedisOrderBookActivitiesHandler{})
+	s.kvStore = kvStore
 }
 
 func (s *marketHandlerSuite) TearDownTest() {
@@ -103,7 +103,7 @@ func (s *marketHandlerSuite) TestHandleNewOrder() {
 	//s.Nil(s.marketHandler.orderbook.MaxBid())
 
 	s.AssertChange(func() {
-		_, _ = s.marketHandler.handleNewOrder(sellOrderEvent)
+		_, _, _ = s.marketHandler.handleNewOrder(sellOrderEvent)
 	}, func() int {
 		return models.OrderDao.Count()
 	}, 1)
@@ -196,38 +196,42 @@ func (s *marketHandlerSuite) batchNewOrderTest(b *batchMatchOrdersTest) {
 	if b.whenSuccess != nil {
 		s.SetupTest()
 		b.Reset()
-		_, launchLog := s.batchNewOrderTestPendingPart(b)
-		hash := "fake-success"
-		launchLog.Hash = sql.NullString{
-			hash,
-			true,
-		}
-		models.UpdateLaunchLogToPending(launchLog)
-		takerOrderEvent := common.ConfirmTransactionEvent{
-			Event:  common.Event{},
-			Hash:   hash,
-			Status: common.STATUS_SUCCESSFUL,
+		_, launchLogs := s.batchNewOrderTestPendingPart(b)
+		for i, launchLog := range launchLogs {
+			hash := fmt.Sprintf("fake-success-%d", i)
+			launchLog.Hash = sql.NullString{
+				hash,
+				true,
+			}
+			models.UpdateLaunchLogToPending(launchLog)
+			takerOrderEvent := models.ConfirmTransactionEvent{ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+				Event:  common.Event{},
+				Hash:   hash,
+				Status: common.STATUS_SUCCESSFUL,
+			}}
+			_, _ = s.marketHandler.handleTransactionResult(&takerOrderEvent)
 		}
-		_, _ = s.marketHandler.handleTransactionResult(&takerOrderEvent)
 		s.assertExpectedResult(b, b.whenSuccess)
 	}
 
 	if b.whenFailed != nil {
 		s.SetupTest()
 		b.Reset()
-		_, launchLog := s.batchNewOrderTestPendingPart(b)
-		hash := "fake-failed"
-		launchLog.Hash = sql.NullString{
-			hash,
-			true,
-		}
-		models.UpdateLaunchLogToPending(launchLog)
-		takerOrderEvent := common.ConfirmTransactionEvent{
-			Event:  common.Event{},
-			Hash:   hash,
-			Status: common.STATUS_FAILED,
+		_, launchLogs := s.batchNewOrderTestPendingPart(b)
+		for i, launchLog := range launchLogs {
+			hash := fmt.Sprintf("fake-failed-%d", i)
+			launchLog.Hash = sql.NullString{
+				hash,
+				true,
+			}
+			models.UpdateLaunchLogToPending(launchLog)
+			takerOrderEvent := models.ConfirmTransactionEvent{ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+				Event:  common.Event{},
+				Hash:   hash,
+				Status: common.STATUS_FAILED,
+			}}
+			_, _ = s.marketHandler.handleTransactionResult(&takerOrderEvent)
 		}
-		_, _ = s.marketHandler.handleTransactionResult(&takerOrderEvent)
 		s.assertExpectedResult(b, b.whenFailed)
 	}
 }
@@ -297,7 +301,7 @@ func (s *marketHandlerSuite) assertExpectedResult(b *batchMatchOrdersTest, resul
 	}
 }
 
-func (s *marketHandlerSuite) batchNewOrderTestPendingPart(b *batchMatchOrdersTest) (*models.Transaction, *models.LaunchLog) {
+func (s *marketHandlerSuite) batchNewOrderTestPendingPart(b *batchMatchOrdersTest) ([]*models.Transaction, []*models.LaunchLog) {
 	oldTradesCount := models.TradeDao.Count()
 	oldTransactionsCount := models.TransactionDao.Count()
 
@@ -307,7 +311,7 @@ func (s *marketHandlerSuite) batchNewOrderTestPendingPart(b *batchMatchOrdersTes
 			Order: utils.ToJsonString(makerOrder),
 		}
 
-		_, _ = s.marketHandler.handleNewOrder(&makerOrderEvent)
+		_, _, _ = s.marketHandler.handleNewOrder(&makerOrderEvent)
 	}
 
 	takerOrderEvent := common.NewOrderEvent{
@@ -315,7 +319,7 @@ func (s *marketHandlerSuite) batchNewOrderTestPendingPart(b *batchMatchOrdersTes
 		Order: utils.ToJsonString(b.takerOrder),
 	}
 
-	transaction, launchLog := s.marketHandler.handleNewOrder(&takerOrderEvent)
+	transactions, launchLogs, _ := s.marketHandler.handleNewOrder(&takerOrderEvent)
 
 	newTradesCount := models.TradeDao.Count()
 	newTransactionsCount := models.TransactionDao.Count()
@@ -327,7 +331,7 @@ func (s *marketHandlerSuite) batchNewOrderTestPendingPart(b *batchMatchOrdersTes
 		s.assertExpectedResult(b, b.whenPending)
 	}
 
-	return transaction, launchLog
+	return transactions, launchLogs
 }
 
 func (s *marketHandlerSuite) TestMatchOrders0() {
@@ -409,7 +413,7 @@ func (s *marketHandlerSuite) TestMatchOrders1() {
 		&expectedResult{
 			[][]string{
 				{"0", "0", "0", "100"},
-				{"40", "0", "0", "100"},
+				{"140", "0", "0", "0"},
 			},
 			[]string{common.ORDER_CANCELED, common.ORDER_PENDING},
 			nil,
@@ -460,9 +464,9 @@ func (s *marketHandlerSuite) TestMatchOrders2() {
 		&expectedResult{
 			[][]string{
 				{"0", "0", "0", "80"},
-				{"0", "0", "0", "80"},
+				{"80", "0", "0", "0"},
 			},
-			[]string{common.ORDER_CANCELED, common.ORDER_CANCELED},
+			[]string{common.ORDER_CANCELED, common.ORDER_PENDING},
 			nil,
 		},
 	)
@@ -517,10 +521,10 @@ func (s *marketHandlerSuite) TestMatchOrders3() {
 		},
 		&expectedResult{
 			[][]string{
-				{"20", "0", "0", "80"},
-				{"0", "0", "0", "80"},
+				{"0", "0", "0", "100"},
+				{"80", "0", "0", "0"},
 			},
-			[]string{common.ORDER_PENDING, common.ORDER_CANCELED},
+			[]string{common.ORDER_CANCELED, common.ORDER_PENDING},
 			nil,
 		},
 	)
@@ -605,12 +609,12 @@ func (s *marketHandlerSuite) TestMatchOrders4() {
 		},
 		&expectedResult{
 			[][]string{
-				{"10", "0", "0", "90"},
-				{"0", "0", "0", "20"},
-				{"0", "0", "0", "60"},
-				{"0", "0", "0", "10"},
+				{"0", "0", "0", "100"},
+				{"20", "0", "0", "0"},
+				{"60", "0", "0", "0"},
+				{"10", "0", "0", "0"},
 			},
-			[]string{common.ORDER_PENDING, common.ORDER_CANCELED, common.ORDER_CANCELED, common.ORDER_CANCELED},
+			[]string{common.ORDER_CANCELED, common.ORDER_PENDING, common.ORDER_PENDING, common.ORDER_PENDING},
 			nil,
 		},
 	)
@@ -690,11 +694,11 @@ func (s *marketHandlerSuite) TestMatchOrders5() {
 		&expectedResult{
 			[][]string{
 				{"0", "0", "0", "100"},
-				{"0", "0", "0", "20"},
-				{"0", "0", "0", "60"},
-				{"0", "0", "0", "20"},
+				{"20", "0", "0", "0"},
+				{"60", "0", "0", "0"},
+				{"20", "0", "0", "0"},
 			},
-			[]string{common.ORDER_CANCELED, common.ORDER_CANCELED, common.ORDER_CANCELED, common.ORDER_CANCELED},
+			[]string{common.ORDER_CANCELED, common.ORDER_PENDING, common.ORDER_PENDING, common.ORDER_PENDING},
 			nil,
 		},
 	)
@@ -768,11 +772,11 @@ func (s *marketHandlerSuite) TestMatchOrders6() {
 		&expectedResult{
 			[][]string{
 				{"0", "0", "0", "50"},
-				{"0", "0", "0", "20"},
-				{"30", "0", "0", "30"},
+				{"20", "0", "0", "0"},
+				{"60", "0", "0", "0"},
 				{"20", "0", "0", "0"},
 			},
-			[]string{common.ORDER_CANCELED, common.ORDER_CANCELED, common.ORDER_PENDING, common.ORDER_PENDING},
+			[]string{common.ORDER_CANCELED, common.ORDER_PENDING, common.ORDER_PENDING, common.ORDER_PENDING},
 			nil,
 		},
 	)
@@ -835,6 +839,77 @@ func (s *marketHandlerSuite) assertOrderAmounts(available, pending, confirmed, c
 	s.Equal(canceled, order.CanceledAmount.String(), "Canceled Amount not match")
 }
 
+// 1 v n
+// matches are settled by 2 transactions, the gas budget only allows 2 matches in each
+func (s *marketHandlerSuite) TestMatchOrdersSplitByGasBudget() {
+	_ = os.Setenv("HSK_BLOCK_GAS_BUDGET", "500000")
+	defer os.Unsetenv("HSK_BLOCK_GAS_BUDGET")
+	s.SetupTest()
+
+	s.newBatchMatchOrdersTest(
+		&buildOrderParams{"sell", "140", "100"},
+		[]*buildOrderParams{
+			&buildOrderParams{"buy", "143", "20"},
+			&buildOrderParams{"buy", "142", "60"},
+			&buildOrderParams{"buy", "141", "20"},
+		},
+		3,
+		2,
+		&expectedResult{
+			[][]string{
+				{"0", "100", "0", "0"},
+				{"0", "20", "0", "0"},
+				{"0", "60", "0", "0"},
+				{"0", "20", "0", "0"},
+			},
+			[]string{common.ORDER_PENDING, common.ORDER_PENDING, common.ORDER_PENDING, common.ORDER_PENDING},
+			nil,
+		},
+		&expectedResult{
+			[][]string{
+				{"0", "0", "100", "0"},
+				{"0", "0", "20", "0"},
+				{"0", "0", "60", "0"},
+				{"0", "0", "20", "0"},
+			},
+			[]string{common.ORDER_FULL_FILLED, common.ORDER_FULL_FILLED, common.ORDER_FULL_FILLED, common.ORDER_FULL_FILLED},
+			nil,
+		},
+		&expectedResult{
+			[][]string{
+				{"0", "0", "0", "100"},
+				{"20", "0", "0", "0"},
+				{"60", "0", "0", "0"},
+				{"20", "0", "0", "0"},
+			},
+			[]string{common.ORDER_CANCELED, common.ORDER_PENDING, common.ORDER_PENDING, common.ORDER_PENDING},
+			nil,
+		},
+	)
+}
+
+func (s *marketHandlerSuite) TestSplitMatchItems() {
+	items := []*common.MatchItem{
+		{MatchShouldBeCanceled: true},
+		{},
+		{},
+		{MatchShouldBeCanceled: true},
+		{},
+	}
+
+	chunks := splitMatchItems(items, 250000, 500000)
+
+	s.Equal(2, len(chunks))
+	s.Equal(0, chunks[0].offset)
+	s.Equal(4, len(chunks[0].matchItems))
+	s.Equal(2, chunks[0].settledItems)
+	s.Equal(4, chunks[1].offset)
+	s.Equal(1, chunks[1].settledItems)
+
+	// at least one match in a transaction
+	s.Equal(3, len(splitMatchItems(items, 250000, 100000)))
+}
+
 func (s *marketHandlerSuite) TestCancelOrder() {
 	order1 := newModelOrder("buy", utils.StringToDecimal("0.02"), utils.StringToDecimal("10"))
 	_ = models.OrderDao.InsertOrder(order1)
@@ -861,6 +936,1010 @@ func (s *marketHandlerSuite) TestCancelOrder() {
 	return
 }
 
+func (s *marketHandlerSuite) TestBulkCancelOrders() {
+	var orders []*models.Order
+	for _, price := range []string{"139", "139", "138"} {
+		order := newModelOrder("buy", utils.StringToDecimal(price), utils.StringToDecimal("10"))
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+		orders = append(orders, order)
+	}
+
+	sequence := s.marketHandler.orderbook.Sequence
+
+	// unknown orders are skipped
+	res, err := s.marketHandler.handleBulkCancelOrders(&models.BulkCancelOrdersEvent{
+		Event: common.Event{
+			Type:     models.EventBulkCancelOrders,
+			MarketID: orders[0].MarketID,
+		},
+		IDs: []string{orders[0].ID, orders[2].ID, "0x00"},
+	})
+	s.Nil(err)
+	s.Len(res.([]*models.Order), 2)
+	s.Equal(sequence+2, s.marketHandler.orderbook.Sequence)
+
+	for _, order := range []*models.Order{orders[0], orders[2]} {
+		_, ok := s.marketHandler.orderbook.getOrder(order.ID)
+		s.False(ok)
+
+		order = models.OrderDao.FindByID(order.ID)
+		s.Equal(common.ORDER_CANCELED, order.Status)
+		s.assertOrderAmounts("0", "0", "0", "10", order)
+	}
+
+	_, ok := s.marketHandler.orderbook.getOrder(orders[1].ID)
+	s.True(ok)
+	s.EqualValues(`[["139","10"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Bids))
+}
+
+func (s *marketHandlerSuite) TestAmendOrder() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	first := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	handleNewOrder(first)
+	second := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	handleNewOrder(second)
+
+	amend := func(order *models.Order, availableAmount string) {
+		_, err := s.marketHandler.handleAmendOrder(&models.AmendOrderEvent{
+			Event: common.Event{
+				Type:     models.EventAmendOrder,
+				MarketID: order.MarketID,
+			},
+			ID:              order.ID,
+			AvailableAmount: availableAmount,
+		})
+		s.Nil(err)
+	}
+
+	amend(first, "4")
+	s.assertOrderAmounts("4", "0", "0", "6", models.OrderDao.FindByID(first.ID))
+
+	// an amend can't raise the amount
+	amend(first, "8")
+	s.assertOrderAmounts("4", "0", "0", "6", models.OrderDao.FindByID(first.ID))
+
+	// the amended order keeps its time priority
+	taker := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("5"))
+	handleNewOrder(taker)
+
+	s.assertOrderAmounts("0", "4", "0", "6", models.OrderDao.FindByID(first.ID))
+	s.assertOrderAmounts("9", "1", "0", "0", models.OrderDao.FindByID(second.ID))
+}
+
+func (s *marketHandlerSuite) TestReplaceOrder() {
+	replaced := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+		Event: common.Event{
+			Type:     common.EventNewOrder,
+			MarketID: replaced.MarketID,
+		},
+		Order: utils.ToJsonString(replaced),
+	})
+	s.Nil(err)
+
+	replace := func(replacedOrder, order *models.Order) {
+		_, _, err := s.marketHandler.handleReplaceOrder(&models.ReplaceOrderEvent{
+			Event: common.Event{
+				Type:     models.EventReplaceOrder,
+				MarketID: order.MarketID,
+			},
+			ReplacedOrderID: replacedOrder.ID,
+			Order:           utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	replacing := newModelOrder("sell", utils.StringToDecimal("141"), utils.StringToDecimal("8"))
+	replace(replaced, replacing)
+
+	s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(replaced.ID).Status)
+	s.Equal(common.ORDER_PENDING, models.OrderDao.FindByID(replacing.ID).Status)
+	s.EqualValues(`[["141","8"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+
+	// the replaced order is not open any more, so the new order is rejected
+	rejected := newModelOrder("sell", utils.StringToDecimal("142"), utils.StringToDecimal("8"))
+	replace(replaced, rejected)
+
+	rejected = models.OrderDao.FindByID(rejected.ID)
+	s.Equal(common.ORDER_CANCELED, rejected.Status)
+	s.assertOrderAmounts("0", "0", "0", "8", rejected)
+	s.EqualValues(`[["141","8"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+}
+
+func (s *marketHandlerSuite) TestMarketTradingStates() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	changeState := func(tradingState string) {
+		_, err := s.marketHandler.handleChangeMarketState(&models.ChangeMarketStateEvent{
+			Event: common.Event{
+				Type:     models.EventChangeMarketState,
+				MarketID: s.marketHandler.market.ID,
+			},
+			TradingState: tradingState,
+		})
+		s.Nil(err)
+	}
+
+	resting := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	handleNewOrder(resting)
+
+	// no new orders while the market is halted or cancel only, the book is kept
+	for _, tradingState := range []string{models.MARKET_STATE_HALTED, models.MARKET_STATE_CANCEL_ONLY} {
+		changeState(tradingState)
+
+		order := newModelOrder("sell", utils.StringToDecimal("141"), utils.StringToDecimal("1"))
+		handleNewOrder(order)
+		s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(order.ID).Status)
+
+		_, ok := s.marketHandler.orderbook.getOrder(resting.ID)
+		s.True(ok)
+	}
+
+	// orders which would match are rejected while the market is post only
+	changeState(models.MARKET_STATE_POST_ONLY)
+
+	taker := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+	s.AssertChange(func() {
+		handleNewOrder(taker)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 0)
+	s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(taker.ID).Status)
+
+	maker := newModelOrder("buy", utils.StringToDecimal("139"), utils.StringToDecimal("1"))
+	handleNewOrder(maker)
+	s.Equal(common.ORDER_PENDING, models.OrderDao.FindByID(maker.ID).Status)
+
+	changeState(models.MARKET_STATE_TRADING)
+
+	taker = newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+	s.AssertChange(func() {
+		handleNewOrder(taker)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 1)
+}
+
+func (s *marketHandlerSuite) TestCallAuction() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	changeState := func(tradingState string) {
+		_, err := s.marketHandler.handleChangeMarketState(&models.ChangeMarketStateEvent{
+			Event: common.Event{
+				Type:     models.EventChangeMarketState,
+				MarketID: s.marketHandler.market.ID,
+			},
+			TradingState: tradingState,
+		})
+		s.Nil(err)
+	}
+
+	changeState(models.MARKET_STATE_CALL_AUCTION)
+
+	// the orders collect without matching, the book is crossed
+	bigBuy := newModelOrder("buy", utils.StringToDecimal("105"), utils.StringToDecimal("10"))
+	smallBuy := newModelOrder("buy", utils.StringToDecimal("101"), utils.StringToDecimal("5"))
+	lowSell := newModelOrder("sell", utils.StringToDecimal("100"), utils.StringToDecimal("8"))
+	highSell := newModelOrder("sell", utils.StringToDecimal("103"), utils.StringToDecimal("6"))
+
+	s.AssertChange(func() {
+		for _, order := range []*models.Order{bigBuy, smallBuy, lowSell, highSell} {
+			handleNewOrder(order)
+		}
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 0)
+
+	// orders which don't rest in the book are rejected
+	ioc := newModelOrder("buy", utils.StringToDecimal("105"), utils.StringToDecimal("1"))
+	ioc.TimeInForce = models.TIME_IN_FORCE_IOC
+	handleNewOrder(ioc)
+	s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(ioc.ID).Status)
+
+	// 10 trade at 103 and 105, 103 leaves the smaller surplus of sells
+	auction := s.marketHandler.orderbook.callAuctionPrice()
+	s.Equal("103", auction.Price.String())
+	s.Equal("10", auction.Volume.String())
+	s.Equal("4", auction.Surplus.String())
+	s.Equal("sell", auction.SurplusSide)
+
+	// the buys take, the big buy fills against both sells at the auction price and the small buy is left resting
+	s.AssertChange(func() {
+		changeState(models.MARKET_STATE_TRADING)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 2)
+
+	s.Equal(models.MARKET_STATE_TRADING, s.marketHandler.market.TradingState)
+
+	trades := models.TradeDao.FindMarketTradesPage(&models.TradeQuery{MarketID: s.marketHandler.market.ID, Limit: 10})
+	s.Equal(2, len(trades))
+	for _, trade := range trades {
+		s.Equal(bigBuy.ID, trade.TakerOrderID)
+		s.Equal(auction.Price.String(), trade.Price.String())
+	}
+
+	s.assertOrderAmounts("0", "10", "0", "0", models.OrderDao.FindByID(bigBuy.ID))
+	s.assertOrderAmounts("5", "0", "0", "0", models.OrderDao.FindByID(smallBuy.ID))
+	s.assertOrderAmounts("0", "8", "0", "0", models.OrderDao.FindByID(lowSell.ID))
+	s.assertOrderAmounts("4", "2", "0", "0", models.OrderDao.FindByID(highSell.ID))
+	s.EqualValues(`[["101","5"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Bids))
+	s.EqualValues(`[["103","4"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+	s.False(s.marketHandler.orderbook.callAuctionPrice().Volume.IsPositive())
+	s.Nil(checkOrderbook(s.marketHandler.orderbook, models.OrderDao.FindMarketPendingOrders(s.marketHandler.market.ID)))
+}
+
+func (s *marketHandlerSuite) TestTriggerStopOrder() {
+	handleNewOrder := func(order *models.Order) []*models.LaunchLog {
+		event := common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		}
+
+		_, launchLogs, err := s.marketHandler.handleNewOrder(&event)
+		s.Nil(err)
+		return launchLogs
+	}
+
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10")))
+
+	stopOrder := newModelOrder("buy", utils.StringToDecimal("141"), utils.StringToDecimal("5"))
+	stopOrder.Type = models.ORDER_TYPE_STOP_LIMIT
+	stopOrder.Status = models.ORDER_UNTRIGGERED
+	stopOrder.StopPrice = utils.StringToDecimal("140")
+	handleNewOrder(stopOrder)
+
+	// waiting orders are not in the book
+	_, ok := s.marketHandler.stopbook.getOrder(stopOrder.ID)
+	s.True(ok)
+	_, ok = s.marketHandler.orderbook.getOrder(stopOrder.ID)
+	s.False(ok)
+	s.Equal(models.ORDER_UNTRIGGERED, models.OrderDao.FindByID(stopOrder.ID).Status)
+
+	launchLogs := handleNewOrder(newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("2")))
+	s.Equal(1, len(launchLogs))
+
+	// a pending trade doesn't trigger stop orders
+	_, ok = s.marketHandler.stopbook.getOrder(stopOrder.ID)
+	s.True(ok)
+
+	launchLogs[0].Hash = sql.NullString{String: "fake-success-0", Valid: true}
+	models.UpdateLaunchLogToPending(launchLogs[0])
+
+	_, err := s.marketHandler.handleTransactionResult(&models.ConfirmTransactionEvent{ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+		Event:  common.Event{},
+		Hash:   "fake-success-0",
+		Status: common.STATUS_SUCCESSFUL,
+	}})
+	s.Nil(err)
+
+	_, ok = s.marketHandler.stopbook.getOrder(stopOrder.ID)
+	s.False(ok)
+
+	triggered := models.OrderDao.FindByID(stopOrder.ID)
+	s.Equal(common.ORDER_PENDING, triggered.Status)
+	s.assertOrderAmounts("0", "5", "0", "0", triggered)
+}
+
+func (s *marketHandlerSuite) TestFailedStopOrderKeepsOthersWaiting() {
+	var stopOrders []*models.Order
+	for i := 0; i < 2; i++ {
+		// nothing in the book fills them, they are rejected once triggered
+		stopOrder := newModelOrder("buy", utils.StringToDecimal("141"), utils.StringToDecimal("5"))
+		stopOrder.Type = models.ORDER_TYPE_STOP_LIMIT
+		stopOrder.Status = models.ORDER_UNTRIGGERED
+		stopOrder.StopPrice = utils.StringToDecimal("140")
+		stopOrder.TimeInForce = models.TIME_IN_FORCE_FOK
+		s.Nil(models.OrderDao.InsertOrder(stopOrder))
+		s.marketHandler.stopbook.insertOrder(stopOrder)
+		stopOrders = append(stopOrders, stopOrder)
+	}
+
+	price := utils.StringToDecimal("140")
+	s.marketHandler.matchTriggeredOrders(s.marketHandler.stopbook.trigger(price), price, func(tx *dbTx, order *models.Order) error {
+		return fmt.Errorf("save order %s failed", order.ID)
+	})
+
+	for _, stopOrder := range stopOrders {
+		_, ok := s.marketHandler.stopbook.getOrder(stopOrder.ID)
+		s.True(ok)
+		s.Equal(models.ORDER_UNTRIGGERED, models.OrderDao.FindByID(stopOrder.ID).Status)
+	}
+}
+
+func (s *marketHandlerSuite) TestCancelStopOrder() {
+	stopOrder := newModelOrder("sell", utils.StringToDecimal("130"), utils.StringToDecimal("5"))
+	stopOrder.Type = models.ORDER_TYPE_STOP_MARKET
+	stopOrder.Status = models.ORDER_UNTRIGGERED
+	stopOrder.StopPrice = utils.StringToDecimal("135")
+
+	_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+		Event: common.Event{
+			Type:     common.EventNewOrder,
+			MarketID: stopOrder.MarketID,
+		},
+		Order: utils.ToJsonString(stopOrder),
+	})
+	s.Nil(err)
+
+	_, err = s.marketHandler.handleCancelOrder(&common.CancelOrderEvent{
+		Event: common.Event{
+			Type:     common.EventCancelOrder,
+			MarketID: stopOrder.MarketID,
+		},
+		ID: stopOrder.ID,
+	})
+	s.Nil(err)
+
+	_, ok := s.marketHandler.stopbook.getOrder(stopOrder.ID)
+	s.False(ok)
+	s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(stopOrder.ID).Status)
+}
+
+func (s *marketHandlerSuite) TestRejectPostOnlyOrder() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	newPostOnlyOrder := func(side string, price, amount decimal.Decimal) *models.Order {
+		order := newModelOrder(side, price, amount)
+		orderJson := order.GetOrderJson()
+		orderJson.Data = ethereum.GetOrderData(1, side == "sell", false, 999999999999, 0, 0, 0, rand.Uint64(), true)
+		order.JSON = utils.ToJsonString(orderJson)
+		return order
+	}
+
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10")))
+
+	// it would take the sell order
+	crossing := newPostOnlyOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("5"))
+	s.AssertChange(func() {
+		handleNewOrder(crossing)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 0)
+
+	_, ok := s.marketHandler.orderbook.getOrder(crossing.ID)
+	s.False(ok)
+
+	rejected := models.OrderDao.FindByID(crossing.ID)
+	s.Equal(common.ORDER_CANCELED, rejected.Status)
+	s.assertOrderAmounts("0", "0", "0", "5", rejected)
+
+	// it rests below the sell order
+	resting := newPostOnlyOrder("buy", utils.StringToDecimal("139"), utils.StringToDecimal("5"))
+	handleNewOrder(resting)
+
+	_, ok = s.marketHandler.orderbook.getOrder(resting.ID)
+	s.True(ok)
+	s.Equal(common.ORDER_PENDING, models.OrderDao.FindByID(resting.ID).Status)
+}
+
+func (s *marketHandlerSuite) TestTimeInForce() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10")))
+
+	// there is not enough to fill it, no trade is written
+	fok := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("11"))
+	fok.TimeInForce = models.TIME_IN_FORCE_FOK
+	s.AssertChange(func() {
+		handleNewOrder(fok)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 0)
+
+	fok = models.OrderDao.FindByID(fok.ID)
+	s.Equal(common.ORDER_CANCELED, fok.Status)
+	s.assertOrderAmounts("0", "0", "0", "11", fok)
+
+	// the part which is not filled is canceled instead of resting
+	ioc := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("12"))
+	ioc.TimeInForce = models.TIME_IN_FORCE_IOC
+	s.AssertChange(func() {
+		handleNewOrder(ioc)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 1)
+
+	_, ok := s.marketHandler.orderbook.getOrder(ioc.ID)
+	s.False(ok)
+
+	ioc = models.OrderDao.FindByID(ioc.ID)
+	s.Equal(common.ORDER_PENDING, ioc.Status)
+	s.assertOrderAmounts("0", "10", "0", "2", ioc)
+
+	// nothing to match, the whole order is canceled
+	ioc = newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+	ioc.TimeInForce = models.TIME_IN_FORCE_IOC
+	handleNewOrder(ioc)
+
+	ioc = models.OrderDao.FindByID(ioc.ID)
+	s.Equal(common.ORDER_CANCELED, ioc.Status)
+	s.assertOrderAmounts("0", "0", "0", "1", ioc)
+}
+
+func (s *marketHandlerSuite) TestMarketOrderMaxSlippage() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	s.marketHandler.market.MarketOrderMaxSlippage = utils.StringToDecimal("0.1")
+
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("100"), utils.StringToDecimal("10")))
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("105"), utils.StringToDecimal("10")))
+	farSell := newModelOrder("sell", utils.StringToDecimal("120"), utils.StringToDecimal("10"))
+	handleNewOrder(farSell)
+
+	// the order would take all sells by its price, it stops at 110 which is 10% above the best ask
+	marketBuy := newModelOrder("buy", utils.StringToDecimal("200"), utils.StringToDecimal("30"))
+	marketBuy.Type = "market"
+	s.AssertChange(func() {
+		handleNewOrder(marketBuy)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 2)
+
+	_, ok := s.marketHandler.orderbook.getOrder(marketBuy.ID)
+	s.False(ok)
+
+	marketBuy = models.OrderDao.FindByID(marketBuy.ID)
+	s.assertOrderAmounts("0", "20", "0", "10", marketBuy)
+
+	_, ok = s.marketHandler.orderbook.getOrder(farSell.ID)
+	s.True(ok)
+
+	// limit orders are not limited
+	limitBuy := newModelOrder("buy", utils.StringToDecimal("200"), utils.StringToDecimal("10"))
+	s.AssertChange(func() {
+		handleNewOrder(limitBuy)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 1)
+}
+
+func (s *marketHandlerSuite) TestSelfTradePrevention() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	ownSell := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("5"))
+	ownSell.TraderAddress = fakeAccount1
+	handleNewOrder(ownSell)
+	otherSell := newModelOrder("sell", utils.StringToDecimal("141"), utils.StringToDecimal("3"))
+	handleNewOrder(otherSell)
+
+	// both orders are reduced by the amount they would match
+	decrement := newModelOrder("buy", utils.StringToDecimal("141"), utils.StringToDecimal("4"))
+	decrement.SelfTradePrevention = models.SELF_TRADE_PREVENTION_DECREMENT_AND_CANCEL
+	s.AssertChange(func() {
+		handleNewOrder(decrement)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 0)
+
+	s.assertOrderAmounts("0", "0", "0", "4", models.OrderDao.FindByID(decrement.ID))
+	s.assertOrderAmounts("1", "0", "0", "4", models.OrderDao.FindByID(ownSell.ID))
+	bookOrder, _ := s.marketHandler.orderbook.getOrder(ownSell.ID)
+	s.Equal("1", bookOrder.Amount.String())
+
+	// the resting order is canceled and the taker order matches the next one
+	cancelOldest := newModelOrder("buy", utils.StringToDecimal("141"), utils.StringToDecimal("4"))
+	cancelOldest.SelfTradePrevention = models.SELF_TRADE_PREVENTION_CANCEL_OLDEST
+	s.AssertChange(func() {
+		handleNewOrder(cancelOldest)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 1)
+
+	ownSell = models.OrderDao.FindByID(ownSell.ID)
+	s.Equal(common.ORDER_CANCELED, ownSell.Status)
+	s.assertOrderAmounts("0", "0", "0", "5", ownSell)
+	s.assertOrderAmounts("1", "3", "0", "0", models.OrderDao.FindByID(cancelOldest.ID))
+	_, ok := s.marketHandler.orderbook.getOrder(ownSell.ID)
+	s.False(ok)
+
+	// the taker order is canceled and the resting order is kept
+	cancelNewest := newModelOrder("sell", utils.StringToDecimal("141"), utils.StringToDecimal("2"))
+	cancelNewest.TraderAddress = fakeAccount1
+	cancelNewest.SelfTradePrevention = models.SELF_TRADE_PREVENTION_CANCEL_NEWEST
+	s.AssertChange(func() {
+		handleNewOrder(cancelNewest)
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 0)
+
+	cancelNewest = models.OrderDao.FindByID(cancelNewest.ID)
+	s.Equal(common.ORDER_CANCELED, cancelNewest.Status)
+	s.assertOrderAmounts("0", "0", "0", "2", cancelNewest)
+	_, ok = s.marketHandler.orderbook.getOrder(cancelOldest.ID)
+	s.True(ok)
+}
+
+func (s *marketHandlerSuite) TestExpireGoodTilTimeOrder() {
+	eventQueue := &common.MockQueue{}
+	eventQueue.On("Push", mock.Anything).Return(nil)
+	s.marketHandler.eventQueue = eventQueue
+
+	gtt := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+	gtt.TimeInForce = models.TIME_IN_FORCE_GTT
+
+	_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+		Event: common.Event{
+			Type:     common.EventNewOrder,
+			MarketID: gtt.MarketID,
+		},
+		Order: utils.ToJsonString(gtt),
+	})
+	s.Nil(err)
+
+	expiredAt := time.Unix(getOrderExpiredAt(gtt), 0)
+
+	s.marketHandler.expireOrders(expiredAt.Add(-time.Second))
+	s.Equal(0, len(eventQueue.Buffers))
+
+	s.marketHandler.expireOrders(expiredAt)
+	s.Equal(1, len(eventQueue.Buffers))
+
+	var event common.CancelOrderEvent
+	_ = json.Unmarshal(eventQueue.Buffers[0], &event)
+	s.Equal(common.EventCancelOrder, event.Type)
+	s.Equal(gtt.ID, event.ID)
+
+	// it is canceled only once
+	s.marketHandler.expireOrders(expiredAt.Add(time.Second))
+	s.Equal(1, len(eventQueue.Buffers))
+}
+
+func (s *marketHandlerSuite) TestExpiredOrders() {
+	newExpiringOrder := func(side string, expiredAt int64) *models.Order {
+		order := newModelOrder(side, utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+		orderJson := order.GetOrderJson()
+		orderJson.Data = ethereum.GetOrderData(1, side == "sell", false, uint64(expiredAt), 0, 0, 0, rand.Uint64(), false)
+		order.JSON = utils.ToJsonString(orderJson)
+		return order
+	}
+
+	handleNewOrder := func(order *models.Order) {
+		event := common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		}
+
+		journalEvent, _ := appendJournal(event.Event, []byte(utils.ToJsonString(event)))
+		s.Nil(handleEvent(s.marketHandler, journalEvent))
+	}
+
+	eventQueue := &common.MockQueue{}
+	eventQueue.On("Push", mock.Anything).Return(nil)
+	s.marketHandler.eventQueue = eventQueue
+
+	// expired before it reached the engine
+	expired := newExpiringOrder("buy", time.Now().Unix()-10)
+	handleNewOrder(expired)
+
+	_, ok := s.marketHandler.orderbook.getOrder(expired.ID)
+	s.False(ok)
+	s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(expired.ID).Status)
+
+	// expires while it rests in the book
+	expiring := newExpiringOrder("sell", time.Now().Unix()+60)
+	handleNewOrder(expiring)
+
+	_, ok = s.marketHandler.orderbook.getOrder(expiring.ID)
+	s.True(ok)
+
+	s.marketHandler.expireOrders(time.Now())
+	s.Equal(0, len(eventQueue.Buffers))
+
+	s.marketHandler.expireOrders(time.Now().Add(time.Minute))
+	s.Equal(1, len(eventQueue.Buffers))
+
+	var event common.CancelOrderEvent
+	_ = json.Unmarshal(eventQueue.Buffers[0], &event)
+	s.Equal(expiring.ID, event.ID)
+
+	_, err := s.marketHandler.handleCancelOrder(&event)
+	s.Nil(err)
+
+	_, ok = s.marketHandler.orderbook.getOrder(expiring.ID)
+	s.False(ok)
+	s.Equal(common.ORDER_CANCELED, models.OrderDao.FindByID(expiring.ID).Status)
+}
+
+func (s *marketHandlerSuite) TestExpiriesAreBounded() {
+	s.marketHandler.scheduleExpiries()
+
+	// signed with the default expiry far in the future
+	gtc := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+	s.marketHandler.scheduleExpiry(gtc)
+	s.Equal(0, s.marketHandler.expiries.Len())
+
+	gtt := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("1"))
+	gtt.TimeInForce = models.TIME_IN_FORCE_GTT
+	s.marketHandler.scheduleExpiry(gtt)
+	s.Equal(1, s.marketHandler.expiries.Len())
+
+	// the expiries of orders which left the books are dropped
+	for i := 0; i < minExpiriesCompaction; i++ {
+		s.marketHandler.scheduleExpiry(gtt)
+	}
+
+	s.marketHandler.compactExpiries()
+	s.Equal(0, s.marketHandler.expiries.Len())
+}
+
+func (s *marketHandlerSuite) TestRestoreOrderbookFromSnapshot() {
+	handleNewOrder := func(order *models.Order) {
+		event := common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		}
+
+		journalEvent, _ := appendJournal(event.Event, []byte(utils.ToJsonString(event)))
+		_ = handleEvent(s.marketHandler, journalEvent)
+	}
+
+	handleNewOrder(newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("20")))
+	handleNewOrder(newModelOrder("buy", utils.StringToDecimal("141"), utils.StringToDecimal("10")))
+	s.marketHandler.saveSnapshot()
+
+	// handled after the snapshot, it has to be replayed
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("141"), utils.StringToDecimal("5")))
+
+	var snapshot string
+	for _, call := range s.kvStore.Calls {
+		if call.Method == "Set" && call.Arguments.String(0) == getMarketSnapshotKey(s.marketHandler.market.ID) {
+			snapshot = call.Arguments.String(1)
+		}
+	}
+	s.NotEmpty(snapshot)
+
+	kvStore := &common.MockKVStore{}
+	kvStore.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	kvStore.On("Get", getMarketSnapshotKey(s.marketHandler.market.ID)).Return(snapshot, nil)
+	kvStore.On("Get", mock.Anything).Return("", common.KVStoreEmpty)
+
+	restored, _ := NewMarketHandler(context.Background(), s.marketHandler.market, kvStore)
+
+	s.Equal(s.marketHandler.orderbook.Sequence, restored.orderbook.Sequence)
+	s.Equal(s.marketHandler.lastEventID, restored.lastEventID)
+	s.Equal(utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2()), utils.ToJsonString(restored.orderbook.SnapshotV2()))
+}
+
+func (s *marketHandlerSuite) TestUnhandledEventsAreHandledAfterRestore() {
+	journalNewOrder := func(order *models.Order) *models.EngineEvent {
+		event := common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		}
+
+		journalEvent, _ := appendJournal(event.Event, []byte(utils.ToJsonString(event)))
+		return journalEvent
+	}
+
+	handled := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("20"))
+	s.Nil(handleEvent(s.marketHandler, journalNewOrder(handled)))
+	s.marketHandler.saveSnapshot()
+
+	// journaled, but the engine stopped before the market handled it
+	unhandled := newModelOrder("buy", utils.StringToDecimal("141"), utils.StringToDecimal("10"))
+	unhandledEvent := journalNewOrder(unhandled)
+
+	var snapshot string
+	for _, call := range s.kvStore.Calls {
+		if call.Method == "Set" && call.Arguments.String(0) == getMarketSnapshotKey(s.marketHandler.market.ID) {
+			snapshot = call.Arguments.String(1)
+		}
+	}
+
+	kvStore := &common.MockKVStore{}
+	kvStore.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	kvStore.On("Get", getMarketSnapshotKey(s.marketHandler.market.ID)).Return(snapshot, nil)
+	kvStore.On("Get", mock.Anything).Return("", common.KVStoreEmpty)
+
+	restored, _ := NewMarketHandler(context.Background(), s.marketHandler.market, kvStore)
+	s.Equal(1, len(restored.unhandledEvents))
+
+	// it is not replayed as if it was handled
+	_, ok := restored.orderbook.getOrder(unhandled.ID)
+	s.False(ok)
+
+	go restored.events.forward(restored.eventChan)
+	restored.Stop()
+	restored.Run()
+
+	_, ok = restored.orderbook.getOrder(unhandled.ID)
+	s.True(ok)
+	s.NotNil(models.OrderDao.FindByID(unhandled.ID))
+
+	handledEventID, _ := models.EngineEventDao.FindMarketHandledEvent(s.marketHandler.market.ID)
+	s.Equal(unhandledEvent.ID, handledEventID)
+}
+
+// panicKVStore panics when the key is read, like a market whose snapshot can't be loaded.
+type panicKVStore struct {
+	common.IKVStore
+	panicKey string
+}
+
+func (s *panicKVStore) Get(key string) (string, error) {
+	if key == s.panicKey {
+		panic("broken snapshot")
+	}
+
+	return s.IKVStore.Get(key)
+}
+
+func (s *marketHandlerSuite) TestRestart() {
+	market := s.marketHandler.market
+	market.IsPublished = true
+	s.Nil(models.MarketDao.UpdateMarket(market))
+
+	broken := *models.MarketHotDai()
+	broken.ID = "BAD-DAI"
+	broken.IsPublished = true
+	s.Nil(models.MarketDao.InsertMarket(&broken))
+
+	memory := connection.NewMemory()
+	kvStore := &panicKVStore{IKVStore: memory.KVStore(), panicKey: getMarketSnapshotKey(broken.ID)}
+
+	engine := &DexEngine{
+		ctx:              context.Background(),
+		eventQueue:       memory.Queue(context.Background(), common.HYDRO_ENGINE_EVENTS_QUEUE_KEY),
+		marketHandlerMap: make(map[string]*MarketHandler),
+		kvStore:          kvStore,
+	}
+
+	marketHandler, err := engine.newMarket(market.ID)
+	s.Nil(err)
+	runMarket(engine, marketHandler)
+
+	// the orders are dispatched to the market, but not handled yet when it is restarted
+	var batch [][]byte
+	var orders []*models.Order
+	for i, side := range []string{"buy", "buy", "sell", "sell"} {
+		order := newModelOrder(side, decimal.New(int64(140+i), 0), utils.StringToDecimal("1"))
+		orders = append(orders, order)
+
+		batch = append(batch, []byte(utils.ToJsonString(common.NewOrderEvent{
+			Event: common.Event{Type: common.EventNewOrder, MarketID: market.ID},
+			Order: utils.ToJsonString(order),
+		})))
+	}
+
+	engine.dispatchEvents(batch)
+	engine.restart("restart-1")
+
+	// the events are handled before the market is loaded again
+	for _, order := range orders {
+		s.NotNil(models.OrderDao.FindByID(order.ID))
+	}
+
+	reloaded, ok := engine.marketHandlerMap[market.ID]
+	s.True(ok)
+	s.NotEqual(marketHandler, reloaded)
+	s.Nil(checkOrderbook(reloaded.orderbook, models.OrderDao.FindMarketPendingOrders(market.ID)))
+	s.Nil(checkStopbook(reloaded.stopbook, models.OrderDao.FindMarketUntriggeredOrders(market.ID)))
+
+	// the broken market doesn't stop the others from being loaded, and the progress ends with the failure
+	_, ok = engine.marketHandlerMap[broken.ID]
+	s.False(ok)
+
+	progress, err := LoadRestartProgress(kvStore)
+	s.Nil(err)
+	s.Equal("restart-1", progress.RestartID)
+	s.Equal(RestartStatusFailed, progress.Status)
+	s.Equal(2, progress.TotalMarkets)
+	s.Equal([]string{market.ID}, progress.LoadedMarkets)
+	s.Contains(progress.FailedMarkets[broken.ID], "broken snapshot")
+
+	kvStore.panicKey = ""
+	engine.restart("restart-2")
+
+	progress, err = LoadRestartProgress(kvStore)
+	s.Nil(err)
+	s.Equal("restart-2", progress.RestartID)
+	s.Equal(RestartStatusDone, progress.Status)
+	s.ElementsMatch([]string{market.ID, broken.ID}, progress.LoadedMarkets)
+	s.Empty(progress.FailedMarkets)
+
+	for marketID := range engine.marketHandlerMap {
+		engine.closeMarket(marketID)
+	}
+	engine.marketsWg.Wait()
+}
+
+func (s *marketHandlerSuite) TestConfirmedTradesAddToCandles() {
+	handleNewOrder := func(order *models.Order) []*models.LaunchLog {
+		_, launchLogs, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+		return launchLogs
+	}
+
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("130"), utils.StringToDecimal("1")))
+	handleNewOrder(newModelOrder("sell", utils.StringToDecimal("135"), utils.StringToDecimal("1")))
+
+	launchLogs := handleNewOrder(newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("2")))
+	s.Equal(1, len(launchLogs))
+
+	launchLogs[0].Hash = sql.NullString{String: "candle-success", Valid: true}
+	_ = models.UpdateLaunchLogToPending(launchLogs[0])
+
+	_, err := s.marketHandler.handleTransactionResult(&models.ConfirmTransactionEvent{ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+		Hash:      "candle-success",
+		Status:    common.STATUS_SUCCESSFUL,
+		Timestamp: 1560000030,
+	}})
+	s.Nil(err)
+
+	candles := models.CandleDao.FindCandles("HOT-DAI", 60, 1560000000, 1560000000)
+	s.Equal(1, len(candles))
+	s.Equal("130", candles[0].Open.String())
+	s.Equal("135", candles[0].High.String())
+	s.Equal("135", candles[0].Close.String())
+	s.Equal("2", candles[0].Volume.String())
+	s.Equal(2, candles[0].TradeCount)
+
+	// a second confirmation of the same transaction doesn't count its trades again
+	_, err = s.marketHandler.handleTransactionResult(&models.ConfirmTransactionEvent{ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+		Hash:      "candle-success",
+		Status:    common.STATUS_SUCCESSFUL,
+		Timestamp: 1560000030,
+	}})
+	s.Nil(err)
+
+	candles = models.CandleDao.FindCandles("HOT-DAI", 60, 1560000000, 1560000000)
+	s.Equal(1, len(candles))
+	s.Equal("2", candles[0].Volume.String())
+	s.Equal(2, candles[0].TradeCount)
+}
+
+func (s *marketHandlerSuite) TestMarketConfig() {
+	config := *s.marketHandler.market
+	config.TakerFeeRate = utils.StringToDecimal("0.005")
+	config.AmountDecimals = 3
+	config.TradingState = models.MARKET_STATE_HALTED
+
+	_, err := s.marketHandler.handleMarketConfig(&models.MarketConfigEvent{Market: &config})
+	s.Nil(err)
+
+	// the trading state only changes with its own event
+	s.Equal("0.005", s.marketHandler.market.TakerFeeRate.String())
+	s.Equal(3, s.marketHandler.orderbook.amountDecimals)
+	s.NotEqual(models.MARKET_STATE_HALTED, s.marketHandler.market.TradingState)
+}
+
+func (s *marketHandlerSuite) TestReplayUsesJournaledMarketConfig() {
+	config := *s.marketHandler.market
+	config.TakerFeeRate = utils.StringToDecimal("0.007")
+
+	event := models.MarketConfigEvent{
+		Event:  common.Event{Type: models.EventMarketConfig, MarketID: config.ID},
+		Market: &config,
+	}
+
+	replayer := NewReplayer()
+	err := replayer.Replay(&models.EngineEvent{ID: 1, MarketID: config.ID, Type: event.Type, Payload: utils.ToJsonString(event)})
+	s.Nil(err)
+
+	marketHandler, err := replayer.marketHandler(config.ID)
+	s.Nil(err)
+	s.Equal("0.007", marketHandler.market.TakerFeeRate.String())
+}
+
+func (s *marketHandlerSuite) TestReturnedAmountIsOneBookEvent() {
+	book := newOrderbook("HOT-DAI", 5)
+
+	newSellOrder := func(id string) *common.MemoryOrder {
+		return &common.MemoryOrder{ID: id, MarketID: "HOT-DAI", Side: "sell", Price: utils.StringToDecimal("100"), Amount: utils.StringToDecimal("1")}
+	}
+
+	first := newSellOrder("first")
+	book.insertOrder(first, decimal.Zero)
+	book.insertOrder(newSellOrder("second"), decimal.Zero)
+	book.insertOrder(newSellOrder("third"), decimal.Zero)
+
+	// the first order is matched, and its settlement fails
+	firstBookOrder, _ := book.getOrder(first.ID)
+	book.addSettling(first, first.Amount, firstBookOrder.Priority, decimal.Zero)
+	book.takeOrder(first.ID)
+
+	sequence := book.Sequence
+	e, ok := book.returnAmount(first.ID, utils.StringToDecimal("1"))
+	s.True(ok)
+	s.Equal("1", e.Amount.String())
+	s.Equal(sequence+1, book.Sequence)
+
+	// it keeps its place in front of the level
+	taker := &common.MemoryOrder{ID: "taker", MarketID: "HOT-DAI", Side: "buy", Price: utils.StringToDecimal("100"), Amount: utils.StringToDecimal("1")}
+	result := book.ExecuteMatch(taker, 5)
+	s.Equal(first.ID, result.MatchItems[0].MakerOrder.ID)
+}
+
 func newModelOrder(side string, price, amount decimal.Decimal) *models.Order {
 	var trader string
 	if side == "buy" {
@@ -915,6 +1994,180 @@ func newModelOrder(side string, price, amount decimal.Decimal) *models.Order {
 	}
 }
 
+func (s *marketHandlerSuite) TestFailedSettlement() {
+	handleNewOrder := func(order *models.Order) []*models.LaunchLog {
+		_, launchLogs, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+		return launchLogs
+	}
+
+	fail := func(launchLog *models.LaunchLog, hash, reason string, faultyOrders ...string) {
+		launchLog.Hash = sql.NullString{String: hash, Valid: true}
+		_ = models.UpdateLaunchLogToPending(launchLog)
+
+		_, err := s.marketHandler.handleTransactionResult(&models.ConfirmTransactionEvent{
+			ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+				Hash:   hash,
+				Status: common.STATUS_FAILED,
+			},
+			FailureReason: reason,
+			FaultyOrders:  faultyOrders,
+		})
+		s.Nil(err)
+	}
+
+	first := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	handleNewOrder(first)
+	second := newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	handleNewOrder(second)
+
+	// without a diagnosis, the taker is held at fault and the maker goes back in front of the later order
+	taker := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	fail(handleNewOrder(taker)[0], "failed-unknown", "")
+
+	transaction := models.TransactionDao.FindTransactionByHash("failed-unknown")
+	s.Equal(models.SETTLEMENT_FAILURE_UNKNOWN, transaction.FailureReason)
+	s.Equal(taker.ID, transaction.FaultyOrders)
+
+	s.assertOrderAmounts("0", "0", "0", "10", models.OrderDao.FindByID(taker.ID))
+	s.assertOrderAmounts("10", "0", "0", "0", models.OrderDao.FindByID(first.ID))
+	s.Equal(first.ID, s.marketHandler.orderbook.restingOrders()[0].ID)
+	s.Equal(second.ID, s.marketHandler.orderbook.restingOrders()[1].ID)
+	s.EqualValues(`[["140","20"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Bids))
+	s.Len(s.marketHandler.orderbook.settling, 0)
+
+	_, err := s.marketHandler.handleCancelOrder(&common.CancelOrderEvent{ID: second.ID})
+	s.Nil(err)
+
+	// the watcher found the buyer doesn't hold the quote token, the maker order is canceled and the taker rests in the book
+	taker = newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("4"))
+	fail(handleNewOrder(taker)[0], "failed-balance", models.SETTLEMENT_FAILURE_BALANCE, first.ID)
+
+	transaction = models.TransactionDao.FindTransactionByHash("failed-balance")
+	s.Equal(models.SETTLEMENT_FAILURE_BALANCE, transaction.FailureReason)
+	s.Equal(first.ID, transaction.FaultyOrders)
+
+	first = models.OrderDao.FindByID(first.ID)
+	s.Equal(common.ORDER_CANCELED, first.Status)
+	s.assertOrderAmounts("0", "0", "0", "10", first)
+	s.assertOrderAmounts("4", "0", "0", "0", models.OrderDao.FindByID(taker.ID))
+	s.EqualValues(`[]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Bids))
+	s.EqualValues(`[["140","4"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+}
+
+func (s *marketHandlerSuite) TestDeadLetter() {
+	confirmEvent := common.ConfirmTransactionEvent{
+		Event: common.Event{
+			Type:     common.EventConfirmTransaction,
+			MarketID: s.marketHandler.market.ID,
+		},
+		Hash:   "0xunknown",
+		Status: common.STATUS_SUCCESSFUL,
+	}
+
+	journalEvent, _ := appendJournal(confirmEvent.Event, []byte(utils.ToJsonString(confirmEvent)))
+	s.NotNil(handleEvent(s.marketHandler, journalEvent))
+
+	count, deadLetters := models.DeadLetterEventDao.FindEvents(models.DEAD_LETTER_PENDING, 10, 0)
+	s.EqualValues(1, count)
+
+	deadLetter := deadLetters[0]
+	s.Equal(journalEvent.ID, deadLetter.EngineEventID)
+	s.Equal(common.EventConfirmTransaction, deadLetter.Type)
+	s.Equal(1, deadLetter.Attempts)
+	s.Contains(deadLetter.Error, "cannot find transaction")
+
+	retry := func() {
+		retryEvent := models.RetryDeadLetterEvent{
+			Event: common.Event{
+				Type:     models.EventRetryDeadLetter,
+				MarketID: s.marketHandler.market.ID,
+			},
+			DeadLetterID: deadLetter.ID,
+		}
+
+		journalEvent, _ := appendJournal(retryEvent.Event, []byte(utils.ToJsonString(retryEvent)))
+		s.Nil(handleEvent(s.marketHandler, journalEvent))
+	}
+
+	// only dead letters set to retrying by the admin are retried
+	retry()
+	s.Equal(1, models.DeadLetterEventDao.FindByID(deadLetter.ID).Attempts)
+
+	deadLetter.Status = models.DEAD_LETTER_RETRYING
+	_ = models.DeadLetterEventDao.UpdateEvent(deadLetter)
+
+	// the retry fails again, the attempt is counted on the same dead letter
+	retry()
+	deadLetter = models.DeadLetterEventDao.FindByID(deadLetter.ID)
+	s.Equal(models.DEAD_LETTER_PENDING, deadLetter.Status)
+	s.Equal(2, deadLetter.Attempts)
+
+	count, _ = models.DeadLetterEventDao.FindEvents("", 10, 0)
+	s.EqualValues(1, count)
+}
+
+func (s *marketHandlerSuite) TestIcebergOrder() {
+	handleNewOrder := func(order *models.Order) {
+		_, _, err := s.marketHandler.handleNewOrder(&common.NewOrderEvent{
+			Event: common.Event{
+				Type:     common.EventNewOrder,
+				MarketID: order.MarketID,
+			},
+			Order: utils.ToJsonString(order),
+		})
+		s.Nil(err)
+	}
+
+	iceberg := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("10"))
+	iceberg.DisplayAmount = utils.StringToDecimal("3")
+	handleNewOrder(iceberg)
+	s.EqualValues(`[["140","3"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+
+	other := newModelOrder("sell", utils.StringToDecimal("140"), utils.StringToDecimal("5"))
+	handleNewOrder(other)
+	s.EqualValues(`[["140","8"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+
+	// the shown part of the iceberg is taken and refilled behind the other order, which fills the rest of the buy
+	s.AssertChange(func() {
+		handleNewOrder(newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("4")))
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 2)
+
+	s.assertOrderAmounts("7", "3", "0", "0", models.OrderDao.FindByID(iceberg.ID))
+	s.assertOrderAmounts("4", "1", "0", "0", models.OrderDao.FindByID(other.ID))
+	s.EqualValues(`[["140","7"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+	s.Equal(other.ID, s.marketHandler.orderbook.restingOrders()[0].ID)
+	s.Equal(iceberg.ID, s.marketHandler.orderbook.restingOrders()[1].ID)
+	s.Nil(checkOrderbook(s.marketHandler.orderbook, models.OrderDao.FindMarketPendingOrders(s.marketHandler.market.ID)))
+
+	// amending takes the hidden part first
+	_, err := s.marketHandler.handleAmendOrder(&models.AmendOrderEvent{ID: iceberg.ID, AvailableAmount: "5"})
+	s.Nil(err)
+	s.assertOrderAmounts("5", "3", "0", "2", models.OrderDao.FindByID(iceberg.ID))
+	s.EqualValues(`[["140","7"]]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+	s.Nil(checkOrderbook(s.marketHandler.orderbook, models.OrderDao.FindMarketPendingOrders(s.marketHandler.market.ID)))
+
+	// the buy goes on matching the refilled part until the iceberg is used up
+	s.AssertChange(func() {
+		handleNewOrder(newModelOrder("buy", utils.StringToDecimal("140"), utils.StringToDecimal("9")))
+	}, func() int {
+		return models.TradeDao.Count()
+	}, 3)
+
+	s.assertOrderAmounts("0", "8", "0", "2", models.OrderDao.FindByID(iceberg.ID))
+	s.assertOrderAmounts("0", "5", "0", "0", models.OrderDao.FindByID(other.ID))
+	s.EqualValues(`[]`, utils.ToJsonString(s.marketHandler.orderbook.SnapshotV2().Asks))
+	s.Len(s.marketHandler.orderbook.icebergs, 0)
+}
+
 func TestMarketHandler(t *testing.T) {
 	suite.Run(t, new(marketHandlerSuite))
 }
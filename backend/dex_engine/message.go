@@ -16,34 +16,93 @@ func InitWsQueue(queue common.IQueue) {
 	wsQueue = queue
 }
 
-func sendOrderUpdateMessage(order *models.Order) {
-	_ = pushAccountMessage(order.TraderAddress, &common.WebsocketOrderChangePayload{
+// WsTypeOrderRejected is sent to the trader when the engine refuses to match an order.
+const WsTypeOrderRejected = "orderRejected"
+
+// OrderRejectedPostOnly is the reason sent for a post-only order which would take liquidity.
+const OrderRejectedPostOnly = "post_only_order_would_match"
+
+// OrderRejectedExpired is the reason sent for an order which expired before it reached the engine.
+const OrderRejectedExpired = "order_expired"
+
+// OrderRejectedFillOrKill is the reason sent for a fill-or-kill order which can't be filled completely.
+const OrderRejectedFillOrKill = "fill_or_kill_order_cannot_be_filled"
+
+// OrderRejectedReplacedOrderNotOpen is the reason sent for a replacing order when the order it replaces was already filled or canceled.
+const OrderRejectedReplacedOrderNotOpen = "replaced_order_is_not_open"
+
+// OrderRejectedMarketHalted is the reason sent for an order placed while the market is halted.
+const OrderRejectedMarketHalted = "market_halted"
+
+// OrderRejectedMarketCancelOnly is the reason sent for an order placed while the market only takes cancels.
+const OrderRejectedMarketCancelOnly = "market_cancel_only"
+
+// OrderRejectedMarketPostOnly is the reason sent for an order which would match while the market only takes post-only orders.
+const OrderRejectedMarketPostOnly = "market_post_only_order_would_match"
+
+type WebsocketOrderRejectedPayload struct {
+	Type   string        `json:"type"`
+	Order  *models.Order `json:"order"`
+	Reason string        `json:"reason"`
+}
+
+func sendOrderRejectedMessage(tx *dbTx, order *models.Order, reason string) error {
+	return pushAccountMessage(tx, order.TraderAddress, &WebsocketOrderRejectedPayload{
+		Type:   WsTypeOrderRejected,
+		Order:  order,
+		Reason: reason,
+	})
+}
+
+// WsTypeMarketStateChange is sent on the market channel when the trading state of the market changes.
+const WsTypeMarketStateChange = "marketStateChange"
+
+type WebsocketMarketStateChangePayload struct {
+	Type         string `json:"type"`
+	MarketID     string `json:"marketID"`
+	TradingState string `json:"tradingState"`
+}
+
+func sendMarketStateChangeMessage(tx *dbTx, marketID, tradingState string) error {
+	return pushMarketChannel(tx, marketID, &WebsocketMarketStateChangePayload{
+		Type:         WsTypeMarketStateChange,
+		MarketID:     marketID,
+		TradingState: tradingState,
+	})
+}
+
+func sendOrderUpdateMessage(tx *dbTx, order *models.Order) error {
+	return pushAccountMessage(tx, order.TraderAddress, &common.WebsocketOrderChangePayload{
 		Type:  common.WsTypeOrderChange,
 		Order: order,
 	})
 }
 
-func sendTradeUpdateMessage(trade *models.Trade) {
-	_ = pushAccountMessage(trade.Maker, &common.WebsocketTradeChangePayload{
+func sendTradeUpdateMessage(tx *dbTx, trade *models.Trade) error {
+	err := pushAccountMessage(tx, trade.Maker, &common.WebsocketTradeChangePayload{
 		Type:  common.WsTypeTradeChange,
 		Trade: trade,
 	})
 
-	_ = pushAccountMessage(trade.Taker, &common.WebsocketTradeChangePayload{
+	if err != nil {
+		return err
+	}
+
+	return pushAccountMessage(tx, trade.Taker, &common.WebsocketTradeChangePayload{
 		Type:  common.WsTypeTradeChange,
 		Trade: trade,
 	})
 }
 
-func sendNewMarketTradeMessage(trade *models.Trade) {
-	_ = pushMarketChannel(trade.MarketID, &common.WebsocketMarketNewMarketTradePayload{
+func sendNewMarketTradeMessage(tx *dbTx, trade *models.Trade) error {
+	return pushMarketChannel(tx, trade.MarketID, &common.WebsocketMarketNewMarketTradePayload{
 		Type:  common.WsTypeNewMarketTrade,
 		Trade: trade,
 	})
 }
 
-func sendLockedBalanceChangeMessage(address, symbol string, newLockedBalance decimal.Decimal) {
-	_ = pushAccountMessage(address, &common.WebsocketLockedBalanceChangePayload{
+func sendLockedBalanceChangeMessage(tx *dbTx, address, symbol string, newLockedBalance decimal.Decimal) error {
+	return pushAccountMessage(tx, address, &common.WebsocketLockedBalanceChangePayload{
 		Type:    common.WsTypeLockedBalanceChange,
 		Symbol:  symbol,
 		Balance: newLockedBalance,
@@ -61,20 +120,21 @@ func sendLockedBalanceChangeMessage(address, symbol string, newLockedBalance dec
 //	return pushMarketChannel(marketID, payload)
 //}
 
-func pushMarketChannel(marketID string, payload interface{}) error {
-	return pushMessage(&common.WebSocketMessage{
+func pushMarketChannel(tx *dbTx, marketID string, payload interface{}) error {
+	return tx.pushMessage(&common.WebSocketMessage{
 		ChannelID: common.GetMarketChannelID(marketID),
 		Payload:   payload,
 	})
 }
 
-func pushAccountMessage(address string, payload interface{}) error {
-	return pushMessage(&common.WebSocketMessage{
+func pushAccountMessage(tx *dbTx, address string, payload interface{}) error {
+	return tx.pushMessage(&common.WebSocketMessage{
 		ChannelID: common.GetAccountChannelID(address),
 		Payload:   payload,
 	})
 }
 
+// pushMessage sends the message right away, messages about database changes are pushed through the outbox of a dbTx.
 func pushMessage(message interface{}) error {
 	msgBytes, err := json.Marshal(message)
 	if err != nil {
@@ -0,0 +1,550 @@
+package dex_engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
+	"github.com/shopspring/decimal"
+)
+
+// bookOrder is an order resting in the book, Priority keeps its place in the time priority.
+// An iceberg order shows at most DisplayAmount in the book, the rest of it waits in HiddenAmount.
+type bookOrder struct {
+	*common.MemoryOrder
+	Priority      uint64          `json:"priority"`
+	DisplayAmount decimal.Decimal `json:"displayAmount"`
+	HiddenAmount  decimal.Decimal `json:"hiddenAmount"`
+}
+
+// newBookOrder splits the amount of the order into the part shown in the book and the hidden part,
+// nothing is hidden if displayAmount is zero.
+func newBookOrder(order *common.MemoryOrder, priority uint64, displayAmount decimal.Decimal) *bookOrder {
+	resting := &bookOrder{MemoryOrder: order, Priority: priority, DisplayAmount: displayAmount}
+
+	if displayAmount.IsPositive() && order.Amount.GreaterThan(displayAmount) {
+		resting.HiddenAmount = order.Amount.Sub(displayAmount)
+		order.Amount = displayAmount
+	}
+
+	return resting
+}
+
+// openAmount is the amount of the order left in the book, shown or hidden.
+func (order *bookOrder) openAmount() decimal.Decimal {
+	return order.Amount.Add(order.HiddenAmount)
+}
+
+func (order *bookOrder) isIceberg() bool {
+	return order.DisplayAmount.IsPositive()
+}
+
+// orderbook is the in-memory book of a single market.
+// It matches orders with the sdk orderbook and keeps an index of the resting orders,
+// so the whole book can be written into a snapshot and rebuilt from it later.
+type orderbook struct {
+	*common.Orderbook
+
+	marketID       string
+	amountDecimals int
+
+	orders       map[string]*bookOrder
+	lastPriority uint64
+
+	// icebergs are the resting iceberg orders, their shown part is refilled from the hidden part after it is taken
+	icebergs map[string]*bookOrder
+
+	// settling keeps the matched amount of each order which waits for its settlement, with the priority the order had.
+	// The amount goes back to the book if the settlement fails, also when the order left the book by matching.
+	settling map[string]*bookOrder
+}
+
+func newOrderbook(marketID string, amountDecimals int) *orderbook {
+	book := &orderbook{
+		Orderbook:      common.NewOrderbook(marketID),
+		marketID:       marketID,
+		amountDecimals: amountDecimals,
+		orders:         make(map[string]*bookOrder),
+		icebergs:       make(map[string]*bookOrder),
+		settling:       make(map[string]*bookOrder),
+	}
+
+	book.UsePlugin(func(e *common.OrderbookEvent) {
+		book.Sequence = book.Sequence + 1
+	})
+
+	return book
+}
+
+// newMemoryOrder returns the part of the order which is still open, it is less than the amount of the order
+// if the order was amended.
+func newMemoryOrder(order *models.Order) *common.MemoryOrder {
+	return &common.MemoryOrder{
+		ID:           order.ID,
+		MarketID:     order.MarketID,
+		Price:        order.Price,
+		Amount:       order.AvailableAmount,
+		Side:         order.Side,
+		Trader:       order.TraderAddress,
+		GasFeeAmount: order.GasFeeAmount,
+		MakerFeeRate: order.MakerFeeRate,
+		TakerFeeRate: order.TakerFeeRate,
+	}
+}
+
+// isPostOnly returns true for limit orders which are signed as maker only, they must never take liquidity.
+func isPostOnly(order *models.Order) bool {
+	return order.Type == "limit" && ethereum.GetIsMakerOnlyFromOrderData(order.GetOrderJson().Data)
+}
+
+// isMarketOrder returns true for market orders, and for stop market orders once they are triggered.
+func isMarketOrder(order *models.Order) bool {
+	return order.Type == "market" || order.Type == models.ORDER_TYPE_STOP_MARKET
+}
+
+// restsInBook returns false for orders whose unfilled part is canceled instead of resting in the book.
+func restsInBook(order *models.Order) bool {
+	return order.TimeInForce != models.TIME_IN_FORCE_IOC && order.TimeInForce != models.TIME_IN_FORCE_FOK
+}
+
+// matchNewOrder matches the taker order against the book, and rests what is left of it if rest is true.
+// It follows the matching rules of the hydro sdk engine. The shown part of an iceberg order is refilled
+// after the taker order took it, and the taker order goes on matching the refilled part in the same result.
+// The taker order rests as an iceberg order if displayAmount is positive.
+func (book *orderbook) matchNewOrder(newOrder *common.MemoryOrder, rest bool, displayAmount decimal.Decimal) (matchResult common.MatchResult, hasMatch bool) {
+	settlingAmount := decimal.Zero
+
+	for book.CanMatch(newOrder) {
+		shownAmounts := book.icebergShownAmounts()
+		result := book.ExecuteMatch(newOrder, book.amountDecimals)
+
+		if len(result.MatchItems) == 0 {
+			panic(fmt.Errorf("no match items, market %s order %s", book.marketID, newOrder.ID))
+		}
+
+		refilled := false
+
+		for i := range result.MatchItems {
+			item := result.MatchItems[i]
+			maker, inBook := book.orders[item.MakerOrder.ID]
+
+			if !item.MatchShouldBeCanceled {
+				if inBook {
+					book.addSettling(item.MakerOrder, item.MatchedAmount, maker.Priority, maker.DisplayAmount)
+				}
+
+				settlingAmount = settlingAmount.Add(item.MatchedAmount)
+			}
+
+			if item.MakerOrderIsDone {
+				// the sdk drops what is left of the shown part if it is too small, it goes back to the hidden part
+				if e := book.refill(item.MakerOrder.ID, shownAmounts[item.MakerOrder.ID].Sub(item.MatchedAmount)); e != nil {
+					item.MakerOrderIsDone = false
+					refilled = true
+
+					msg := common.OrderBookChangeMessage(book.marketID, book.Sequence, e.Side, e.Price, e.Amount)
+					result.OrderBookActivities = append(result.OrderBookActivities, msg)
+				} else {
+					delete(book.orders, item.MakerOrder.ID)
+					delete(book.icebergs, item.MakerOrder.ID)
+				}
+			}
+
+			newOrder.Amount = newOrder.Amount.Sub(item.MatchedAmount)
+		}
+
+		matchResult.TakerOrder = result.TakerOrder
+		matchResult.MatchItems = append(matchResult.MatchItems, result.MatchItems...)
+		matchResult.OrderBookActivities = append(matchResult.OrderBookActivities, result.OrderBookActivities...)
+		hasMatch = true
+
+		if !refilled || !newOrder.Amount.IsPositive() {
+			break
+		}
+	}
+
+	if !rest || common.TakerOrderShouldBeRemoved(newOrder) {
+		matchResult.TakerOrderIsDone = true
+
+		book.lastPriority = book.lastPriority + 1
+		book.addSettling(newOrder, settlingAmount, book.lastPriority, displayAmount)
+		return
+	}
+
+	// if matched, gasFee is paid
+	if matchResult.BaseTokenTotalMatchedAmtWithoutCanceledMatch().IsPositive() {
+		newOrder.GasFeeAmount = decimal.Zero
+	}
+
+	e := book.insertOrder(newOrder, displayAmount)
+	book.addSettling(newOrder, settlingAmount, book.lastPriority, displayAmount)
+
+	msg := common.OrderBookChangeMessage(book.marketID, book.Sequence, e.Side, e.Price, e.Amount)
+	matchResult.OrderBookActivities = append(matchResult.OrderBookActivities, msg)
+
+	return
+}
+
+// limitSlippage keeps a market order from matching further than maxSlippage away from the best opposite price,
+// by lowering the price of a buy order or raising the price of a sell order to that bound.
+// Orders whose price is within the bound already are not changed.
+func (book *orderbook) limitSlippage(order *common.MemoryOrder, maxSlippage decimal.Decimal) {
+	if order.Side == "buy" {
+		bestAsk := book.MinAsk()
+		if bestAsk == nil {
+			return
+		}
+
+		bound := bestAsk.Mul(decimal.New(1, 0).Add(maxSlippage))
+		if order.Price.GreaterThan(bound) {
+			order.Price = bound
+		}
+	} else {
+		bestBid := book.MaxBid()
+		if bestBid == nil {
+			return
+		}
+
+		bound := bestBid.Mul(decimal.New(1, 0).Sub(maxSlippage))
+		if order.Price.LessThan(bound) {
+			order.Price = bound
+		}
+	}
+}
+
+// selfTradePrevention is what the book canceled to keep a taker order from matching orders of its own trader.
+type selfTradePrevention struct {
+	// CanceledMakerOrders is the amount canceled from each resting order, in the order they were canceled
+	CanceledMakerOrders []*canceledMakerOrder
+	TakerCanceledAmount decimal.Decimal
+	// TakerOrderIsDone is true if the rest of the taker order must not match or rest in the book
+	TakerOrderIsDone    bool
+	OrderBookActivities []common.WebSocketMessage
+}
+
+type canceledMakerOrder struct {
+	ID             string
+	CanceledAmount decimal.Decimal
+}
+
+// preventSelfTrade changes the book and the taker order according to mode, so matching the taker order afterwards
+// doesn't trade with orders of the same trader. Nothing is done if mode is empty.
+func (book *orderbook) preventSelfTrade(taker *common.MemoryOrder, mode string) *selfTradePrevention {
+	result := &selfTradePrevention{}
+
+	if mode == "" || taker.Trader == "" {
+		return result
+	}
+
+	for taker.Amount.IsPositive() && book.CanMatch(taker) {
+		var item *common.MatchItem
+		matchedBefore := decimal.Zero
+
+		for _, matchItem := range book.MatchOrder(taker, book.amountDecimals).MatchItems {
+			if strings.EqualFold(matchItem.MakerOrder.Trader, taker.Trader) {
+				item = matchItem
+				break
+			}
+
+			matchedBefore = matchedBefore.Add(matchItem.MatchedAmount)
+		}
+
+		if item == nil {
+			return result
+		}
+
+		switch mode {
+		case models.SELF_TRADE_PREVENTION_CANCEL_OLDEST:
+			book.cancelMakerOrder(result, item.MakerOrder, book.orders[item.MakerOrder.ID].openAmount())
+		case models.SELF_TRADE_PREVENTION_CANCEL_NEWEST:
+			book.cancelTakerOrder(result, taker, taker.Amount.Sub(matchedBefore))
+			result.TakerOrderIsDone = true
+		case models.SELF_TRADE_PREVENTION_CANCEL_BOTH:
+			book.cancelMakerOrder(result, item.MakerOrder, book.orders[item.MakerOrder.ID].openAmount())
+			book.cancelTakerOrder(result, taker, taker.Amount.Sub(matchedBefore))
+			result.TakerOrderIsDone = true
+		case models.SELF_TRADE_PREVENTION_DECREMENT_AND_CANCEL:
+			book.cancelMakerOrder(result, item.MakerOrder, item.MatchedAmount)
+			book.cancelTakerOrder(result, taker, item.MatchedAmount)
+		default:
+			panic(fmt.Errorf("unknown self trade prevention mode %s, market %s order %s", mode, book.marketID, taker.ID))
+		}
+
+		if result.TakerOrderIsDone {
+			return result
+		}
+	}
+
+	return result
+}
+
+// cancelMakerOrder takes amount from a resting order, the order is removed if nothing is left of it.
+func (book *orderbook) cancelMakerOrder(result *selfTradePrevention, maker *common.MemoryOrder, amount decimal.Decimal) {
+	var e *common.OrderbookEvent
+
+	if resting := book.orders[maker.ID]; amount.GreaterThanOrEqual(resting.openAmount()) {
+		e = book.removeOrder(maker.ID)
+	} else {
+		e = book.reduceOrder(resting, resting.openAmount().Sub(amount))
+	}
+
+	if e != nil {
+		msg := common.OrderBookChangeMessage(book.marketID, book.Sequence, e.Side, e.Price, e.Amount)
+		result.OrderBookActivities = append(result.OrderBookActivities, msg)
+	}
+
+	result.CanceledMakerOrders = append(result.CanceledMakerOrders, &canceledMakerOrder{ID: maker.ID, CanceledAmount: amount})
+}
+
+func (book *orderbook) cancelTakerOrder(result *selfTradePrevention, taker *common.MemoryOrder, amount decimal.Decimal) {
+	taker.Amount = taker.Amount.Sub(amount)
+	result.TakerCanceledAmount = result.TakerCanceledAmount.Add(amount)
+}
+
+// canFill returns true if the order can be filled completely by the book, the book is not changed.
+func (book *orderbook) canFill(order *common.MemoryOrder) bool {
+	if !book.CanMatch(order) {
+		return false
+	}
+
+	matchedAmount := decimal.Zero
+	for _, item := range book.MatchOrder(order, book.amountDecimals).MatchItems {
+		matchedAmount = matchedAmount.Add(item.MatchedAmount)
+	}
+
+	return matchedAmount.GreaterThanOrEqual(order.Amount)
+}
+
+// insertOrder rests the order at the back of its price level. An iceberg order shows at most displayAmount,
+// displayAmount is zero for other orders.
+func (book *orderbook) insertOrder(order *common.MemoryOrder, displayAmount decimal.Decimal) *common.OrderbookEvent {
+	book.lastPriority = book.lastPriority + 1
+	book.addOrder(newBookOrder(order, book.lastPriority, displayAmount))
+
+	return book.InsertOrder(order)
+}
+
+func (book *orderbook) addOrder(order *bookOrder) {
+	book.orders[order.ID] = order
+
+	if order.isIceberg() {
+		book.icebergs[order.ID] = order
+	}
+}
+
+// removeOrder takes the order out of the book, with its hidden part. It returns nil if the order is not resting.
+// The amounts of the order waiting for settlement won't go back to the book any more.
+func (book *orderbook) removeOrder(orderID string) *common.OrderbookEvent {
+	delete(book.settling, orderID)
+	return book.takeOrder(orderID)
+}
+
+// takeOrder takes the order out of the book to match it again, its amounts waiting for settlement are kept.
+func (book *orderbook) takeOrder(orderID string) *common.OrderbookEvent {
+	order, ok := book.orders[orderID]
+	if !ok {
+		return nil
+	}
+
+	delete(book.orders, orderID)
+	delete(book.icebergs, orderID)
+	return book.RemoveOrder(order.MemoryOrder)
+}
+
+// reduceOrder lowers the open amount of a resting order to amount, the hidden part of an iceberg order goes first.
+// It returns nil if the shown part didn't change.
+func (book *orderbook) reduceOrder(order *bookOrder, amount decimal.Decimal) *common.OrderbookEvent {
+	if amount.GreaterThanOrEqual(order.Amount) {
+		order.HiddenAmount = amount.Sub(order.Amount)
+		return nil
+	}
+
+	order.HiddenAmount = decimal.Zero
+	e := book.ChangeOrder(order.MemoryOrder, amount.Sub(order.Amount))
+	order.Amount = amount
+
+	return e
+}
+
+// icebergShownAmounts returns the shown part of each resting iceberg order.
+func (book *orderbook) icebergShownAmounts() map[string]decimal.Decimal {
+	amounts := make(map[string]decimal.Decimal, len(book.icebergs))
+	for id, order := range book.icebergs {
+		amounts[id] = order.Amount
+	}
+
+	return amounts
+}
+
+// refill shows the next part of an iceberg order after its shown part was taken, leftAmount is what the match
+// left of the shown part. The refilled part goes to the back of its price level, the order loses its time priority.
+// It returns nil if the order has nothing hidden.
+func (book *orderbook) refill(orderID string, leftAmount decimal.Decimal) *common.OrderbookEvent {
+	order, ok := book.icebergs[orderID]
+	if !ok || !order.HiddenAmount.IsPositive() {
+		return nil
+	}
+
+	hiddenAmount := order.HiddenAmount.Add(leftAmount)
+
+	book.lastPriority = book.lastPriority + 1
+	order.Priority = book.lastPriority
+	order.Amount = decimal.Min(order.DisplayAmount, hiddenAmount)
+	order.HiddenAmount = hiddenAmount.Sub(order.Amount)
+
+	return book.InsertOrder(order.MemoryOrder)
+}
+
+func (book *orderbook) getOrder(orderID string) (*bookOrder, bool) {
+	order, ok := book.orders[orderID]
+	return order, ok
+}
+
+// restingOrders returns the orders of the book in time priority.
+func (book *orderbook) restingOrders() []*bookOrder {
+	orders := make([]*bookOrder, 0, len(book.orders))
+	for _, order := range book.orders {
+		orders = append(orders, order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Priority < orders[j].Priority
+	})
+
+	return orders
+}
+
+// restoreOrders puts snapshot orders back into the book, keeping their time priority,
+// and then sets the sequence the book had when the snapshot was taken.
+func (book *orderbook) restoreOrders(orders []*bookOrder, settling []*bookOrder, sequence uint64) {
+	for _, order := range orders {
+		book.InsertOrder(order.MemoryOrder)
+		book.addOrder(order)
+
+		if order.Priority > book.lastPriority {
+			book.lastPriority = order.Priority
+		}
+	}
+
+	for _, order := range settling {
+		book.settling[order.ID] = order
+
+		if order.Priority > book.lastPriority {
+			book.lastPriority = order.Priority
+		}
+	}
+
+	book.Sequence = sequence
+}
+
+// settlingOrders returns the amounts waiting for settlement in time priority.
+func (book *orderbook) settlingOrders() []*bookOrder {
+	orders := make([]*bookOrder, 0, len(book.settling))
+	for _, order := range book.settling {
+		orders = append(orders, order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Priority < orders[j].Priority
+	})
+
+	return orders
+}
+
+// addSettling adds amount of the order to the amount waiting for settlement.
+func (book *orderbook) addSettling(order *common.MemoryOrder, amount decimal.Decimal, priority uint64, displayAmount decimal.Decimal) {
+	if !amount.IsPositive() {
+		return
+	}
+
+	settling, ok := book.settling[order.ID]
+	if !ok {
+		memoryOrder := *order
+		memoryOrder.Amount = decimal.Zero
+		settling = &bookOrder{MemoryOrder: &memoryOrder, Priority: priority, DisplayAmount: displayAmount}
+		book.settling[order.ID] = settling
+	}
+
+	settling.Amount = settling.Amount.Add(amount)
+}
+
+// settle takes amount of the order out of settlement, the settlement either succeeded or failed.
+func (book *orderbook) settle(orderID string, amount decimal.Decimal) {
+	settling, ok := book.settling[orderID]
+	if !ok {
+		return
+	}
+
+	settling.Amount = settling.Amount.Sub(amount)
+	if !settling.Amount.IsPositive() {
+		delete(book.settling, orderID)
+	}
+}
+
+// returnAmount puts amount of an order whose settlement failed back into the book.
+// An order resting in the book gets the amount in its place, an iceberg order in its hidden part.
+// An order which left the book by matching is inserted again with the priority it had.
+// It returns false if the order was removed from the book since, or if it would cross the book now.
+// The event is nil if the amount went back but the shown part of the book didn't change.
+func (book *orderbook) returnAmount(orderID string, amount decimal.Decimal) (*common.OrderbookEvent, bool) {
+	if order, ok := book.orders[orderID]; ok {
+		if order.isIceberg() {
+			order.HiddenAmount = order.HiddenAmount.Add(amount)
+			return nil, true
+		}
+
+		e := book.ChangeOrder(order.MemoryOrder, amount)
+		order.Amount = order.Amount.Add(amount)
+		return e, true
+	}
+
+	settling, ok := book.settling[orderID]
+	if !ok {
+		return nil, false
+	}
+
+	memoryOrder := *settling.MemoryOrder
+	memoryOrder.Amount = amount
+
+	if book.CanMatch(&memoryOrder) {
+		return nil, false
+	}
+
+	return book.insertOrderAt(&memoryOrder, settling.Priority, settling.DisplayAmount), true
+}
+
+// insertOrderAt rests the order in front of the orders of its price level which came after priority.
+// The sdk book keeps a level in the order its orders were inserted, so the orders behind are moved after the order.
+// The shown book only changes by the amount of the order, so it is a single book event in the sequence,
+// the one returned.
+func (book *orderbook) insertOrderAt(order *common.MemoryOrder, priority uint64, displayAmount decimal.Decimal) *common.OrderbookEvent {
+	sequence := book.Sequence
+
+	var behind []*bookOrder
+	for _, resting := range book.orders {
+		if resting.Side == order.Side && resting.Price.Equal(order.Price) && resting.Priority > priority {
+			behind = append(behind, resting)
+		}
+	}
+
+	sort.Slice(behind, func(i, j int) bool {
+		return behind[i].Priority < behind[j].Priority
+	})
+
+	for _, resting := range behind {
+		book.RemoveOrder(resting.MemoryOrder)
+	}
+
+	book.addOrder(newBookOrder(order, priority, displayAmount))
+	e := book.InsertOrder(order)
+
+	for _, resting := range behind {
+		book.InsertOrder(resting.MemoryOrder)
+	}
+
+	book.Sequence = sequence + 1
+	return e
+}
@@ -0,0 +1,186 @@
+package dex_engine
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+)
+
+// dbTx is the sql transaction of an event.
+// Websocket messages pushed in it are written to the outbox and only relayed after the commit,
+// so clients never get messages about rows which are rolled back.
+type dbTx struct {
+	*models.Tx
+	messages []*models.OutboxMessage
+}
+
+// runInTransaction calls fn in a sql transaction and relays its messages once it is committed.
+func runInTransaction(fn func(tx *dbTx) error) error {
+	var messages []*models.OutboxMessage
+
+	err := models.RunInTransaction(func(tx *models.Tx) error {
+		t := &dbTx{Tx: tx}
+
+		err := fn(t)
+		if err != nil {
+			return err
+		}
+
+		messages = t.messages
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	relayMessages(messages)
+	return nil
+}
+
+func (tx *dbTx) pushMessage(message interface{}) error {
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	outboxMessage := &models.OutboxMessage{
+		Payload:   string(msgBytes),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = tx.OutboxMessageDao.InsertMessage(outboxMessage)
+	if err != nil {
+		return err
+	}
+
+	tx.messages = append(tx.messages, outboxMessage)
+	return nil
+}
+
+// outboxMutex makes sweeps of the outbox run one at a time.
+var outboxMutex sync.Mutex
+
+// outboxRelayInterval is how often the outbox is swept, messages left behind by a failed push are relayed then.
+const outboxRelayInterval = 5 * time.Second
+
+// outboxRelayBatchSize is how many messages a sweep reads at once.
+const outboxRelayBatchSize = 1000
+
+// outboxBehind is set when messages are left behind by a failed push. Until a sweep has relayed them,
+// the messages of new commits are left to the sweeps as well, so they don't overtake the older ones.
+var outboxBehind int32
+
+// relayMessages pushes the messages of a commit to the websocket queue and removes them from the outbox.
+// The rest of the outbox is left to the sweeps, so markets don't wait on each other or on older messages.
+func relayMessages(messages []*models.OutboxMessage) {
+	if len(messages) == 0 || atomic.LoadInt32(&outboxBehind) == 1 {
+		return
+	}
+
+	var ids []int64
+	for _, message := range messages {
+		err := pushOutboxMessage(message)
+		if err != nil {
+			atomic.StoreInt32(&outboxBehind, 1)
+			break
+		}
+
+		ids = append(ids, message.ID)
+	}
+
+	deleteOutboxMessages(ids)
+}
+
+// relayOutbox pushes the messages of the outbox created before the time to the websocket queue oldest first,
+// and removes them from it. It returns false if it stops at a failure, the rest are kept for the next sweep.
+func relayOutbox(before time.Time) bool {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+
+	for {
+		messages := models.OutboxMessageDao.FindMessages(before, outboxRelayBatchSize)
+
+		var ids []int64
+		for _, message := range messages {
+			if pushOutboxMessage(message) != nil {
+				deleteOutboxMessages(ids)
+				return false
+			}
+
+			ids = append(ids, message.ID)
+		}
+
+		if !deleteOutboxMessages(ids) {
+			return false
+		}
+
+		if len(messages) < outboxRelayBatchSize {
+			return true
+		}
+	}
+}
+
+// sweepOutbox relays the messages left in the outbox. The messages of the latest commits are relayed after them,
+// so they are only swept once they are older than outboxRelayInterval, unless the outbox is behind.
+func sweepOutbox() {
+	behind := atomic.LoadInt32(&outboxBehind) == 1
+
+	before := time.Now().UTC()
+	if !behind {
+		before = before.Add(-outboxRelayInterval)
+	}
+
+	if relayOutbox(before) && behind {
+		utils.Infof("outbox messages left behind are relayed")
+		atomic.StoreInt32(&outboxBehind, 0)
+	}
+}
+
+func pushOutboxMessage(message *models.OutboxMessage) error {
+	utils.Debugf("sending pushMessage: %v", message.Payload)
+
+	err := wsQueue.Push([]byte(message.Payload))
+	if err != nil {
+		utils.Errorf("relay outbox message %d failed: %v", message.ID, err)
+	}
+
+	return err
+}
+
+// deleteOutboxMessages removes relayed messages in one statement. If it fails they are relayed again by a sweep.
+func deleteOutboxMessages(ids []int64) bool {
+	err := models.OutboxMessageDao.DeleteMessages(ids)
+	if err != nil {
+		utils.Errorf("delete %d outbox messages failed: %v", len(ids), err)
+		return false
+	}
+
+	return true
+}
+
+// relayPendingMessages relays the messages which were committed but not relayed before the engine stopped.
+func relayPendingMessages() {
+	relayOutbox(time.Now().UTC())
+}
+
+// relayOutboxPeriodically sweeps the outbox every outboxRelayInterval until ctx is done, so messages left behind
+// while the websocket queue was down are not kept forever.
+func relayOutboxPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(outboxRelayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepOutbox()
+		}
+	}
+}
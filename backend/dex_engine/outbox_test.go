@@ -0,0 +1,58 @@
+package dex_engine
+
+import (
+	"errors"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestRelayOutboxKeepsOrderAfterFailedPush(t *testing.T) {
+	setEnvs()
+	models.InitTestDBPG()
+
+	queue := &common.MockQueue{}
+	queue.On("Push", []byte(`"first"`)).Return(errors.New("queue is down")).Once()
+	queue.On("Push", mock.Anything).Return(nil)
+	wsQueue = queue
+
+	pushMessage := func(payload string) {
+		err := runInTransaction(func(tx *dbTx) error {
+			return tx.pushMessage(payload)
+		})
+		assert.Nil(t, err)
+	}
+
+	// the queue is down, the second message is left to the sweep instead of overtaking the first one
+	pushMessage("first")
+	pushMessage("second")
+	assert.EqualValues(t, []string{`"first"`}, payloads(queue.Buffers))
+	assert.EqualValues(t, 2, len(models.OutboxMessageDao.FindMessages(time.Now().UTC(), 10)))
+
+	sweepOutbox()
+	assert.EqualValues(t, []string{`"first"`, `"first"`, `"second"`}, payloads(queue.Buffers))
+	assert.EqualValues(t, 0, len(models.OutboxMessageDao.FindMessages(time.Now().UTC(), 10)))
+
+	// the outbox caught up, commits relay their messages again
+	pushMessage("third")
+	assert.EqualValues(t, []string{`"first"`, `"first"`, `"second"`, `"third"`}, payloads(queue.Buffers))
+	assert.EqualValues(t, 0, len(models.OutboxMessageDao.FindMessages(time.Now().UTC(), 10)))
+
+	// the messages of the latest commits are left to them
+	_ = models.OutboxMessageDao.InsertMessage(&models.OutboxMessage{Payload: `"fresh"`, CreatedAt: time.Now().UTC()})
+	sweepOutbox()
+	assert.EqualValues(t, 4, len(queue.Buffers))
+	assert.EqualValues(t, 1, len(models.OutboxMessageDao.FindMessages(time.Now().UTC(), 10)))
+}
+
+func payloads(buffers [][]byte) []string {
+	var values []string
+	for _, buffer := range buffers {
+		values = append(values, string(buffer))
+	}
+
+	return values
+}
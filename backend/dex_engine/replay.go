@@ -0,0 +1,152 @@
+package dex_engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+)
+
+// Replayer handles journaled events again, one by one and in journal order,
+// against the database models.DB is connected to. It is used to rebuild books, orders and trades
+// in a scratch database, so websocket messages are dropped and snapshots are kept in memory.
+type Replayer struct {
+	kvStore        common.IKVStore
+	marketHandlers map[string]*MarketHandler
+	// configs are the latest journaled configs of the markets
+	configs map[string]*models.Market
+}
+
+func NewReplayer() *Replayer {
+	InitWsQueue(&discardQueue{})
+
+	return &Replayer{
+		kvStore:        &memoryKVStore{data: make(map[string]string)},
+		marketHandlers: make(map[string]*MarketHandler),
+		configs:        make(map[string]*models.Market),
+	}
+}
+
+// Replay handles the event the way the engine did.
+// A market is loaded with its latest journaled config the first time one of its events is replayed, or from the
+// database if the journal has no config of it.
+func (r *Replayer) Replay(journalEvent *models.EngineEvent) error {
+	switch journalEvent.Type {
+	case common.EventOpenMarket:
+		_, err := r.marketHandler(journalEvent.MarketID)
+		return err
+	case common.EventCloseMarket:
+		delete(r.marketHandlers, journalEvent.MarketID)
+		return nil
+	case common.EventRestartEngine:
+		// markets save a snapshot when they stop, and are restored from it
+		for _, marketHandler := range r.marketHandlers {
+			marketHandler.saveSnapshot()
+		}
+
+		r.marketHandlers = make(map[string]*MarketHandler)
+		return nil
+	case models.EventMarketConfig:
+		var e models.MarketConfigEvent
+		err := json.Unmarshal([]byte(journalEvent.Payload), &e)
+		if err != nil || e.Market == nil {
+			return fmt.Errorf("wrong market config event %d: %v", journalEvent.ID, err)
+		}
+
+		r.configs[journalEvent.MarketID] = e.Market
+
+		// a market which is already loaded takes the config like the engine does
+		if marketHandler, ok := r.marketHandlers[journalEvent.MarketID]; ok {
+			return handleEvent(marketHandler, journalEvent)
+		}
+
+		return nil
+	default:
+		marketHandler, err := r.marketHandler(journalEvent.MarketID)
+		if err != nil {
+			return err
+		}
+
+		return handleEvent(marketHandler, journalEvent)
+	}
+}
+
+// Orderbook returns the aggregated book of a replayed market.
+func (r *Replayer) Orderbook(marketID string) *common.SnapshotV2 {
+	marketHandler, ok := r.marketHandlers[marketID]
+	if !ok {
+		return nil
+	}
+
+	snapshot := marketHandler.orderbook.SnapshotV2()
+	snapshot.Sequence = marketHandler.orderbook.Sequence
+	return snapshot
+}
+
+func (r *Replayer) marketHandler(marketID string) (*MarketHandler, error) {
+	marketHandler, ok := r.marketHandlers[marketID]
+	if ok {
+		return marketHandler, nil
+	}
+
+	var market *models.Market
+	if config, ok := r.configs[marketID]; ok {
+		copied := *config
+		market = &copied
+	} else {
+		market = models.MarketDao.FindMarketByID(marketID)
+	}
+
+	if market == nil {
+		return nil, fmt.Errorf("replay fail, market [%s] not found", marketID)
+	}
+
+	marketHandler, err := NewMarketHandler(context.Background(), market, r.kvStore)
+	if err != nil {
+		return nil, err
+	}
+
+	r.marketHandlers[marketID] = marketHandler
+	return marketHandler, nil
+}
+
+// memoryKVStore keeps the snapshots of a replay.
+type memoryKVStore struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (s *memoryKVStore) Set(key string, value string, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = value
+	return nil
+}
+
+func (s *memoryKVStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.data[key]
+	if !ok {
+		return "", common.KVStoreEmpty
+	}
+
+	return value, nil
+}
+
+// discardQueue drops the websocket messages of a replay.
+type discardQueue struct{}
+
+func (discardQueue) Push([]byte) error {
+	return nil
+}
+
+func (discardQueue) Pop() ([]byte, error) {
+	return nil, common.EXIT
+}
@@ -0,0 +1,133 @@
+package dex_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+)
+
+const (
+	RestartStatusPending  = "pending"
+	RestartStatusStopping = "stopping"
+	RestartStatusLoading  = "loading"
+	RestartStatusDone     = "done"
+	RestartStatusFailed   = "failed"
+)
+
+// RestartEngineEvent asks the engine to stop all markets and load them again.
+// RestartID is used to follow the progress of the restart.
+type RestartEngineEvent struct {
+	common.Event
+	RestartID string `json:"restartID"`
+}
+
+// RestartProgress is saved in the kv store while the engine restarts, the admin api reads it from there.
+type RestartProgress struct {
+	RestartID     string            `json:"restartID"`
+	Status        string            `json:"status"`
+	TotalMarkets  int               `json:"totalMarkets"`
+	LoadedMarkets []string          `json:"loadedMarkets"`
+	FailedMarkets map[string]string `json:"failedMarkets"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	UpdatedAt     time.Time         `json:"updatedAt"`
+}
+
+func GetRestartProgressKey() string {
+	return "HYDRO_ENGINE_RESTART_PROGRESS"
+}
+
+func SaveRestartProgress(kvStore common.IKVStore, progress *RestartProgress) error {
+	progress.UpdatedAt = time.Now().UTC()
+	return kvStore.Set(GetRestartProgressKey(), utils.ToJsonString(progress), 0)
+}
+
+// LoadRestartProgress returns the progress of the latest restart, or nil if the engine was never restarted.
+func LoadRestartProgress(kvStore common.IKVStore) (*RestartProgress, error) {
+	res, err := kvStore.Get(GetRestartProgressKey())
+	if err == common.KVStoreEmpty {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var progress RestartProgress
+	err = json.Unmarshal([]byte(res), &progress)
+	if err != nil {
+		return nil, fmt.Errorf("wrong restart progress format: %v", err)
+	}
+
+	return &progress, nil
+}
+
+// restart stops all market handlers, waits until they have handled the events already dispatched to them,
+// and then loads the published markets again.
+// It runs in the event loop, so no event is dispatched until the restart is finished.
+func (e *DexEngine) restart(restartID string) {
+	progress := &RestartProgress{
+		RestartID:     restartID,
+		Status:        RestartStatusStopping,
+		LoadedMarkets: []string{},
+		FailedMarkets: make(map[string]string),
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	e.saveRestartProgress(progress)
+	utils.Infof("engine restart %s: stopping %d markets", restartID, len(e.marketHandlerMap))
+
+	for marketID := range e.marketHandlerMap {
+		e.closeMarket(marketID)
+	}
+
+	e.marketsWg.Wait()
+
+	markets := models.MarketDao.FindPublishedMarkets()
+	progress.Status = RestartStatusLoading
+	progress.TotalMarkets = len(markets)
+	e.saveRestartProgress(progress)
+	utils.Infof("engine restart %s: loading %d markets", restartID, len(markets))
+
+	for _, market := range markets {
+		marketHandler, err := e.loadMarket(market.ID)
+		if err != nil {
+			utils.Errorf("engine restart %s: %v", restartID, err)
+			progress.FailedMarkets[market.ID] = err.Error()
+		} else {
+			runMarket(e, marketHandler)
+			progress.LoadedMarkets = append(progress.LoadedMarkets, market.ID)
+		}
+
+		e.saveRestartProgress(progress)
+	}
+
+	if len(progress.FailedMarkets) > 0 {
+		progress.Status = RestartStatusFailed
+	} else {
+		progress.Status = RestartStatusDone
+	}
+
+	e.saveRestartProgress(progress)
+	utils.Infof("engine restart %s %s, loaded: %d, failed: %d", restartID, progress.Status, len(progress.LoadedMarkets), len(progress.FailedMarkets))
+}
+
+// loadMarket is newMarket which turns a panic while restoring the book into an error,
+// so one broken market doesn't stop the others from being loaded.
+func (e *DexEngine) loadMarket(marketID string) (marketHandler *MarketHandler, err error) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			err = fmt.Errorf("load market [%s] fail, %v", marketID, rcv)
+		}
+	}()
+
+	return e.newMarket(marketID)
+}
+
+func (e *DexEngine) saveRestartProgress(progress *RestartProgress) {
+	err := SaveRestartProgress(e.kvStore, progress)
+	if err != nil {
+		utils.Errorf("save restart progress failed: %v", err)
+	}
+}
@@ -3,49 +3,116 @@ package dex_engine
 import (
 	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
 	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/shopspring/decimal"
 )
 
-func UpdateOrder(order *models.Order) error {
-	err := models.OrderDao.UpdateOrder(order)
-	market := models.MarketDao.FindMarketByID(order.MarketID)
-	sendOrderUpdateMessage(order)
-	if order.Side == "buy" {
-		sendLockedBalanceChangeMessage(order.TraderAddress, market.QuoteTokenSymbol, models.BalanceDao.GetByAccountAndSymbol(order.TraderAddress, market.QuoteTokenSymbol, market.QuoteTokenDecimals))
-	} else {
-		sendLockedBalanceChangeMessage(order.TraderAddress, market.BaseTokenSymbol, models.BalanceDao.GetByAccountAndSymbol(order.TraderAddress, market.BaseTokenSymbol, market.BaseTokenDecimals))
+func UpdateOrder(tx *dbTx, order *models.Order) error {
+	err := tx.OrderDao.UpdateOrder(order)
+	if err != nil {
+		return err
+	}
+
+	return sendOrderChangeMessages(tx, order)
+}
+
+// UpdateOrders saves many orders at once. Every order gets its own update message,
+// but a locked balance is sent only once for each trader and token.
+func UpdateOrders(tx *dbTx, orders []*models.Order) error {
+	type lockedBalanceKey struct {
+		trader string
+		symbol string
+	}
+
+	lockedBalances := make(map[lockedBalanceKey]int)
+	var keys []lockedBalanceKey
+
+	for _, order := range orders {
+		err := tx.OrderDao.UpdateOrder(order)
+		if err != nil {
+			return err
+		}
+
+		err = sendOrderUpdateMessage(tx, order)
+		if err != nil {
+			return err
+		}
+
+		market := models.MarketDao.FindMarketByID(order.MarketID)
+
+		key := lockedBalanceKey{trader: order.TraderAddress, symbol: market.BaseTokenSymbol}
+		decimals := market.BaseTokenDecimals
+		if order.Side == "buy" {
+			key.symbol = market.QuoteTokenSymbol
+			decimals = market.QuoteTokenDecimals
+		}
+
+		if _, ok := lockedBalances[key]; !ok {
+			lockedBalances[key] = decimals
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		err := sendLockedBalanceChangeMessage(tx, key.trader, key.symbol, tx.BalanceDao.GetByAccountAndSymbol(key.trader, key.symbol, lockedBalances[key]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func InsertOrder(tx *dbTx, order *models.Order) error {
+	err := tx.OrderDao.InsertOrder(order)
+	if err != nil {
+		return err
 	}
 
-	return err
+	return sendOrderChangeMessages(tx, order)
 }
 
-func InsertOrder(order *models.Order) error {
-	err := models.OrderDao.InsertOrder(order)
+// sendOrderChangeMessages sends the order and the locked balance it changes,
+// the balance is read in the transaction so it includes the change of the order.
+func sendOrderChangeMessages(tx *dbTx, order *models.Order) error {
 	market := models.MarketDao.FindMarketByID(order.MarketID)
-	sendOrderUpdateMessage(order)
+
+	err := sendOrderUpdateMessage(tx, order)
+	if err != nil {
+		return err
+	}
 
 	if order.Side == "buy" {
-		sendLockedBalanceChangeMessage(order.TraderAddress, market.QuoteTokenSymbol, models.BalanceDao.GetByAccountAndSymbol(order.TraderAddress, market.QuoteTokenSymbol, market.QuoteTokenDecimals))
+		return sendLockedBalanceChangeMessage(tx, order.TraderAddress, market.QuoteTokenSymbol, tx.BalanceDao.GetByAccountAndSymbol(order.TraderAddress, market.QuoteTokenSymbol, market.QuoteTokenDecimals))
 	} else {
-		sendLockedBalanceChangeMessage(order.TraderAddress, market.BaseTokenSymbol, models.BalanceDao.GetByAccountAndSymbol(order.TraderAddress, market.BaseTokenSymbol, market.BaseTokenDecimals))
+		return sendLockedBalanceChangeMessage(tx, order.TraderAddress, market.BaseTokenSymbol, tx.BalanceDao.GetByAccountAndSymbol(order.TraderAddress, market.BaseTokenSymbol, market.BaseTokenDecimals))
 	}
-
-	return err
 }
 
-func UpdateTrade(trade *models.Trade) error {
-	err := models.TradeDao.UpdateTrade(trade)
-	sendTradeUpdateMessage(trade)
+func UpdateTrade(tx *dbTx, trade *models.Trade) error {
+	err := tx.TradeDao.UpdateTrade(trade)
+	if err != nil {
+		return err
+	}
+
+	err = sendTradeUpdateMessage(tx, trade)
+	if err != nil {
+		return err
+	}
 
 	if trade.Status == common.STATUS_SUCCESSFUL {
-		sendNewMarketTradeMessage(trade)
+		return sendNewMarketTradeMessage(tx, trade)
 	}
-	return err
+
+	return nil
 }
 
-func InsertTrade(trade *models.Trade) error {
-	err := models.TradeDao.InsertTrade(trade)
-	sendTradeUpdateMessage(trade)
-	return err
+func InsertTrade(tx *dbTx, trade *models.Trade) error {
+	err := tx.TradeDao.InsertTrade(trade)
+	if err != nil {
+		return err
+	}
+
+	return sendTradeUpdateMessage(tx, trade)
 }
 
 type MatchResultWithOrders struct {
@@ -53,6 +120,9 @@ type MatchResultWithOrders struct {
 	*common.MatchResult
 	modelTakerOrder  *models.Order
 	modelMakerOrders map[string]*models.Order
+
+	// trades of an uncross are at the auction price, other trades are at the price of the maker order
+	tradePrice decimal.Decimal
 }
 
 func NewMatchResultWithOrders(takerOrder *models.Order, result *common.MatchResult) *MatchResultWithOrders {
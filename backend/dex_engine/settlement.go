@@ -0,0 +1,108 @@
+package dex_engine
+
+import (
+	"strings"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/shopspring/decimal"
+)
+
+// settlementFailure is why a settlement transaction failed, and which orders are at fault.
+type settlementFailure struct {
+	Reason       string
+	FaultyOrders []string
+}
+
+func (f *settlementFailure) isFaulty(orderID string) bool {
+	for _, id := range f.FaultyOrders {
+		if id == orderID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// settlementFailureOf returns why the settlement transaction failed, and which orders are at fault.
+// A diagnosis saved with the transaction is used as it is, so a replayed failure has the same result,
+// else the one the watcher sent with the event. If there is none, the taker is at fault.
+func settlementFailureOf(transaction *models.Transaction, event *models.ConfirmTransactionEvent, trades []*models.Trade) *settlementFailure {
+	if transaction.FailureReason != "" {
+		failure := &settlementFailure{Reason: transaction.FailureReason}
+		if transaction.FaultyOrders != "" {
+			failure.FaultyOrders = strings.Split(transaction.FaultyOrders, ",")
+		}
+
+		return failure
+	}
+
+	if event != nil && event.FailureReason != "" {
+		return &settlementFailure{Reason: event.FailureReason, FaultyOrders: event.FaultyOrders}
+	}
+
+	return &settlementFailure{
+		Reason:       models.SETTLEMENT_FAILURE_UNKNOWN,
+		FaultyOrders: []string{trades[0].TakerOrderID},
+	}
+}
+
+// settleInBook takes the amounts of the trades out of settlement. If failure is not nil, the orders at fault are
+// taken out of the book, and the amounts of the other orders go back into the book where they can.
+// It returns the orders whose amounts went back, and the changes of the book.
+func (m *MarketHandler) settleInBook(trades []*models.Trade, failure *settlementFailure) (returned map[string]bool, msgs []common.WebSocketMessage) {
+	returned = make(map[string]bool)
+
+	var orderIDs []string
+	amounts := make(map[string]decimal.Decimal)
+
+	for _, trade := range trades {
+		for _, orderID := range []string{trade.TakerOrderID, trade.MakerOrderID} {
+			if _, ok := amounts[orderID]; !ok {
+				orderIDs = append(orderIDs, orderID)
+			}
+
+			amounts[orderID] = amounts[orderID].Add(trade.Amount)
+		}
+	}
+
+	addMessage := func(e *common.OrderbookEvent) {
+		if e != nil {
+			msgs = append(msgs, common.OrderBookChangeMessage(m.market.ID, m.orderbook.Sequence, e.Side, e.Price, e.Amount))
+		}
+	}
+
+	if failure != nil {
+		// the orders at fault leave the book first, the other orders may cross them otherwise
+		for _, orderID := range orderIDs {
+			if failure.isFaulty(orderID) {
+				addMessage(m.orderbook.removeOrder(orderID))
+			}
+		}
+
+		for _, orderID := range orderIDs {
+			if !failure.isFaulty(orderID) && m.canReturn(orderID) {
+				e, ok := m.orderbook.returnAmount(orderID, amounts[orderID])
+				returned[orderID] = ok
+				addMessage(e)
+			}
+		}
+	}
+
+	for _, orderID := range orderIDs {
+		m.orderbook.settle(orderID, amounts[orderID])
+	}
+
+	return returned, msgs
+}
+
+// canReturn returns true if the failed amount of the order may go back to the book. Orders which don't rest
+// in the book, and orders which expired, are canceled instead.
+func (m *MarketHandler) canReturn(orderID string) bool {
+	if _, ok := m.orderbook.getOrder(orderID); ok {
+		return true
+	}
+
+	order := models.OrderDao.FindByID(orderID)
+	return order != nil && restsInBook(order) && !isMarketOrder(order) && !m.isExpired(order)
+}
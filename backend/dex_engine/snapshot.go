@@ -0,0 +1,364 @@
+package dex_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/shopspring/decimal"
+)
+
+// marketSnapshotVersion should be bumped whenever the layout of marketSnapshot changes.
+// Snapshots of another version are ignored and the book is rebuilt from the database.
+const marketSnapshotVersion = 6
+
+// marketSnapshot is the persisted state of a market handler.
+type marketSnapshot struct {
+	Version     int          `json:"version"`
+	MarketID    string       `json:"marketID"`
+	Sequence    uint64       `json:"sequence"`
+	LastEventID int64        `json:"lastEventID"`
+	Orders      []*bookOrder `json:"orders"`
+	StopOrders  []*stopOrder `json:"stopOrders"`
+	// Settling is the matched amount of orders waiting for settlement
+	Settling []*bookOrder `json:"settling"`
+	// TradingState is the state of the market when the snapshot was taken, the replayed events may change it
+	TradingState string    `json:"tradingState"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func getMarketSnapshotKey(marketID string) string {
+	return fmt.Sprintf("HYDRO_MARKET_ENGINE_SNAPSHOT:%s", marketID)
+}
+
+func (m *MarketHandler) saveSnapshot() {
+	snapshot := &marketSnapshot{
+		Version:     marketSnapshotVersion,
+		MarketID:    m.market.ID,
+		Sequence:    m.orderbook.Sequence,
+		LastEventID: m.lastEventID,
+		Orders:      m.orderbook.restingOrders(),
+		StopOrders:  m.stopbook.waitingOrders(),
+		Settling:    m.orderbook.settlingOrders(),
+
+		TradingState: m.market.TradingState,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	bts, err := json.Marshal(snapshot)
+	if err != nil {
+		utils.Errorf("marshal snapshot of market %s failed: %v", m.market.ID, err)
+		return
+	}
+
+	err = m.kvStore.Set(getMarketSnapshotKey(m.market.ID), string(bts), 0)
+	if err != nil {
+		utils.Errorf("save snapshot of market %s failed: %v", m.market.ID, err)
+		return
+	}
+
+	m.eventsSinceSnapshot = 0
+	utils.Debugf("market %s snapshot saved, sequence: %d, last event: %d", m.market.ID, snapshot.Sequence, snapshot.LastEventID)
+}
+
+func (m *MarketHandler) loadSnapshot() *marketSnapshot {
+	res, err := m.kvStore.Get(getMarketSnapshotKey(m.market.ID))
+	if err == common.KVStoreEmpty {
+		return nil
+	} else if err != nil {
+		panic(fmt.Errorf("get snapshot error %v", err))
+	}
+
+	var snapshot marketSnapshot
+	err = json.Unmarshal([]byte(res), &snapshot)
+	if err != nil {
+		utils.Errorf("unmarshal snapshot of market %s failed: %v", m.market.ID, err)
+		return nil
+	}
+
+	if snapshot.Version != marketSnapshotVersion {
+		utils.Infof("ignore snapshot of market %s, version %d is not supported", m.market.ID, snapshot.Version)
+		return nil
+	}
+
+	return &snapshot
+}
+
+// loadPublishedSequence returns the sequence of the orderbook snapshot which clients are reading.
+func (m *MarketHandler) loadPublishedSequence() uint64 {
+	res, err := m.kvStore.Get(common.GetMarketOrderbookSnapshotV2Key(m.market.ID))
+	if err == common.KVStoreEmpty {
+		return 0
+	} else if err != nil {
+		panic(fmt.Errorf("get snapshot error %v", err))
+	}
+
+	var snapshot struct {
+		Sequence uint64 `json:"sequence"`
+	}
+
+	_ = json.Unmarshal([]byte(res), &snapshot)
+
+	return snapshot.Sequence
+}
+
+// restoreOrderbook loads the latest snapshot of the market and replays the events handled after it.
+// The result is checked against the pending and untriggered orders in the database, if there is no usable snapshot
+// or they don't match, the book is rebuilt from the database.
+// In all cases the sequence continues from where it was, so clients never see it going back.
+// The events journaled after the last handled one are kept for Run, they were not handled before the engine stopped.
+func (m *MarketHandler) restoreOrderbook() {
+	var sequence uint64
+	snapshot := m.loadSnapshot()
+
+	handledEventID, hasHandled := models.EngineEventDao.FindMarketHandledEvent(m.market.ID)
+	if hasHandled {
+		m.unhandledEvents = models.EngineEventDao.FindMarketEventsAfter(m.market.ID, handledEventID)
+	}
+
+	if snapshot != nil {
+		m.orderbook.restoreOrders(snapshot.Orders, snapshot.Settling, snapshot.Sequence)
+		m.stopbook.restoreOrders(snapshot.StopOrders)
+		m.lastEventID = snapshot.LastEventID
+		m.market.TradingState = snapshot.TradingState
+
+		// the snapshot may be saved before the event in it is saved as handled
+		for len(m.unhandledEvents) > 0 && m.unhandledEvents[0].ID <= snapshot.LastEventID {
+			m.unhandledEvents = m.unhandledEvents[1:]
+		}
+
+		var events []*models.EngineEvent
+		for _, event := range models.EngineEventDao.FindMarketEventsAfter(m.market.ID, snapshot.LastEventID) {
+			if hasHandled && event.ID > handledEventID {
+				break
+			}
+
+			events = append(events, event)
+		}
+
+		for _, event := range events {
+			m.replayEvent(event)
+		}
+
+		err := checkOrderbook(m.orderbook, models.OrderDao.FindMarketPendingOrders(m.market.ID))
+		if err == nil {
+			err = checkStopbook(m.stopbook, models.OrderDao.FindMarketUntriggeredOrders(m.market.ID))
+		}
+
+		if err == nil {
+			m.publishOrderbook()
+			utils.Infof("market %s restored from snapshot, sequence: %d, replayed events: %d", m.market.ID, m.orderbook.Sequence, len(events))
+			return
+		}
+
+		utils.Errorf("market %s snapshot doesn't match the database, rebuild the book: %v", m.market.ID, err)
+		sequence = m.orderbook.Sequence
+		m.orderbook = newOrderbook(m.market.ID, m.market.AmountDecimals)
+		m.stopbook = newStopbook()
+	} else {
+		sequence = m.loadPublishedSequence()
+	}
+
+	m.orderbook.Sequence = sequence
+	m.rebuildOrderbook()
+}
+
+// rebuildOrderbook re-inserts the available part of all pending orders into the book,
+// and the untriggered stop orders into the stop book.
+func (m *MarketHandler) rebuildOrderbook() {
+	orders := models.OrderDao.FindMarketPendingOrders(m.market.ID)
+
+	for _, order := range orders {
+		if order.AvailableAmount.LessThanOrEqual(decimal.Zero) {
+			continue
+		}
+
+		bookOrder := common.MemoryOrder{
+			MarketID: order.MarketID,
+			ID:       order.ID,
+			Price:    order.Price,
+			Amount:   order.AvailableAmount,
+			Side:     order.Side,
+			Trader:   order.TraderAddress,
+		}
+
+		e := m.orderbook.insertOrder(&bookOrder, order.DisplayAmount)
+		msg := common.OrderBookChangeMessage(m.market.ID, m.orderbook.Sequence, e.Side, e.Price, e.Amount)
+		_ = pushMessage(msg)
+	}
+
+	for _, order := range models.OrderDao.FindMarketUntriggeredOrders(m.market.ID) {
+		m.stopbook.insertOrder(order)
+	}
+
+	m.publishOrderbook()
+}
+
+// replayEvent applies an event to the book only, the database already holds its result.
+func (m *MarketHandler) replayEvent(event *models.EngineEvent) {
+	m.eventTime = event.CreatedAt
+
+	switch event.Type {
+	case common.EventNewOrder:
+		var e common.NewOrderEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		var order models.Order
+		_ = json.Unmarshal([]byte(e.Order), &order)
+
+		m.replayNewOrder(&order)
+	case common.EventCancelOrder:
+		var e common.CancelOrderEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		m.orderbook.removeOrder(e.ID)
+		m.stopbook.removeOrder(e.ID)
+	case models.EventAmendOrder:
+		var e models.AmendOrderEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		availableAmount, err := decimal.NewFromString(e.AvailableAmount)
+		if err == nil {
+			m.amendInBook(e.ID, availableAmount)
+		}
+	case models.EventReplaceOrder:
+		var e models.ReplaceOrderEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		var order models.Order
+		_ = json.Unmarshal([]byte(e.Order), &order)
+
+		replacedOrder := models.OrderDao.FindByID(e.ReplacedOrderID)
+		if m.marketStateRejectReason(nil) != "" || replacedOrder == nil || replacedOrder.TraderAddress != order.TraderAddress || !m.isOpen(replacedOrder.ID) {
+			break
+		}
+
+		m.orderbook.removeOrder(replacedOrder.ID)
+		m.stopbook.removeOrder(replacedOrder.ID)
+		m.replayNewOrder(&order)
+	case models.EventChangeMarketState:
+		var e models.ChangeMarketStateEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		m.market.TradingState = e.TradingState
+
+		if takesOrders(e.TradingState) {
+			m.orderbook.uncross()
+		}
+	case models.EventBulkCancelOrders:
+		var e models.BulkCancelOrdersEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		for _, id := range e.IDs {
+			m.orderbook.removeOrder(id)
+			m.stopbook.removeOrder(id)
+		}
+	case common.EventConfirmTransaction:
+		var e models.ConfirmTransactionEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		transaction := models.TransactionDao.FindTransactionByHash(e.Hash)
+		trades := models.TradeDao.FindTradesByHash(e.Hash)
+		if transaction == nil || len(trades) == 0 {
+			break
+		}
+
+		if e.Status == common.STATUS_FAILED {
+			m.settleInBook(trades, settlementFailureOf(transaction, &e, trades))
+			break
+		}
+
+		m.settleInBook(trades, nil)
+
+		if !m.market.IsMatching() {
+			break
+		}
+
+		for _, stopOrder := range m.stopbook.trigger(lastTradePrice(trades)) {
+			order := models.OrderDao.FindByID(stopOrder.ID)
+			if order == nil || m.rejectReason(order, stopOrder.MemoryOrder) != "" {
+				continue
+			}
+
+			m.matchInBook(order, stopOrder.MemoryOrder)
+		}
+	case models.EventRetryDeadLetter:
+		var e models.RetryDeadLetterEvent
+		_ = json.Unmarshal([]byte(event.Payload), &e)
+
+		deadLetter := models.DeadLetterEventDao.FindByID(e.DeadLetterID)
+		if deadLetter == nil || deadLetter.Type == models.EventRetryDeadLetter || deadLetter.MarketID != m.market.ID {
+			break
+		}
+
+		retried := *event
+		retried.Type = deadLetter.Type
+		retried.Payload = deadLetter.Payload
+		m.replayEvent(&retried)
+	}
+
+	m.lastEventID = event.ID
+}
+
+// replayNewOrder puts a new order into the books the way handleNewOrder does.
+func (m *MarketHandler) replayNewOrder(order *models.Order) {
+	memoryOrder := newMemoryOrder(order)
+
+	if order.Status == models.ORDER_UNTRIGGERED {
+		if m.marketStateRejectReason(nil) == "" {
+			m.stopbook.insertOrder(order)
+		}
+	} else if m.rejectReason(order, memoryOrder) == "" {
+		m.matchInBook(order, memoryOrder)
+	}
+}
+
+// checkOrderbook makes sure the book holds exactly the available part of the pending orders.
+func checkOrderbook(book *orderbook, pendingOrders []*models.Order) error {
+	count := 0
+
+	for _, order := range pendingOrders {
+		if order.AvailableAmount.LessThanOrEqual(decimal.Zero) {
+			continue
+		}
+
+		count++
+		bookOrder, ok := book.getOrder(order.ID)
+		if !ok {
+			return fmt.Errorf("order %s is not in the book", order.ID)
+		}
+
+		if !bookOrder.Price.Equal(order.Price) || !bookOrder.openAmount().Equal(order.AvailableAmount) {
+			return fmt.Errorf("order %s is %s@%s in the book, %s@%s in the database", order.ID, bookOrder.openAmount(), bookOrder.Price, order.AvailableAmount, order.Price)
+		}
+	}
+
+	if count != len(book.orders) {
+		return fmt.Errorf("%d orders in the book, %d in the database", len(book.orders), count)
+	}
+
+	return nil
+}
+
+// checkStopbook makes sure the stop book holds exactly the untriggered orders.
+func checkStopbook(book *stopbook, untriggeredOrders []*models.Order) error {
+	for _, order := range untriggeredOrders {
+		stopOrder, ok := book.getOrder(order.ID)
+		if !ok {
+			return fmt.Errorf("stop order %s is not in the stop book", order.ID)
+		}
+
+		if !stopOrder.StopPrice.Equal(order.StopPrice) {
+			return fmt.Errorf("stop order %s stops at %s in the stop book, %s in the database", order.ID, stopOrder.StopPrice, order.StopPrice)
+		}
+	}
+
+	if len(untriggeredOrders) != len(book.orders) {
+		return fmt.Errorf("%d orders in the stop book, %d in the database", len(book.orders), len(untriggeredOrders))
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package dex_engine
+
+import (
+	"sort"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/shopspring/decimal"
+)
+
+// stopOrder is a stop order waiting for the last trade price to cross StopPrice.
+type stopOrder struct {
+	*common.MemoryOrder
+	StopPrice decimal.Decimal `json:"stopPrice"`
+	Priority  uint64          `json:"priority"`
+}
+
+// isTriggeredBy returns true if a trade at price reaches the stop price,
+// buy stops are triggered when the price goes up to it, sell stops when the price goes down to it.
+func (o *stopOrder) isTriggeredBy(price decimal.Decimal) bool {
+	if o.Side == "buy" {
+		return price.GreaterThanOrEqual(o.StopPrice)
+	}
+
+	return price.LessThanOrEqual(o.StopPrice)
+}
+
+// stopbook holds the untriggered stop orders of a single market.
+type stopbook struct {
+	orders       map[string]*stopOrder
+	lastPriority uint64
+}
+
+func newStopbook() *stopbook {
+	return &stopbook{
+		orders: make(map[string]*stopOrder),
+	}
+}
+
+func (book *stopbook) insertOrder(order *models.Order) {
+	book.lastPriority = book.lastPriority + 1
+	book.orders[order.ID] = &stopOrder{
+		MemoryOrder: newMemoryOrder(order),
+		StopPrice:   order.StopPrice,
+		Priority:    book.lastPriority,
+	}
+}
+
+// removeOrder takes the order out of the book. It returns false if the order is not in it.
+func (book *stopbook) removeOrder(orderID string) bool {
+	_, ok := book.orders[orderID]
+	delete(book.orders, orderID)
+
+	return ok
+}
+
+func (book *stopbook) getOrder(orderID string) (*stopOrder, bool) {
+	order, ok := book.orders[orderID]
+	return order, ok
+}
+
+// trigger removes the orders triggered by a trade at price and returns them in time priority.
+func (book *stopbook) trigger(price decimal.Decimal) []*stopOrder {
+	var triggered []*stopOrder
+
+	for id, order := range book.orders {
+		if order.isTriggeredBy(price) {
+			triggered = append(triggered, order)
+			delete(book.orders, id)
+		}
+	}
+
+	sort.Slice(triggered, func(i, j int) bool {
+		return triggered[i].Priority < triggered[j].Priority
+	})
+
+	return triggered
+}
+
+// waitingOrders returns the orders of the book in time priority.
+func (book *stopbook) waitingOrders() []*stopOrder {
+	orders := make([]*stopOrder, 0, len(book.orders))
+	for _, order := range book.orders {
+		orders = append(orders, order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Priority < orders[j].Priority
+	})
+
+	return orders
+}
+
+// restoreOrders puts snapshot orders back into the book, keeping their priority.
+func (book *stopbook) restoreOrders(orders []*stopOrder) {
+	for _, order := range orders {
+		book.orders[order.ID] = order
+
+		if order.Priority > book.lastPriority {
+			book.lastPriority = order.Priority
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package dex_watcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk/ethereum"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/onrik/ethrpc"
+	"github.com/shopspring/decimal"
+)
+
+// settlementChain reads the balances and allowances of traders at a block.
+type settlementChain interface {
+	GetTokenBalanceAt(tokenAddress, address string, blockNumber uint64) (decimal.Decimal, error)
+	GetTokenAllowanceAt(tokenAddress, proxyAddress, address string, blockNumber uint64) (decimal.Decimal, error)
+}
+
+type ethereumChain struct {
+	client *ethrpc.EthRPC
+}
+
+func newEthereumChain(rpcURL string) *ethereumChain {
+	return &ethereumChain{client: ethrpc.New(rpcURL)}
+}
+
+func (c *ethereumChain) GetTokenBalanceAt(tokenAddress, address string, blockNumber uint64) (decimal.Decimal, error) {
+	return c.call(tokenAddress, address, fmt.Sprintf("0x70a08231000000000000000000000000%s", strings.TrimPrefix(address, "0x")), blockNumber)
+}
+
+func (c *ethereumChain) GetTokenAllowanceAt(tokenAddress, proxyAddress, address string, blockNumber uint64) (decimal.Decimal, error) {
+	data := fmt.Sprintf("0xdd62ed3e000000000000000000000000%s000000000000000000000000%s", strings.TrimPrefix(address, "0x"), strings.TrimPrefix(proxyAddress, "0x"))
+	return c.call(tokenAddress, address, data, blockNumber)
+}
+
+func (c *ethereumChain) call(to, from, data string, blockNumber uint64) (decimal.Decimal, error) {
+	res, err := c.client.EthCall(ethrpc.T{To: to, From: from, Data: data}, fmt.Sprintf("0x%x", blockNumber))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return utils.StringToDecimal(res), nil
+}
+
+// diagnoseSettlement finds out why the settlement transaction failed, and which orders are at fault.
+// The transaction ran out of gas, or a trader didn't have the balance or the allowance the trades need.
+// They are read at the block of the transaction, which the failed transaction left as it was, so they include
+// the settlements before it in the block. If nothing explains the failure, the taker is at fault.
+// It returns an empty reason if the trades of the transaction can't be found, the engine holds the taker at fault then.
+func diagnoseSettlement(chain settlementChain, tx sdk.Transaction, receipt sdk.TransactionReceipt, transaction *models.Transaction) (string, []string) {
+	trades := models.TradeDao.FindTradesByHash(tx.GetHash())
+	market := models.MarketDao.FindMarketByID(transaction.MarketID)
+	if len(trades) == 0 || market == nil {
+		return "", nil
+	}
+
+	if isOutOfGas(tx, receipt) {
+		return models.SETTLEMENT_FAILURE_GAS, nil
+	}
+
+	payments := settlementPayments(market, trades)
+	proxyAddress := os.Getenv("HSK_PROXY_ADDRESS")
+
+	for _, reason := range []string{models.SETTLEMENT_FAILURE_BALANCE, models.SETTLEMENT_FAILURE_ALLOWANCE} {
+		var faultyOrders []string
+
+		for _, payment := range payments {
+			var held decimal.Decimal
+			var err error
+
+			if reason == models.SETTLEMENT_FAILURE_BALANCE {
+				held, err = chain.GetTokenBalanceAt(payment.token, payment.trader, tx.GetBlockNumber())
+			} else {
+				held, err = chain.GetTokenAllowanceAt(payment.token, proxyAddress, payment.trader, tx.GetBlockNumber())
+			}
+
+			if err != nil {
+				utils.Errorf("diagnose settlement %s failed: %v", tx.GetHash(), err)
+				return models.SETTLEMENT_FAILURE_UNKNOWN, []string{trades[0].TakerOrderID}
+			}
+
+			if held.LessThan(payment.amount) {
+				faultyOrders = append(faultyOrders, payment.orderIDs...)
+			}
+		}
+
+		if len(faultyOrders) > 0 {
+			return reason, faultyOrders
+		}
+	}
+
+	return models.SETTLEMENT_FAILURE_UNKNOWN, []string{trades[0].TakerOrderID}
+}
+
+// isOutOfGas returns true if the transaction used all the gas it was given.
+func isOutOfGas(tx sdk.Transaction, receipt sdk.TransactionReceipt) bool {
+	ethereumReceipt, ok := receipt.(*ethereum.EthereumTransactionReceipt)
+	if !ok || ethereumReceipt.TransactionReceipt == nil {
+		return false
+	}
+
+	return ethereumReceipt.GasUsed >= tx.GetGas()
+}
+
+// settlementPayment is what a trader pays in a token for the trades of a transaction, in the smallest unit of the token.
+type settlementPayment struct {
+	trader   string
+	token    string
+	amount   decimal.Decimal
+	orderIDs []string
+}
+
+// settlementPayments returns what each trader pays for the trades: sellers pay the base token,
+// buyers pay the quote token and their fee.
+func settlementPayments(market *models.Market, trades []*models.Trade) []*settlementPayment {
+	var payments []*settlementPayment
+	byTraderAndToken := make(map[string]*settlementPayment)
+
+	pay := func(orderID string, isTaker bool, trade *models.Trade) {
+		order := models.OrderDao.FindByID(orderID)
+		if order == nil {
+			return
+		}
+
+		var token string
+		var amount decimal.Decimal
+
+		if order.Side == "sell" {
+			token = market.BaseTokenAddress
+			amount = trade.Amount.Mul(decimal.New(1, int32(market.BaseTokenDecimals)))
+		} else {
+			feeRate := order.MakerFeeRate
+			if isTaker {
+				feeRate = order.TakerFeeRate
+			}
+
+			token = market.QuoteTokenAddress
+			amount = trade.Amount.Mul(trade.Price).Mul(decimal.New(1, 0).Add(feeRate)).Mul(decimal.New(1, int32(market.QuoteTokenDecimals)))
+		}
+
+		key := strings.ToLower(order.TraderAddress) + token
+		payment, ok := byTraderAndToken[key]
+		if !ok {
+			payment = &settlementPayment{trader: order.TraderAddress, token: token}
+			byTraderAndToken[key] = payment
+			payments = append(payments, payment)
+		}
+
+		payment.amount = payment.amount.Add(amount)
+		if len(payment.orderIDs) == 0 || payment.orderIDs[len(payment.orderIDs)-1] != orderID {
+			payment.orderIDs = append(payment.orderIDs, orderID)
+		}
+	}
+
+	for _, trade := range trades {
+		pay(trade.TakerOrderID, true, trade)
+		pay(trade.MakerOrderID, false, trade)
+	}
+
+	return payments
+}
@@ -0,0 +1,146 @@
+package dex_watcher
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/connection"
+	"github.com/HydroProtocol/hydro-scaffold-dex/backend/models"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/HydroProtocol/hydro-sdk-backend/sdk"
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/HydroProtocol/nights-watch"
+	"github.com/HydroProtocol/nights-watch/plugin"
+	"github.com/HydroProtocol/nights-watch/structs"
+)
+
+type DBTransactionHandler struct {
+	eventQueue common.IQueue
+	kvStore    common.IKVStore
+
+	// failed settlements are diagnosed with it
+	chain settlementChain
+}
+
+func (handler DBTransactionHandler) TxHandlerFunc(txAndReceipt *structs.RemovableTxAndReceipt) {
+	tx := txAndReceipt.Tx
+	txReceipt := txAndReceipt.Receipt
+
+	launchLog := models.LaunchLogDao.FindByHash(tx.GetHash())
+	if launchLog == nil {
+		utils.Debugf("Skip useless transaction %s", tx.GetHash())
+		return
+	}
+
+	if launchLog.Status != common.STATUS_PENDING {
+		utils.Infof("LaunchLog is not pending %s, skip", launchLog.Hash.String)
+		return
+	}
+
+	txResult := txReceipt.GetResult()
+	hash := tx.GetHash()
+	transaction := models.TransactionDao.FindTransactionByID(launchLog.ItemID)
+	utils.Infof("Transaction %s txResult is %+v", tx.GetHash(), txResult)
+
+	var status string
+	if txResult {
+		status = common.STATUS_SUCCESSFUL
+	} else {
+		status = common.STATUS_FAILED
+	}
+
+	if launchLog.ItemType == "hydroApprove" {
+		launchLog.Status = status
+		errUpdate := models.LaunchLogDao.UpdateLaunchLog(launchLog)
+		if errUpdate != nil {
+			panic(errUpdate)
+		}
+		return
+	}
+
+	event := &models.ConfirmTransactionEvent{
+		ConfirmTransactionEvent: common.ConfirmTransactionEvent{
+			Event: common.Event{
+				Type:     common.EventConfirmTransaction,
+				MarketID: transaction.MarketID,
+			},
+			Hash:      hash,
+			Status:    status,
+			Timestamp: txAndReceipt.TimeStamp,
+		},
+	}
+
+	// the engine cancels the orders at fault, the diagnosis travels in the event so a replay cancels the same ones
+	if status == common.STATUS_FAILED {
+		event.FailureReason, event.FaultyOrders = diagnoseSettlement(handler.chain, tx, txReceipt, transaction)
+		utils.Infof("Transaction %s failed, reason: %s, faulty orders: %v", hash, event.FailureReason, event.FaultyOrders)
+	}
+
+	bts, _ := json.Marshal(event)
+	errQueue := handler.eventQueue.Push(bts)
+	if errQueue != nil {
+		utils.Errorf("Push event into Queue Error: %v", errQueue)
+	}
+
+	handler.kvStore.Set(common.HYDRO_WATCHER_BLOCK_NUMBER_CACHE_KEY, strconv.FormatUint(tx.GetBlockNumber(), 10), 0)
+}
+
+// Start watches the chain for the transactions of the launch logs and pushes their results to the engine
+// until ctx is canceled. The queue and the synced block are kept in redis, or in the process if HSK_REDIS_URL
+// is connection.MemoryURL. register is called with the watcher before it runs, to add more plugins, it may be nil.
+func Start(ctx context.Context, startMetrics func(), register func(watcher *nights_watch.AbstractWatcher)) {
+	models.Connect(os.Getenv("HSK_DATABASE_URL"))
+
+	backend := connection.NewBackend(ctx, os.Getenv("HSK_REDIS_URL"))
+
+	kvStore, err := backend.KVStore()
+	if err != nil {
+		panic(err)
+	}
+
+	queue, err := backend.Queue(common.HYDRO_ENGINE_EVENTS_QUEUE_KEY)
+	if err != nil {
+		panic(err)
+	}
+
+	filter := func(tx sdk.Transaction) bool {
+		return models.LaunchLogDao.FindByHash(tx.GetHash()) != nil
+	}
+
+	dbTxHandler := DBTransactionHandler{
+		eventQueue: queue,
+		kvStore:    kvStore,
+		chain:      newEthereumChain(os.Getenv("HSK_BLOCKCHAIN_RPC_URL")),
+	}
+
+	txReceiptPlugin := plugin.NewTxReceiptPluginWithFilter(dbTxHandler.TxHandlerFunc, filter)
+
+	watcher := nights_watch.NewHttpBasedEthWatcher(ctx, os.Getenv("HSK_BLOCKCHAIN_RPC_URL"))
+	watcher.RegisterTxReceiptPlugin(txReceiptPlugin)
+
+	if register != nil {
+		register(watcher)
+	}
+
+	syncedBlockInCache, err := kvStore.Get(common.HYDRO_WATCHER_BLOCK_NUMBER_CACHE_KEY)
+	if err != nil && err != common.KVStoreEmpty {
+		panic(err)
+	}
+
+	var startFromBlock uint64
+	if b, convErr := strconv.Atoi(syncedBlockInCache); convErr == nil {
+		startFromBlock = uint64(b) + 1
+	} else {
+		startFromBlock = 0
+	}
+
+	go startMetrics()
+	errRun := watcher.RunTillExitFromBlock(startFromBlock)
+	if errRun != nil {
+		utils.Infof("Watcher Exit with err: %s", errRun)
+	} else {
+		utils.Infof("Watcher Exit")
+	}
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scopes of an api key
+const (
+	// API_KEY_SCOPE_READ keys read the orders, trades and balances of the trader
+	API_KEY_SCOPE_READ = "read"
+	// API_KEY_SCOPE_TRADE keys build, place, amend and replace orders, placed orders are still signed by the wallet
+	API_KEY_SCOPE_TRADE = "trade"
+	// API_KEY_SCOPE_CANCEL keys cancel orders
+	API_KEY_SCOPE_CANCEL = "cancel"
+)
+
+type IApiKeyDao interface {
+	InsertApiKey(apiKey *ApiKey) error
+	FindActiveApiKey(keyHash string) *ApiKey
+	FindActiveApiKeysByAddress(address string) []*ApiKey
+	RevokeApiKey(address string, id int64, revokedAt time.Time) (bool, error)
+	UseNonce(id int64, nonce int64, usedAt time.Time) (bool, error)
+}
+
+// ApiKey lets a bot authenticate the requests of a trader without the private key of the wallet.
+// Only the hash of the key is kept, KeyPrefix is enough to tell the keys of a trader apart.
+// Scopes and AllowedIPs are separated by commas, AllowedIPs holds IPs or CIDR blocks and is empty if any IP is allowed.
+// LastNonce is the nonce of the last request, every request must use a larger one.
+type ApiKey struct {
+	ID         int64      `json:"id"         db:"id" primaryKey:"true" autoIncrement:"true" gorm:"primary_key"`
+	Address    string     `json:"address"    db:"address"`
+	Name       string     `json:"name"       db:"name"`
+	KeyHash    string     `json:"-"          db:"key_hash"`
+	KeyPrefix  string     `json:"keyPrefix"  db:"key_prefix"`
+	Scopes     string     `json:"scopes"     db:"scopes"`
+	AllowedIPs string     `json:"allowedIPs" db:"allowed_ips" gorm:"column:allowed_ips"`
+	LastNonce  int64      `json:"lastNonce"  db:"last_nonce"`
+	LastUsedAt *time.Time `json:"lastUsedAt" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revokedAt"  db:"revoked_at"`
+	CreatedAt  time.Time  `json:"createdAt"  db:"created_at"`
+}
+
+func (ApiKey) TableName() string {
+	return "api_keys"
+}
+
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsIP returns true if the key may be used from ip.
+func (k *ApiKey) AllowsIP(ip string) bool {
+	if k.AllowedIPs == "" {
+		return true
+	}
+
+	requestIP := net.ParseIP(ip)
+	if requestIP == nil {
+		return false
+	}
+
+	for _, allowed := range strings.Split(k.AllowedIPs, ",") {
+		if _, block, err := net.ParseCIDR(allowed); err == nil {
+			if block.Contains(requestIP) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(requestIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var ApiKeyDao IApiKeyDao
+var ApiKeyDaoPG IApiKeyDao
+
+func init() {
+	ApiKeyDao = &apiKeyDaoPG{}
+	ApiKeyDaoPG = ApiKeyDao
+}
+
+type apiKeyDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
+}
+
+func (d apiKeyDaoPG) InsertApiKey(apiKey *ApiKey) error {
+	return conn(d.tx).Create(apiKey).Error
+}
+
+func (d apiKeyDaoPG) FindActiveApiKey(keyHash string) *ApiKey {
+	var apiKeys []*ApiKey
+	conn(d.tx).Where("key_hash = ? and revoked_at is null", keyHash).Find(&apiKeys)
+	if len(apiKeys) == 0 {
+		return nil
+	}
+
+	return apiKeys[0]
+}
+
+func (d apiKeyDaoPG) FindActiveApiKeysByAddress(address string) []*ApiKey {
+	var apiKeys []*ApiKey
+	conn(d.tx).Where("address = ? and revoked_at is null", address).Order("id desc").Find(&apiKeys)
+	return apiKeys
+}
+
+// RevokeApiKey revokes the key of the address, it returns false if the address has no such active key.
+func (d apiKeyDaoPG) RevokeApiKey(address string, id int64, revokedAt time.Time) (bool, error) {
+	result := conn(d.tx).Exec(`update api_keys set revoked_at = ? where id = ? and address = ? and revoked_at is null`, revokedAt, id, address)
+	return result.RowsAffected == 1, result.Error
+}
+
+// UseNonce saves the nonce of a request of the key. It returns false if the nonce is not larger than the last one,
+// the request may be a replay then.
+func (d apiKeyDaoPG) UseNonce(id int64, nonce int64, usedAt time.Time) (bool, error) {
+	result := conn(d.tx).Exec(`update api_keys set last_nonce = ?, last_used_at = ? where id = ? and last_nonce < ?`, nonce, usedAt, id, nonce)
+	return result.RowsAffected == 1, result.Error
+}
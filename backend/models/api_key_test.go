@@ -0,0 +1,65 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestApiKeyDao_PG_UseNonce(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	now := time.Now()
+
+	apiKey := &ApiKey{
+		Address:   TestUser1,
+		Name:      "maker",
+		KeyHash:   "hash1",
+		KeyPrefix: "hk_01234",
+		Scopes:    "read,trade",
+		CreatedAt: now,
+	}
+	assert.Nil(t, ApiKeyDaoPG.InsertApiKey(apiKey))
+
+	apiKey = ApiKeyDaoPG.FindActiveApiKey("hash1")
+	assert.EqualValues(t, TestUser1, apiKey.Address)
+	assert.Len(t, ApiKeyDaoPG.FindActiveApiKeysByAddress(TestUser1), 1)
+
+	used, err := ApiKeyDaoPG.UseNonce(apiKey.ID, 100, now)
+	assert.Nil(t, err)
+	assert.True(t, used)
+
+	// nonces only grow
+	used, _ = ApiKeyDaoPG.UseNonce(apiKey.ID, 100, now)
+	assert.False(t, used)
+	used, _ = ApiKeyDaoPG.UseNonce(apiKey.ID, 99, now)
+	assert.False(t, used)
+	used, _ = ApiKeyDaoPG.UseNonce(apiKey.ID, 101, now)
+	assert.True(t, used)
+	assert.EqualValues(t, 101, ApiKeyDaoPG.FindActiveApiKey("hash1").LastNonce)
+
+	// a key is revoked by its owner only
+	revoked, _ := ApiKeyDaoPG.RevokeApiKey(TestUser2, apiKey.ID, now)
+	assert.False(t, revoked)
+	revoked, _ = ApiKeyDaoPG.RevokeApiKey(TestUser1, apiKey.ID, now)
+	assert.True(t, revoked)
+	assert.Nil(t, ApiKeyDaoPG.FindActiveApiKey("hash1"))
+	assert.Len(t, ApiKeyDaoPG.FindActiveApiKeysByAddress(TestUser1), 0)
+}
+
+func TestApiKey_Permissions(t *testing.T) {
+	apiKey := &ApiKey{Scopes: "read,cancel", AllowedIPs: "10.0.0.0/8,192.168.1.7"}
+
+	assert.True(t, apiKey.HasScope(API_KEY_SCOPE_READ))
+	assert.True(t, apiKey.HasScope(API_KEY_SCOPE_CANCEL))
+	assert.False(t, apiKey.HasScope(API_KEY_SCOPE_TRADE))
+
+	assert.True(t, apiKey.AllowsIP("10.1.2.3"))
+	assert.True(t, apiKey.AllowsIP("192.168.1.7"))
+	assert.False(t, apiKey.AllowsIP("192.168.1.8"))
+	assert.False(t, apiKey.AllowsIP("not an ip"))
+
+	apiKey.AllowedIPs = ""
+	assert.True(t, apiKey.AllowsIP("192.168.1.8"))
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/jinzhu/gorm"
 	"github.com/shopspring/decimal"
 )
 
@@ -31,13 +32,15 @@ func (d *nullDecimal) Scan(value interface{}) error {
 }
 
 type balanceDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
 }
 
-func (balanceDaoPG) GetByAccountAndSymbol(account, tokenSymbol string, decimals int) decimal.Decimal {
+func (d balanceDaoPG) GetByAccountAndSymbol(account, tokenSymbol string, decimals int) decimal.Decimal {
 	var sellLockedBalance nullDecimal
 	var buyLockedBalance nullDecimal
 
-	sellRow := DB.Raw(`select sum(available_amount + pending_amount) as locked_balance from orders where status='pending' and trader_address= $1 and market_id like $2 and side = 'sell'`, account, tokenSymbol+"-%").Row()
+	sellRow := conn(d.tx).Raw(`select sum(available_amount + pending_amount) as locked_balance from orders where status in ('pending', 'untriggered') and trader_address= $1 and market_id like $2 and side = 'sell'`, account, tokenSymbol+"-%").Row()
 	if sellRow == nil {
 		sellLockedBalance.Scan(nil)
 	}
@@ -46,7 +49,7 @@ func (balanceDaoPG) GetByAccountAndSymbol(account, tokenSymbol string, decimals
 		panic(err)
 	}
 
-	buyRow := DB.Raw(`select sum( (available_amount + pending_amount) * price) as locked_balance from orders where trader_address = $1 and status = 'pending' and market_id like $2 and side = 'buy'`, account, "%-"+tokenSymbol).Row()
+	buyRow := conn(d.tx).Raw(`select sum( (available_amount + pending_amount) * price) as locked_balance from orders where trader_address = $1 and status in ('pending', 'untriggered') and market_id like $2 and side = 'buy'`, account, "%-"+tokenSymbol).Row()
 	if buyRow == nil {
 		buyLockedBalance.Scan(nil)
 	}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// CallAuctionPrice is the indicative price of a market in a call auction, the price at which the most volume
+// would trade if the auction ended now. The engine saves it in the kv store and sends it on the market channel.
+type CallAuctionPrice struct {
+	MarketID string          `json:"marketID"`
+	Price    decimal.Decimal `json:"price"`
+	Volume   decimal.Decimal `json:"volume"`
+	// Surplus is the amount of SurplusSide which would not trade at the price, SurplusSide is empty if there is none
+	Surplus     decimal.Decimal `json:"surplus"`
+	SurplusSide string          `json:"surplusSide"`
+}
+
+func GetMarketCallAuctionPriceKey(marketID string) string {
+	return "HYDRO_MARKET_CALL_AUCTION_PRICE:" + marketID
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"fmt"
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+// CandleResolutions are the resolutions candles are kept in, in seconds: 1m, 5m, 15m, 1h, 6h, 1d and 1w.
+var CandleResolutions = []int64{60, 300, 900, 3600, 21600, 86400, 604800}
+
+// candleBatchSize is how many candles are saved in one statement.
+const candleBatchSize = 1000
+
+// weekOffset moves the weekly periods from the epoch, which is a Thursday, to Mondays.
+const weekOffset = 4 * 86400
+
+type ICandleDao interface {
+	FindCandles(marketID string, resolution, from, to int64) []*Candle
+	FindLastCandle(marketID string, resolution, before int64) *Candle
+	AddTrades(trades []*Trade) error
+	SaveCandles(candles []*Candle) error
+}
+
+// Candle holds the trades of a market executed in one period of a resolution. StartTime is the start of the period
+// in seconds since the epoch, periods are aligned to the epoch except weeks, which start on Mondays.
+type Candle struct {
+	MarketID   string          `json:"marketID"   db:"market_id" gorm:"primary_key"`
+	Resolution int64           `json:"resolution" db:"resolution" gorm:"primary_key"`
+	StartTime  int64           `json:"time"       db:"start_time" gorm:"primary_key"`
+	Open       decimal.Decimal `json:"open"       db:"open"`
+	High       decimal.Decimal `json:"high"       db:"high"`
+	Low        decimal.Decimal `json:"low"        db:"low"`
+	Close      decimal.Decimal `json:"close"      db:"close"`
+	Volume     decimal.Decimal `json:"volume"     db:"volume"`
+	TradeCount int             `json:"tradeCount" db:"trade_count"`
+	UpdatedAt  time.Time       `json:"updatedAt"  db:"updated_at"`
+}
+
+func (Candle) TableName() string {
+	return "candles"
+}
+
+func IsCandleResolution(resolution int64) bool {
+	for _, r := range CandleResolutions {
+		if r == resolution {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CandleStartTime returns the start of the period of the resolution the time falls in.
+func CandleStartTime(t time.Time, resolution int64) int64 {
+	var offset int64
+	if resolution == 604800 {
+		offset = weekOffset
+	}
+
+	// rounded down for times before the offset too
+	elapsed := t.Unix() - offset
+	if remainder := elapsed % resolution; remainder < 0 {
+		elapsed -= resolution + remainder
+	} else {
+		elapsed -= remainder
+	}
+
+	return elapsed + offset
+}
+
+// NewCandle opens a candle of the resolution with the trade.
+func NewCandle(trade *Trade, resolution int64) *Candle {
+	return &Candle{
+		MarketID:   trade.MarketID,
+		Resolution: resolution,
+		StartTime:  CandleStartTime(trade.ExecutedAt, resolution),
+		Open:       trade.Price,
+		High:       trade.Price,
+		Low:        trade.Price,
+		Close:      trade.Price,
+		Volume:     trade.Amount,
+		TradeCount: 1,
+		UpdatedAt:  time.Now().UTC(),
+	}
+}
+
+// AddTrade adds a trade executed after the trades already in the candle.
+func (c *Candle) AddTrade(trade *Trade) {
+	c.High = decimal.Max(c.High, trade.Price)
+	c.Low = decimal.Min(c.Low, trade.Price)
+	c.Close = trade.Price
+	c.Volume = c.Volume.Add(trade.Amount)
+	c.TradeCount++
+	c.UpdatedAt = time.Now().UTC()
+}
+
+var CandleDao ICandleDao
+var CandleDaoPG ICandleDao
+
+func init() {
+	CandleDao = &candleDaoPG{}
+	CandleDaoPG = CandleDao
+}
+
+type candleDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
+}
+
+// FindCandles returns the candles of the resolution which start between from and to, both included, oldest first.
+func (d candleDaoPG) FindCandles(marketID string, resolution, from, to int64) []*Candle {
+	var candles []*Candle
+
+	conn(d.tx).Where("market_id = ? and resolution = ? and start_time >= ? and start_time <= ?", marketID, resolution, from, to).Order("start_time asc").Find(&candles)
+	return candles
+}
+
+// FindLastCandle returns the latest candle of the resolution which starts before the time, or nil if there is none.
+func (d candleDaoPG) FindLastCandle(marketID string, resolution, before int64) *Candle {
+	var candles []*Candle
+
+	conn(d.tx).Where("market_id = ? and resolution = ? and start_time < ?", marketID, resolution, before).Order("start_time desc").Limit(1).Find(&candles)
+	if len(candles) == 0 {
+		return nil
+	}
+
+	return candles[0]
+}
+
+// AddTrades adds confirmed trades to the candles of every resolution. The trades must be added in the order they
+// were executed, the close of a candle is the price of the trade added last.
+func (d candleDaoPG) AddTrades(trades []*Trade) error {
+	for _, trade := range trades {
+		var candles []*Candle
+		for _, resolution := range CandleResolutions {
+			candles = append(candles, NewCandle(trade, resolution))
+		}
+
+		err := d.upsertCandles(candles, `
+			high = greatest(candles.high, excluded.high),
+			low = least(candles.low, excluded.low),
+			close = excluded.close,
+			volume = candles.volume + excluded.volume,
+			trade_count = candles.trade_count + excluded.trade_count,
+			updated_at = excluded.updated_at`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveCandles saves whole candles, the candles in the table are replaced.
+func (d candleDaoPG) SaveCandles(candles []*Candle) error {
+	for start := 0; start < len(candles); start += candleBatchSize {
+		end := start + candleBatchSize
+		if end > len(candles) {
+			end = len(candles)
+		}
+
+		err := d.upsertCandles(candles[start:end], `
+			open = excluded.open,
+			high = excluded.high,
+			low = excluded.low,
+			close = excluded.close,
+			volume = excluded.volume,
+			trade_count = excluded.trade_count,
+			updated_at = excluded.updated_at`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d candleDaoPG) upsertCandles(candles []*Candle, update string) error {
+	if len(candles) == 0 {
+		return nil
+	}
+
+	var rows []string
+	var values []interface{}
+
+	for _, c := range candles {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		values = append(values, c.MarketID, c.Resolution, c.StartTime, c.Open, c.High, c.Low, c.Close, c.Volume, c.TradeCount, c.UpdatedAt)
+	}
+
+	sql := fmt.Sprintf(
+		"insert into candles (market_id, resolution, start_time, open, high, low, close, volume, trade_count, updated_at) values %s on conflict (market_id, resolution, start_time) do update set %s",
+		strings.Join(rows, ", "), update,
+	)
+
+	return conn(d.tx).Exec(sql, values...).Error
+}
+
+// BackfillCandles rebuilds the candles of the market from its successful trades executed since from, and returns
+// how many trades it read. From is moved back to the start of its week, so that no candle is rebuilt from only
+// a part of its trades.
+// Trades the engine confirms while the candles are rebuilt may be left out of them, it should be stopped meanwhile.
+func BackfillCandles(marketID string, from time.Time, batchSize int) (int, error) {
+	weekly := CandleResolutions[len(CandleResolutions)-1]
+
+	// the trades are paged up from a cursor before the first of them
+	query := &TradeQuery{
+		MarketID: marketID,
+		Status:   common.STATUS_SUCCESSFUL,
+		After:    &TradeCursor{ExecutedAt: time.Unix(CandleStartTime(from, weekly), 0), ID: 0},
+		Limit:    batchSize,
+	}
+
+	open := make(map[int64]*Candle)
+	var closed []*Candle
+	var count int
+
+	for {
+		trades := TradeDao.FindMarketTradesPage(query)
+		if len(trades) == 0 {
+			break
+		}
+
+		// the page is newest first
+		for i := len(trades) - 1; i >= 0; i-- {
+			trade := trades[i]
+
+			for _, resolution := range CandleResolutions {
+				candle := open[resolution]
+
+				if candle != nil && candle.StartTime == CandleStartTime(trade.ExecutedAt, resolution) {
+					candle.AddTrade(trade)
+					continue
+				}
+
+				if candle != nil {
+					closed = append(closed, candle)
+				}
+
+				open[resolution] = NewCandle(trade, resolution)
+			}
+		}
+
+		count += len(trades)
+		query.After = &TradeCursor{ExecutedAt: trades[0].ExecutedAt, ID: trades[0].ID}
+
+		if len(closed) >= candleBatchSize {
+			if err := CandleDao.SaveCandles(closed); err != nil {
+				return count, err
+			}
+
+			closed = nil
+		}
+	}
+
+	for _, resolution := range CandleResolutions {
+		if candle := open[resolution]; candle != nil {
+			closed = append(closed, candle)
+		}
+	}
+
+	return count, CandleDao.SaveCandles(closed)
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newCandleTrade(price, amount string, executedAt int64) *Trade {
+	trade := NewTradeWithTime("WETH-DAI", true, time.Unix(executedAt, 0))
+	trade.Price = utils.StringToDecimal(price)
+	trade.Amount = utils.StringToDecimal(amount)
+	return trade
+}
+
+func TestCandleStartTime(t *testing.T) {
+	assert.EqualValues(t, 120, CandleStartTime(time.Unix(179, 0), 60))
+	assert.EqualValues(t, 0, CandleStartTime(time.Unix(179, 0), 300))
+
+	// weeks start on Mondays, the epoch is a Thursday
+	assert.EqualValues(t, -259200, CandleStartTime(time.Unix(0, 0), 604800))
+	monday := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	assert.EqualValues(t, monday.Unix(), CandleStartTime(monday, 604800))
+	assert.EqualValues(t, monday.Unix(), CandleStartTime(monday.Add(7*24*time.Hour-time.Second), 604800))
+	assert.EqualValues(t, monday.Unix()-604800, CandleStartTime(monday.Add(-time.Second), 604800))
+	assert.True(t, IsCandleResolution(3600))
+	assert.False(t, IsCandleResolution(7200))
+}
+
+func TestCandleDao_PG_AddTrades(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	err := CandleDaoPG.AddTrades([]*Trade{
+		newCandleTrade("2", "1", 60),
+		newCandleTrade("3", "2", 100),
+		newCandleTrade("1", "1", 110),
+	})
+	assert.Nil(t, err)
+
+	err = CandleDaoPG.AddTrades([]*Trade{newCandleTrade("1.5", "1", 130)})
+	assert.Nil(t, err)
+
+	candles := CandleDaoPG.FindCandles("WETH-DAI", 60, 0, 600)
+	assert.EqualValues(t, 2, len(candles))
+	assert.EqualValues(t, 60, candles[0].StartTime)
+	assert.EqualValues(t, "2", candles[0].Open.String())
+	assert.EqualValues(t, "3", candles[0].High.String())
+	assert.EqualValues(t, "1", candles[0].Low.String())
+	assert.EqualValues(t, "1", candles[0].Close.String())
+	assert.EqualValues(t, "4", candles[0].Volume.String())
+	assert.EqualValues(t, 3, candles[0].TradeCount)
+	assert.EqualValues(t, 120, candles[1].StartTime)
+
+	candles = CandleDaoPG.FindCandles("WETH-DAI", 604800, -604800, 0)
+	assert.EqualValues(t, 1, len(candles))
+	assert.EqualValues(t, "2", candles[0].Open.String())
+	assert.EqualValues(t, "1.5", candles[0].Close.String())
+	assert.EqualValues(t, 4, candles[0].TradeCount)
+
+	last := CandleDaoPG.FindLastCandle("WETH-DAI", 60, 120)
+	assert.EqualValues(t, 60, last.StartTime)
+	assert.Nil(t, CandleDaoPG.FindLastCandle("WETH-DAI", 60, 60))
+}
+
+func TestCandleDao_PG_BackfillCandles(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	// on a Friday, the trades are in the same weekly candle
+	start := time.Unix(2580*604800+86400, 0)
+	var trades []*Trade
+	for i := 0; i < 7; i++ {
+		trade := NewTradeWithTime("WETH-DAI", true, start.Add(time.Duration(i*40)*time.Second))
+		_ = TradeDaoPG.InsertTrade(trade)
+		trades = append(trades, trade)
+	}
+	_ = TradeDaoPG.InsertTrade(NewTradeWithTime("WETH-DAI", false, start))
+
+	// a stale candle is replaced by the backfill
+	_ = CandleDaoPG.AddTrades(trades[:1])
+	_ = CandleDaoPG.AddTrades(trades[:1])
+
+	count, err := BackfillCandles("WETH-DAI", start, 3)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, count)
+
+	var tradeCount int
+	for _, candle := range CandleDaoPG.FindCandles("WETH-DAI", 60, 0, start.Unix()+3600) {
+		tradeCount += candle.TradeCount
+	}
+	assert.EqualValues(t, 7, tradeCount)
+
+	candles := CandleDaoPG.FindCandles("WETH-DAI", 604800, 0, start.Unix())
+	assert.EqualValues(t, 1, len(candles))
+	assert.EqualValues(t, 7, candles[0].TradeCount)
+	assert.EqualValues(t, trades[0].Price.String(), candles[0].Open.String())
+	assert.EqualValues(t, trades[6].Price.String(), candles[0].Close.String())
+}
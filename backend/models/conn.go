@@ -3,13 +3,29 @@ package models
 import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"sync"
 	"time"
 )
 
 var DB *gorm.DB
 
+var (
+	connectMu sync.Mutex
+	dbURL     string
+)
+
+// Connect opens the database at url, it reuses DB if it is connected to url already,
+// so services running in one process share the connection.
 func Connect(url string) *gorm.DB {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	if DB != nil && dbURL == url {
+		return DB
+	}
+
 	db, err := gorm.Open("postgres", url)
 
 	if err != nil {
@@ -21,5 +37,21 @@ func Connect(url string) *gorm.DB {
 	}
 
 	DB = db
+	dbURL = url
 	return db
 }
+
+// conn returns the sql transaction a dao is bound to, or the global connection.
+func conn(tx *gorm.DB) *gorm.DB {
+	if tx != nil {
+		return tx
+	}
+
+	return DB
+}
+
+// isUniqueViolation returns true if err is postgres refusing a row which breaks a unique constraint.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// EventRetryDeadLetter asks the engine to handle the event of a dead letter again.
+// A retry which fails is counted as another attempt of the same dead letter.
+const EventRetryDeadLetter = "EVENT/EVENT_RETRY_DEAD_LETTER"
+
+type RetryDeadLetterEvent struct {
+	common.Event
+	DeadLetterID int64 `json:"deadLetterID"`
+}
+
+// Status of a dead letter
+const (
+	// DEAD_LETTER_PENDING dead letters wait for the admin to retry or discard them
+	DEAD_LETTER_PENDING = "pending"
+	// DEAD_LETTER_RETRYING dead letters are sent to the engine again, they are pending again if the retry fails
+	DEAD_LETTER_RETRYING = "retrying"
+	// DEAD_LETTER_RESOLVED dead letters were handled by a retry
+	DEAD_LETTER_RESOLVED = "resolved"
+	// DEAD_LETTER_DISCARDED dead letters were dropped by the admin
+	DEAD_LETTER_DISCARDED = "discarded"
+)
+
+type IDeadLetterEventDao interface {
+	InsertEvent(event *DeadLetterEvent) error
+	UpdateEvent(event *DeadLetterEvent) error
+	FindByID(id int64) *DeadLetterEvent
+	FindEvents(status string, limit, offset int) (int64, []*DeadLetterEvent)
+}
+
+// DeadLetterEvent is an engine event which failed, with the error and the stack of its last attempt.
+// EngineEventID is the journal entry of the event, the payload is kept as well because events which can't be
+// parsed or routed to a market are dead letters too.
+type DeadLetterEvent struct {
+	ID            int64     `json:"id"            db:"id" primaryKey:"true" autoIncrement:"true" gorm:"primary_key"`
+	EngineEventID int64     `json:"engineEventID" db:"engine_event_id"`
+	MarketID      string    `json:"marketID"      db:"market_id"`
+	Type          string    `json:"type"          db:"type"`
+	Payload       string    `json:"payload"       db:"payload"`
+	Error         string    `json:"error"         db:"error"`
+	Stack         string    `json:"stack"         db:"stack"`
+	Attempts      int       `json:"attempts"      db:"attempts"`
+	Status        string    `json:"status"        db:"status"`
+	CreatedAt     time.Time `json:"createdAt"     db:"created_at"`
+	UpdatedAt     time.Time `json:"updatedAt"     db:"updated_at"`
+}
+
+func (DeadLetterEvent) TableName() string {
+	return "dead_letter_events"
+}
+
+var DeadLetterEventDao IDeadLetterEventDao
+var DeadLetterEventDaoPG IDeadLetterEventDao
+
+func init() {
+	DeadLetterEventDao = &deadLetterEventDaoPG{}
+	DeadLetterEventDaoPG = DeadLetterEventDao
+}
+
+type deadLetterEventDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
+}
+
+func (d deadLetterEventDaoPG) InsertEvent(event *DeadLetterEvent) error {
+	return conn(d.tx).Create(event).Error
+}
+
+func (d deadLetterEventDaoPG) UpdateEvent(event *DeadLetterEvent) error {
+	return conn(d.tx).Save(event).Error
+}
+
+func (d deadLetterEventDaoPG) FindByID(id int64) *DeadLetterEvent {
+	var events []*DeadLetterEvent
+	conn(d.tx).Where("id = ?", id).Find(&events)
+	if len(events) == 0 {
+		return nil
+	}
+
+	return events[0]
+}
+
+// FindEvents returns the dead letters with status, the latest first. All dead letters are returned if status is empty.
+func (d deadLetterEventDaoPG) FindEvents(status string, limit, offset int) (int64, []*DeadLetterEvent) {
+	var events []*DeadLetterEvent
+	var count int64
+
+	query := conn(d.tx).Model(&DeadLetterEvent{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	query.Count(&count)
+	query.Order("id desc").Limit(limit).Offset(offset).Find(&events)
+
+	return count, events
+}
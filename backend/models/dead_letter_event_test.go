@@ -0,0 +1,58 @@
+package models
+
+import (
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterEventDao_PG_FindEvents(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	count, events := DeadLetterEventDaoPG.FindEvents("", 10, 0)
+	assert.EqualValues(t, 0, count)
+	assert.Len(t, events, 0)
+
+	for i := 0; i < 3; i++ {
+		_ = DeadLetterEventDaoPG.InsertEvent(newDeadLetterEvent())
+	}
+
+	deadLetter := DeadLetterEventDaoPG.FindByID(2)
+	assert.EqualValues(t, DEAD_LETTER_PENDING, deadLetter.Status)
+
+	deadLetter.Status = DEAD_LETTER_DISCARDED
+	deadLetter.Attempts++
+	_ = DeadLetterEventDaoPG.UpdateEvent(deadLetter)
+
+	deadLetter = DeadLetterEventDaoPG.FindByID(2)
+	assert.EqualValues(t, DEAD_LETTER_DISCARDED, deadLetter.Status)
+	assert.EqualValues(t, 2, deadLetter.Attempts)
+
+	count, events = DeadLetterEventDaoPG.FindEvents(DEAD_LETTER_PENDING, 10, 0)
+	assert.EqualValues(t, 2, count)
+	assert.EqualValues(t, 3, events[0].ID)
+	assert.EqualValues(t, 1, events[1].ID)
+
+	count, events = DeadLetterEventDaoPG.FindEvents("", 1, 1)
+	assert.EqualValues(t, 3, count)
+	assert.Len(t, events, 1)
+	assert.EqualValues(t, 2, events[0].ID)
+
+	assert.Nil(t, DeadLetterEventDaoPG.FindByID(4))
+}
+
+func newDeadLetterEvent() *DeadLetterEvent {
+	return &DeadLetterEvent{
+		EngineEventID: 1,
+		MarketID:      "HOT-DAI",
+		Type:          common.EventNewOrder,
+		Payload:       "{}",
+		Error:         "error",
+		Attempts:      1,
+		Status:        DEAD_LETTER_PENDING,
+		CreatedAt:     time.Now().UTC(),
+		UpdatedAt:     time.Now().UTC(),
+	}
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// EventBulkCancelOrders cancels many orders of a market at once, the engine removes all of them from the book
+// in a single step, so no order can be matched while the others are being canceled.
+const EventBulkCancelOrders = "EVENT/EVENT_BULK_CANCEL_ORDERS"
+
+type BulkCancelOrdersEvent struct {
+	common.Event
+	IDs []string `json:"ids"`
+}
+
+// EventChangeMarketState changes the trading state of a market in the engine, the market in the database is changed
+// before the event is sent.
+const EventChangeMarketState = "EVENT/EVENT_CHANGE_MARKET_STATE"
+
+type ChangeMarketStateEvent struct {
+	common.Event
+	TradingState string `json:"tradingState"`
+}
+
+// EventAmendOrder lowers the amount left open of an order, the order keeps its place in the book.
+const EventAmendOrder = "EVENT/EVENT_AMEND_ORDER"
+
+type AmendOrderEvent struct {
+	common.Event
+	ID              string `json:"id"`
+	AvailableAmount string `json:"availableAmount"`
+}
+
+// EventReplaceOrder cancels an order and places a new one instead in a single event,
+// the new order is rejected if the replaced one is not open any more.
+const EventReplaceOrder = "EVENT/EVENT_REPLACE_ORDER"
+
+type ReplaceOrderEvent struct {
+	common.Event
+	ReplacedOrderID string `json:"replacedOrderID"`
+	Order           string `json:"order"`
+}
+
+// EventMarketConfig journals the config of a market. The engine journals it when it loads a market, and the admin api
+// sends it when the config of a published market is changed, so a replay runs every event with the config of its time.
+// The trading state in it is left alone by the engine, it only changes with EventChangeMarketState.
+const EventMarketConfig = "EVENT/EVENT_MARKET_CONFIG"
+
+type MarketConfigEvent struct {
+	common.Event
+	Market *Market `json:"market"`
+}
+
+// ConfirmTransactionEvent is the result of a settlement transaction, the watcher sends it with
+// common.EventConfirmTransaction. A failed settlement comes with why it failed and the orders at fault,
+// the watcher finds them out from the state of the chain at the block of the transaction.
+type ConfirmTransactionEvent struct {
+	common.ConfirmTransactionEvent
+	FailureReason string   `json:"failureReason,omitempty"`
+	FaultyOrders  []string `json:"faultyOrders,omitempty"`
+}
+
+type IEngineEventDao interface {
+	InsertEvent(event *EngineEvent) error
+	FindMarketEventsAfter(marketID string, eventID int64) []*EngineEvent
+	FindEventsAfter(eventID int64, limit int) []*EngineEvent
+	FindMarketEventsPage(marketID string, eventID int64, limit int) []*EngineEvent
+	SaveMarketHandledEvent(marketID string, eventID int64) error
+	FindMarketHandledEvent(marketID string) (int64, bool)
+}
+
+// EngineEvent is an entry of the engine journal, every event the engine pops from the queue is appended
+// to it before dispatch. ID is the sequence of the journal.
+// Markets replay their events after the last snapshot from it, and cli/replay replays the whole journal.
+type EngineEvent struct {
+	ID        int64     `json:"id"        db:"id" primaryKey:"true" autoIncrement:"true" gorm:"primary_key"`
+	MarketID  string    `json:"marketID"  db:"market_id"`
+	Type      string    `json:"type"      db:"type"`
+	Payload   string    `json:"payload"   db:"payload"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+func (EngineEvent) TableName() string {
+	return "engine_events"
+}
+
+// MarketHandledEvent is the last journal event a market handled. The events of the market after it were journaled
+// but not handled before the engine stopped, the market handles them when it is loaded again.
+type MarketHandledEvent struct {
+	MarketID  string    `json:"marketID"  db:"market_id" gorm:"primary_key"`
+	EventID   int64     `json:"eventID"   db:"event_id"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+func (MarketHandledEvent) TableName() string {
+	return "market_handled_events"
+}
+
+var EngineEventDao IEngineEventDao
+var EngineEventDaoPG IEngineEventDao
+
+func init() {
+	EngineEventDao = &engineEventDaoPG{}
+	EngineEventDaoPG = EngineEventDao
+}
+
+type engineEventDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
+}
+
+func (d engineEventDaoPG) InsertEvent(event *EngineEvent) error {
+	return conn(d.tx).Create(event).Error
+}
+
+func (d engineEventDaoPG) FindMarketEventsAfter(marketID string, eventID int64) []*EngineEvent {
+	var events []*EngineEvent
+	conn(d.tx).Where("market_id = ? and id > ?", marketID, eventID).Order("id asc").Find(&events)
+	return events
+}
+
+func (d engineEventDaoPG) FindEventsAfter(eventID int64, limit int) []*EngineEvent {
+	var events []*EngineEvent
+	conn(d.tx).Where("id > ?", eventID).Order("id asc").Limit(limit).Find(&events)
+	return events
+}
+
+// FindMarketEventsPage returns up to limit events of the market after the event, oldest first.
+func (d engineEventDaoPG) FindMarketEventsPage(marketID string, eventID int64, limit int) []*EngineEvent {
+	var events []*EngineEvent
+	conn(d.tx).Where("market_id = ? and id > ?", marketID, eventID).Order("id asc").Limit(limit).Find(&events)
+	return events
+}
+
+func (d engineEventDaoPG) SaveMarketHandledEvent(marketID string, eventID int64) error {
+	return conn(d.tx).Exec(
+		"insert into market_handled_events (market_id, event_id, updated_at) values (?, ?, ?) on conflict (market_id) do update set event_id = excluded.event_id, updated_at = excluded.updated_at",
+		marketID, eventID, time.Now().UTC(),
+	).Error
+}
+
+// FindMarketHandledEvent returns the id of the last event the market handled, it returns false if the market
+// never saved one.
+func (d engineEventDaoPG) FindMarketHandledEvent(marketID string) (int64, bool) {
+	var handled []*MarketHandledEvent
+	conn(d.tx).Where("market_id = ?", marketID).Limit(1).Find(&handled)
+	if len(handled) == 0 {
+		return 0, false
+	}
+
+	return handled[0].EventID, true
+}
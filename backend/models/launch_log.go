@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"github.com/jinzhu/gorm"
 	"github.com/shopspring/decimal"
 	"time"
 )
@@ -50,19 +51,21 @@ func init() {
 }
 
 type launchLogDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
 }
 
-func (launchLogDaoPG) FindLaunchLogByID(id int) *LaunchLog {
+func (d launchLogDaoPG) FindLaunchLogByID(id int) *LaunchLog {
 	var launchLog LaunchLog
 
-	DB.First(&launchLog, id)
+	conn(d.tx).First(&launchLog, id)
 	return &launchLog
 }
 
-func (launchLogDaoPG) FindByHash(hash string) *LaunchLog {
+func (d launchLogDaoPG) FindByHash(hash string) *LaunchLog {
 	var launchLog LaunchLog
 
-	DB.Where("transaction_hash = ?", hash).Find(&launchLog)
+	conn(d.tx).Where("transaction_hash = ?", hash).Find(&launchLog)
 	if !launchLog.Hash.Valid {
 		return nil
 	}
@@ -70,10 +73,10 @@ func (launchLogDaoPG) FindByHash(hash string) *LaunchLog {
 	return &launchLog
 }
 
-func (launchLogDaoPG) FindPendingLogWithMaxNonce() int64 {
+func (d launchLogDaoPG) FindPendingLogWithMaxNonce() int64 {
 	var nonce sql.NullInt64
 
-	err := DB.Raw(`select max(nonce) from launch_logs`).Row().Scan(&nonce)
+	err := conn(d.tx).Raw(`select max(nonce) from launch_logs`).Row().Scan(&nonce)
 	if err != nil {
 		panic(err)
 	}
@@ -84,20 +87,20 @@ func (launchLogDaoPG) FindPendingLogWithMaxNonce() int64 {
 	}
 }
 
-func (launchLogDaoPG) FindAllCreated() []*LaunchLog {
+func (d launchLogDaoPG) FindAllCreated() []*LaunchLog {
 	var launchLogs []*LaunchLog
-	DB.Where("status = 'created'").Order("created_at asc").Find(&launchLogs)
+	conn(d.tx).Where("status = 'created'").Order("created_at asc").Find(&launchLogs)
 	return launchLogs
 }
 
-func (launchLogDaoPG) UpdateLaunchLog(launchLog *LaunchLog) error {
-	return DB.Save(launchLog).Error
+func (d launchLogDaoPG) UpdateLaunchLog(launchLog *LaunchLog) error {
+	return conn(d.tx).Save(launchLog).Error
 }
 
-func (launchLogDaoPG) InsertLaunchLog(launchLog *LaunchLog) error {
-	return DB.Create(launchLog).Error
+func (d launchLogDaoPG) InsertLaunchLog(launchLog *LaunchLog) error {
+	return conn(d.tx).Create(launchLog).Error
 }
 
-func (launchLogDaoPG) UpdateLaunchLogsStatusByItemID(status string, itemID int64) error {
-	return DB.Exec(`update launch_logs set "status" = ? where item_id = ?`, status, itemID).Error
+func (d launchLogDaoPG) UpdateLaunchLogsStatusByItemID(status string, itemID int64) error {
+	return conn(d.tx).Exec(`update launch_logs set "status" = ? where item_id = ?`, status, itemID).Error
 }
@@ -37,6 +37,40 @@ type Market struct {
 	WithdrawRate decimal.Decimal `json:"withdrawRate" db:"withdraw_rate"`
 	AuctionRatioStart decimal.Decimal `json:"auctionRatioStart" db:"auction_ratio_start"`
 	AuctionRatioPerBlock decimal.Decimal `json:"auctionRatioPerBlock" db:"auction_ratio_per_block"`
+	SelfTradePrevention string `json:"selfTradePrevention" db:"self_trade_prevention"`
+	TradingState string `json:"tradingState" db:"trading_state"`
+	PriceBand decimal.Decimal `json:"priceBand" db:"price_band"`
+	PriceBandReference string `json:"priceBandReference" db:"price_band_reference"`
+	MarketOrderMaxSlippage decimal.Decimal `json:"marketOrderMaxSlippage" db:"market_order_max_slippage"`
+}
+
+// The price an order price is measured against when the market has a price band.
+// PriceBand is the fraction the price may be away from it, zero disables the band.
+// MarketOrderMaxSlippage is the fraction a market order may trade away from the best price, zero disables it.
+const (
+	PRICE_BAND_REFERENCE_LAST_TRADE = "last_trade"
+	PRICE_BAND_REFERENCE_ORACLE     = "oracle"
+)
+
+// Trading states of a published market, the book is kept in all of them.
+const (
+	// MARKET_STATE_TRADING markets match orders as usual
+	MARKET_STATE_TRADING = "trading"
+	// MARKET_STATE_HALTED markets take no new orders, and traders can't cancel their orders
+	MARKET_STATE_HALTED = "halted"
+	// MARKET_STATE_CANCEL_ONLY markets take no new orders, but traders can cancel their orders
+	MARKET_STATE_CANCEL_ONLY = "cancel_only"
+	// MARKET_STATE_POST_ONLY markets only take orders which don't match
+	MARKET_STATE_POST_ONLY = "post_only"
+	// MARKET_STATE_CALL_AUCTION markets collect limit orders without matching them,
+	// the book is uncrossed when the market moves to a state which takes orders
+	MARKET_STATE_CALL_AUCTION = "call_auction"
+)
+
+// IsMatching returns true if orders of the market are matched. An empty state is the state of markets
+// created before trading states existed.
+func (m *Market) IsMatching() bool {
+	return m.TradingState == "" || m.TradingState == MARKET_STATE_TRADING
 }
 
 func (Market) TableName() string {
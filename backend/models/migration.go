@@ -0,0 +1,44 @@
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ResetDatabase rolls back all migrations in the dir from the newest one, then applies them again.
+// All data in the database is dropped, only use it on test or scratch databases. The statements of a migration
+// are run one by one, as indexes created concurrently can't be created in the transaction of a multi statement query.
+func ResetDatabase(migrationsDir string) error {
+	downFiles, _ := filepath.Glob(filepath.Join(migrationsDir, "*.down.sql"))
+	upFiles, _ := filepath.Glob(filepath.Join(migrationsDir, "*.up.sql"))
+
+	if len(upFiles) == 0 {
+		return fmt.Errorf("no migrations found in %s", migrationsDir)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(downFiles)))
+	sort.Strings(upFiles)
+
+	for _, file := range append(downFiles, upFiles...) {
+		sql, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range strings.Split(string(sql), ";") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+
+			err = DB.Exec(statement).Error
+			if err != nil {
+				return fmt.Errorf("migration %s failed: %v", file, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -4,19 +4,55 @@ import (
 	"encoding/json"
 	"github.com/HydroProtocol/hydro-sdk-backend/common"
 	"github.com/HydroProtocol/hydro-sdk-backend/utils"
+	"github.com/jinzhu/gorm"
 	"github.com/shopspring/decimal"
 	"time"
 )
 
 type IOrderDao interface {
 	FindMarketPendingOrders(marketID string) []*Order
+	FindMarketUntriggeredOrders(marketID string) []*Order
 	FindByAccount(trader, marketID, status string, offset, limit int) (int64, []*Order)
+	FindOpenOrdersByAccount(trader, marketID, side string) []*Order
+	FindByIDs(ids []string) []*Order
 	FindByID(id string) *Order
 	InsertOrder(order *Order) error
 	UpdateOrder(order *Order) error
 	Count() int
 }
 
+// Stop orders wait in the engine with ORDER_UNTRIGGERED status until the last trade price crosses their stop price,
+// then they become pending limit or market orders.
+const ORDER_UNTRIGGERED = "untriggered"
+
+const ORDER_TYPE_STOP_LIMIT = "stop-limit"
+const ORDER_TYPE_STOP_MARKET = "stop-market"
+
+// Time in force of an order, it decides what happens to the part which is not filled right away.
+const (
+	// TIME_IN_FORCE_GTC orders rest in the book until they are filled or canceled
+	TIME_IN_FORCE_GTC = "GTC"
+	// TIME_IN_FORCE_IOC orders cancel the part which is not filled right away
+	TIME_IN_FORCE_IOC = "IOC"
+	// TIME_IN_FORCE_FOK orders are filled completely right away, or rejected
+	TIME_IN_FORCE_FOK = "FOK"
+	// TIME_IN_FORCE_GTT orders rest in the book until the expiry signed in their data
+	TIME_IN_FORCE_GTT = "GTT"
+)
+
+// Self trade prevention modes, they decide what happens when an order would match a resting order of the same trader.
+// An empty mode lets the orders match.
+const (
+	// SELF_TRADE_PREVENTION_CANCEL_NEWEST cancels the part of the taker order which would match the trader's own order
+	SELF_TRADE_PREVENTION_CANCEL_NEWEST = "cancel_newest"
+	// SELF_TRADE_PREVENTION_CANCEL_OLDEST cancels the resting order and lets the taker order go on matching
+	SELF_TRADE_PREVENTION_CANCEL_OLDEST = "cancel_oldest"
+	// SELF_TRADE_PREVENTION_CANCEL_BOTH cancels the resting order and the rest of the taker order
+	SELF_TRADE_PREVENTION_CANCEL_BOTH = "cancel_both"
+	// SELF_TRADE_PREVENTION_DECREMENT_AND_CANCEL reduces both orders by the amount they would match
+	SELF_TRADE_PREVENTION_DECREMENT_AND_CANCEL = "decrement_and_cancel"
+)
+
 var OrderDao IOrderDao
 var OrderDaoPG IOrderDao
 
@@ -26,26 +62,30 @@ func init() {
 }
 
 type Order struct {
-	ID              string          `json:"id" db:"id" primaryKey:"true" gorm:"primary_key"`
-	TraderAddress   string          `json:"traderAddress" db:"trader_address"`
-	MarketID        string          `json:"marketID" db:"market_id"`
-	Side            string          `json:"side" db:"side"`
-	Price           decimal.Decimal `json:"price" db:"price"`
-	Amount          decimal.Decimal `json:"amount" db:"amount"`
-	Status          string          `json:"status" db:"status"`
-	Type            string          `json:"type" db:"type"`
-	Version         string          `json:"version" db:"version"`
-	AvailableAmount decimal.Decimal `json:"availableAmount" db:"available_amount"`
-	ConfirmedAmount decimal.Decimal `json:"confirmedAmount" db:"confirmed_amount"`
-	CanceledAmount  decimal.Decimal `json:"canceledAmount" db:"canceled_amount"`
-	PendingAmount   decimal.Decimal `json:"pendingAmount" db:"pending_amount"`
-	MakerFeeRate    decimal.Decimal `json:"makerFeeRate" db:"maker_fee_rate"`
-	TakerFeeRate    decimal.Decimal `json:"takerFeeRate" db:"taker_fee_rate"`
-	MakerRebateRate decimal.Decimal `json:"makerRebateRate" db:"maker_rebate_rate"`
-	GasFeeAmount    decimal.Decimal `json:"gasFeeAmount" db:"gas_fee_amount"`
-	JSON            string          `json:"json" db:"json"`
-	CreatedAt       time.Time       `json:"createdAt" db:"created_at"`
-	UpdatedAt       time.Time       `json:"updatedAt" db:"updated_at"`
+	ID                  string          `json:"id" db:"id" primaryKey:"true" gorm:"primary_key"`
+	TraderAddress       string          `json:"traderAddress" db:"trader_address"`
+	MarketID            string          `json:"marketID" db:"market_id"`
+	Side                string          `json:"side" db:"side"`
+	Price               decimal.Decimal `json:"price" db:"price"`
+	Amount              decimal.Decimal `json:"amount" db:"amount"`
+	Status              string          `json:"status" db:"status"`
+	Type                string          `json:"type" db:"type"`
+	Version             string          `json:"version" db:"version"`
+	AvailableAmount     decimal.Decimal `json:"availableAmount" db:"available_amount"`
+	ConfirmedAmount     decimal.Decimal `json:"confirmedAmount" db:"confirmed_amount"`
+	CanceledAmount      decimal.Decimal `json:"canceledAmount" db:"canceled_amount"`
+	PendingAmount       decimal.Decimal `json:"pendingAmount" db:"pending_amount"`
+	MakerFeeRate        decimal.Decimal `json:"makerFeeRate" db:"maker_fee_rate"`
+	TakerFeeRate        decimal.Decimal `json:"takerFeeRate" db:"taker_fee_rate"`
+	MakerRebateRate     decimal.Decimal `json:"makerRebateRate" db:"maker_rebate_rate"`
+	GasFeeAmount        decimal.Decimal `json:"gasFeeAmount" db:"gas_fee_amount"`
+	StopPrice           decimal.Decimal `json:"stopPrice" db:"stop_price"`
+	TimeInForce         string          `json:"timeInForce" db:"time_in_force"`
+	SelfTradePrevention string          `json:"selfTradePrevention" db:"self_trade_prevention"`
+	DisplayAmount       decimal.Decimal `json:"displayAmount" db:"display_amount"`
+	JSON                string          `json:"json" db:"json"`
+	CreatedAt           time.Time       `json:"createdAt" db:"created_at"`
+	UpdatedAt           time.Time       `json:"updatedAt" db:"updated_at"`
 }
 
 func (o *Order) AutoSetStatusByAmounts() {
@@ -60,6 +100,10 @@ func (o *Order) AutoSetStatusByAmounts() {
 	}
 }
 
+func (o *Order) IsStopOrder() bool {
+	return o.Type == ORDER_TYPE_STOP_LIMIT || o.Type == ORDER_TYPE_STOP_MARKET
+}
+
 type OrderJSON struct {
 	Trader                  string          `json:"trader"`
 	Relayer                 string          `json:"relayer"`
@@ -85,42 +129,71 @@ func (o Order) GetOrderJson() *OrderJSON {
 }
 
 type orderDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
 }
 
 func (Order) TableName() string {
 	return "orders"
 }
 
-func (orderDaoPG) FindMarketPendingOrders(marketID string) (orders []*Order) {
-	DB.Where("status = 'pending' and market_id = ?", marketID).Order("created_at asc").Find(&orders)
+func (d orderDaoPG) FindMarketPendingOrders(marketID string) (orders []*Order) {
+	conn(d.tx).Where("status = 'pending' and market_id = ?", marketID).Order("created_at asc").Find(&orders)
+	return
+}
+
+func (d orderDaoPG) FindMarketUntriggeredOrders(marketID string) (orders []*Order) {
+	conn(d.tx).Where("status = ? and market_id = ?", ORDER_UNTRIGGERED, marketID).Order("created_at asc").Find(&orders)
+	return
+}
+
+func (d orderDaoPG) FindByAccount(trader, marketID, status string, offset, limit int) (count int64, orders []*Order) {
+	conn(d.tx).Where("trader_address = ? and market_id = ? and status = ?", trader, marketID, status).Order("created_at desc").Limit(limit).Offset(offset).Find(&orders)
+	conn(d.tx).Model(&Order{}).Where("trader_address = ? and market_id = ? and status = ?", trader, marketID, status).Count(&count)
+	return
+}
+
+// FindOpenOrdersByAccount returns the pending and untriggered orders of the trader,
+// marketID and side are ignored if they are empty.
+func (d orderDaoPG) FindOpenOrdersByAccount(trader, marketID, side string) (orders []*Order) {
+	query := conn(d.tx).Where("trader_address = ? and status in (?)", trader, []string{common.ORDER_PENDING, ORDER_UNTRIGGERED})
+
+	if marketID != "" {
+		query = query.Where("market_id = ?", marketID)
+	}
+
+	if side != "" {
+		query = query.Where("side = ?", side)
+	}
+
+	query.Order("created_at asc").Find(&orders)
 	return
 }
 
-func (orderDaoPG) FindByAccount(trader, marketID, status string, offset, limit int) (count int64, orders []*Order) {
-	DB.Where("trader_address = ? and market_id = ? and status = ?", trader, marketID, status).Order("created_at desc").Limit(limit).Offset(offset).Find(&orders)
-	DB.Model(&Order{}).Where("trader_address = ? and market_id = ? and status = ?", trader, marketID, status).Count(&count)
+func (d orderDaoPG) FindByIDs(ids []string) (orders []*Order) {
+	conn(d.tx).Where("id in (?)", ids).Order("created_at asc").Find(&orders)
 	return
 }
 
-func (orderDaoPG) FindByID(id string) *Order {
+func (d orderDaoPG) FindByID(id string) *Order {
 	var order Order
-	DB.Where("id = ?", id).First(&order)
+	conn(d.tx).Where("id = ?", id).First(&order)
 	if order.ID == "" {
 		return nil
 	}
 	return &order
 }
 
-func (orderDaoPG) InsertOrder(order *Order) error {
-	return DB.Create(order).Error
+func (d orderDaoPG) InsertOrder(order *Order) error {
+	return conn(d.tx).Create(order).Error
 }
 
-func (orderDaoPG) UpdateOrder(order *Order) error {
-	return DB.Save(order).Error
+func (d orderDaoPG) UpdateOrder(order *Order) error {
+	return conn(d.tx).Save(order).Error
 }
 
-func (o orderDaoPG) Count() (count int) {
-	err := DB.Model(&Order{}).Count(&count).Error
+func (d orderDaoPG) Count() (count int) {
+	err := conn(d.tx).Model(&Order{}).Count(&count).Error
 	if err != nil {
 		utils.Errorf("count orders error: %v", err)
 	}
@@ -82,6 +82,58 @@ func Test_PG_GetMarketPendingOrders(t *testing.T) {
 	assert.EqualValues(t, 3, len(orders))
 }
 
+func Test_PG_GetMarketUntriggeredOrders(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	order1 := NewOrder(TestUser1, "WETH-DAI", "buy", false)
+	order2 := NewOrder(TestUser1, "WETH-DAI", "sell", false)
+	order2.Type = ORDER_TYPE_STOP_LIMIT
+	order2.Status = ORDER_UNTRIGGERED
+	order2.StopPrice = order2.Price.Sub(decimal.New(1, 0))
+
+	_ = OrderDaoPG.InsertOrder(order1)
+	_ = OrderDaoPG.InsertOrder(order2)
+
+	orders := OrderDaoPG.FindMarketUntriggeredOrders("WETH-DAI")
+	assert.EqualValues(t, 1, len(orders))
+	assert.EqualValues(t, order2.ID, orders[0].ID)
+	assert.EqualValues(t, order2.StopPrice.String(), orders[0].StopPrice.String())
+
+	orders = OrderDaoPG.FindMarketPendingOrders("WETH-DAI")
+	assert.EqualValues(t, 1, len(orders))
+	assert.EqualValues(t, order1.ID, orders[0].ID)
+}
+
+func Test_PG_FindOpenOrdersByAccount(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	order1 := NewOrder(TestUser1, "WETH-DAI", "buy", false)
+	order2 := NewOrder(TestUser1, "WETH-DAI", "sell", false)
+	order2.Type = ORDER_TYPE_STOP_LIMIT
+	order2.Status = ORDER_UNTRIGGERED
+	order3 := NewOrder(TestUser1, "WETH-DAI", "sell", false)
+	order3.Status = common.ORDER_CANCELED
+
+	_ = OrderDaoPG.InsertOrder(order1)
+	_ = OrderDaoPG.InsertOrder(order2)
+	_ = OrderDaoPG.InsertOrder(order3)
+
+	orders := OrderDaoPG.FindOpenOrdersByAccount(TestUser1, "", "")
+	assert.EqualValues(t, 2, len(orders))
+
+	orders = OrderDaoPG.FindOpenOrdersByAccount(TestUser1, "WETH-DAI", "sell")
+	assert.EqualValues(t, 1, len(orders))
+	assert.EqualValues(t, order2.ID, orders[0].ID)
+
+	orders = OrderDaoPG.FindOpenOrdersByAccount(TestUser1, "HOT-DAI", "")
+	assert.EqualValues(t, 0, len(orders))
+
+	orders = OrderDaoPG.FindByIDs([]string{order1.ID, order3.ID})
+	assert.EqualValues(t, 2, len(orders))
+}
+
 func Test_PG_FindNotExistOrder(t *testing.T) {
 	setEnvs()
 	InitTestDBPG()
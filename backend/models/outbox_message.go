@@ -0,0 +1,56 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type IOutboxMessageDao interface {
+	InsertMessage(message *OutboxMessage) error
+	FindMessages(before time.Time, limit int) []*OutboxMessage
+	DeleteMessages(ids []int64) error
+}
+
+// OutboxMessage is a websocket message saved in the same sql transaction as the changes it describes.
+// It is relayed to the websocket queue after the transaction is committed, and deleted then.
+type OutboxMessage struct {
+	ID        int64     `json:"id"        db:"id" primaryKey:"true" autoIncrement:"true" gorm:"primary_key"`
+	Payload   string    `json:"payload"   db:"payload"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
+
+var OutboxMessageDao IOutboxMessageDao
+var OutboxMessageDaoPG IOutboxMessageDao
+
+func init() {
+	OutboxMessageDao = &outboxMessageDaoPG{}
+	OutboxMessageDaoPG = OutboxMessageDao
+}
+
+type outboxMessageDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
+}
+
+func (d outboxMessageDaoPG) InsertMessage(message *OutboxMessage) error {
+	return conn(d.tx).Create(message).Error
+}
+
+// FindMessages returns the oldest messages created before the time, oldest first.
+func (d outboxMessageDaoPG) FindMessages(before time.Time, limit int) []*OutboxMessage {
+	var messages []*OutboxMessage
+	conn(d.tx).Where("created_at < ?", before).Order("id asc").Limit(limit).Find(&messages)
+	return messages
+}
+
+func (d outboxMessageDaoPG) DeleteMessages(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return conn(d.tx).Exec(`delete from outbox_messages where id in (?)`, ids).Error
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// ErrSessionNonceUsed is returned by InsertSession if a session signed in with the nonce already.
+var ErrSessionNonceUsed = errors.New("session nonce is already used")
+
+type ISessionDao interface {
+	InsertSession(session *Session) error
+	FindActiveSession(tokenHash string, now time.Time) *Session
+	RevokeSession(id int64, revokedAt time.Time) error
+	RevokeAddressSessions(address string, revokedAt time.Time) error
+}
+
+// Session is a sign in of a trader with a Sign-In with Ethereum message. The trader authenticates with the token
+// of the session until it expires or is revoked, only the hash of the token is kept.
+// The nonce of the signed message is unique, a message can't be used to sign in twice.
+type Session struct {
+	ID        int64      `json:"id"        db:"id" primaryKey:"true" autoIncrement:"true" gorm:"primary_key"`
+	Address   string     `json:"address"   db:"address"`
+	TokenHash string     `json:"-"         db:"token_hash"`
+	Nonce     string     `json:"nonce"     db:"nonce"`
+	Domain    string     `json:"domain"    db:"domain"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	RevokedAt *time.Time `json:"revokedAt" db:"revoked_at"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+var SessionDao ISessionDao
+var SessionDaoPG ISessionDao
+
+func init() {
+	SessionDao = &sessionDaoPG{}
+	SessionDaoPG = SessionDao
+}
+
+type sessionDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
+}
+
+func (d sessionDaoPG) InsertSession(session *Session) error {
+	err := conn(d.tx).Create(session).Error
+	if isUniqueViolation(err) {
+		return ErrSessionNonceUsed
+	}
+
+	return err
+}
+
+// FindActiveSession returns the session with the token hash if it is neither revoked nor expired at now.
+func (d sessionDaoPG) FindActiveSession(tokenHash string, now time.Time) *Session {
+	var sessions []*Session
+	conn(d.tx).Where("token_hash = ? and revoked_at is null and expires_at > ?", tokenHash, now).Find(&sessions)
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	return sessions[0]
+}
+
+func (d sessionDaoPG) RevokeSession(id int64, revokedAt time.Time) error {
+	return conn(d.tx).Exec(`update sessions set revoked_at = ? where id = ? and revoked_at is null`, revokedAt, id).Error
+}
+
+// RevokeAddressSessions revokes all sessions of the address which are not revoked yet.
+func (d sessionDaoPG) RevokeAddressSessions(address string, revokedAt time.Time) error {
+	return conn(d.tx).Exec(`update sessions set revoked_at = ? where address = ? and revoked_at is null`, revokedAt, address).Error
+}
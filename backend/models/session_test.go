@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionDao_PG_FindActiveSession(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	now := time.Now()
+
+	newSession := func(address, tokenHash, nonce string, expiresAt time.Time) *Session {
+		session := &Session{
+			Address:   address,
+			TokenHash: tokenHash,
+			Nonce:     nonce,
+			Domain:    "localhost:3000",
+			ExpiresAt: expiresAt,
+			CreatedAt: now,
+		}
+
+		assert.Nil(t, SessionDaoPG.InsertSession(session))
+		return session
+	}
+
+	active := newSession(TestUser1, "hash1", "nonce1", now.Add(time.Hour))
+	newSession(TestUser1, "hash2", "nonce2", now.Add(-time.Minute))
+	newSession(TestUser2, "hash3", "nonce3", now.Add(time.Hour))
+
+	// a nonce signs in once
+	assert.EqualValues(t, ErrSessionNonceUsed, SessionDaoPG.InsertSession(&Session{Address: TestUser1, TokenHash: "hash4", Nonce: "nonce1", ExpiresAt: now.Add(time.Hour)}))
+
+	session := SessionDaoPG.FindActiveSession("hash1", now)
+	assert.EqualValues(t, active.ID, session.ID)
+	assert.EqualValues(t, TestUser1, session.Address)
+
+	assert.Nil(t, SessionDaoPG.FindActiveSession("hash2", now))
+	assert.Nil(t, SessionDaoPG.FindActiveSession("unknown", now))
+
+	assert.Nil(t, SessionDaoPG.RevokeSession(active.ID, now))
+	assert.Nil(t, SessionDaoPG.FindActiveSession("hash1", now))
+	assert.NotNil(t, SessionDaoPG.FindActiveSession("hash3", now))
+
+	assert.Nil(t, SessionDaoPG.RevokeAddressSessions(TestUser2, now))
+	assert.Nil(t, SessionDaoPG.FindActiveSession("hash3", now))
+}
@@ -5,7 +5,6 @@ import (
 	uuid2 "github.com/satori/go.uuid"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/mock"
-	"io/ioutil"
 	"math/rand"
 	"os"
 	"time"
@@ -54,11 +53,6 @@ func (m *MTradeDao) FindTradesByMarket(pair string, startTime time.Time, endTime
 	return args.Get(0).([]*Trade)
 }
 
-func (m *MTradeDao) FindAllTrades(marketID string) (int64, []*Trade) {
-	args := m.Called(marketID)
-	return args.Get(0).(int64), args.Get(1).([]*Trade)
-}
-
 func (m *MTradeDao) FindTradesByHash(hash string) []*Trade {
 	args := m.Called(hash)
 	return args.Get(0).([]*Trade)
@@ -69,9 +63,19 @@ func (m *MTradeDao) FindTradeByID(id int64) *Trade {
 	return args.Get(0).(*Trade)
 }
 
-func (m *MTradeDao) FindAccountMarketTrades(account, marketID, status string, limit, offset int) (int64, []*Trade) {
-	args := m.Called(account, status, limit, offset)
-	return args.Get(0).(int64), args.Get(1).([]*Trade)
+func (m *MTradeDao) FindLastTrade(marketID string) *Trade {
+	args := m.Called(marketID)
+	return args.Get(0).(*Trade)
+}
+
+func (m *MTradeDao) FindMarketTradesPage(query *TradeQuery) []*Trade {
+	args := m.Called(query)
+	return args.Get(0).([]*Trade)
+}
+
+func (m *MTradeDao) FindAccountTradesPage(account string, query *TradeQuery) []*Trade {
+	args := m.Called(account, query)
+	return args.Get(0).([]*Trade)
 }
 
 func (m *MTradeDao) InsertTrade(trade *Trade) error {
@@ -137,12 +141,20 @@ func (m *MCache) Set(key string, value string, expire time.Duration) error {
 
 func (m *MCache) Get(key string) (string, error) {
 	args := m.Called(key)
-	if args.Get(0) == nil {
+	if args.Get(1) == nil {
 		return args.Get(0).(string), nil
 	}
 	return args.Get(0).(string), args.Get(1).(error)
 }
 
+func (m *MCache) Delete(key string) error {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(error)
+}
+
 func (m *MCache) Push(key []byte) error {
 	args := m.Called(key)
 	if args.Get(0) == nil {
@@ -289,23 +301,14 @@ func InitTestDBPG() {
 		if err != nil {
 			panic(err)
 		}
+
+		DB = nil
 	}
 
 	Connect(os.Getenv("HSK_DATABASE_URL"))
 	DB.LogMode(true)
-	createSql, err := ioutil.ReadFile("../db/migrations/0001-init.up.sql")
-	cleanSql, err := ioutil.ReadFile("../db/migrations/0001-init.down.sql")
-
-	if err != nil {
-		panic(err)
-	}
-
-	err = DB.Exec(string(cleanSql)).Error
-	if err != nil {
-		panic(err)
-	}
 
-	err = DB.Exec(string(createSql)).Error
+	err := ResetDatabase("../db/migrations")
 	if err != nil {
 		panic(err)
 	}
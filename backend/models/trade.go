@@ -1,17 +1,20 @@
 package models
 
 import (
+	"fmt"
 	"github.com/HydroProtocol/hydro-sdk-backend/common"
+	"github.com/jinzhu/gorm"
 	"github.com/shopspring/decimal"
 	"time"
 )
 
 type ITradeDao interface {
 	FindTradesByMarket(marketID string, startTime time.Time, endTime time.Time) []*Trade
-	FindAllTrades(marketID string) (int64, []*Trade)
 	FindTradesByHash(hash string) []*Trade
 	FindTradeByID(id int64) *Trade
-	FindAccountMarketTrades(account, marketID, status string, limit, offset int) (int64, []*Trade)
+	FindLastTrade(marketID string) *Trade
+	FindMarketTradesPage(query *TradeQuery) []*Trade
+	FindAccountTradesPage(account string, query *TradeQuery) []*Trade
 
 	InsertTrade(trade *Trade) error
 	UpdateTrade(trade *Trade) error
@@ -42,6 +45,83 @@ func (Trade) TableName() string {
 	return "trades"
 }
 
+// TradeCursor is the position of a trade in the history, which is ordered by executed_at and id.
+type TradeCursor struct {
+	ExecutedAt time.Time
+	ID         int64
+}
+
+// TradeQuery selects a page of the trade history of a market, newest trades first.
+// With After set and Before not, the page holds the trades right after the cursor instead of the newest ones.
+type TradeQuery struct {
+	MarketID string
+	// Status is empty for trades of any status
+	Status string
+	// From and To bound executed_at, From is inclusive and To is exclusive. A zero time is no bound.
+	From time.Time
+	To   time.Time
+	// Before and After are exclusive
+	Before *TradeCursor
+	After  *TradeCursor
+	Limit  int
+}
+
+// ascending returns true if the page is taken from the oldest matching trades.
+func (q *TradeQuery) ascending() bool {
+	return q.After != nil && q.Before == nil
+}
+
+func (q *TradeQuery) order() string {
+	if q.ascending() {
+		return "executed_at asc, id asc"
+	}
+
+	return "executed_at desc, id desc"
+}
+
+func (q *TradeQuery) conditions() (string, []interface{}) {
+	where := "market_id = ?"
+	args := []interface{}{q.MarketID}
+
+	if q.Status != "" {
+		where += " and status = ?"
+		args = append(args, q.Status)
+	}
+
+	if !q.From.IsZero() {
+		where += " and executed_at >= ?"
+		args = append(args, q.From)
+	}
+
+	if !q.To.IsZero() {
+		where += " and executed_at < ?"
+		args = append(args, q.To)
+	}
+
+	if q.Before != nil {
+		where += " and (executed_at, id) < (?, ?)"
+		args = append(args, q.Before.ExecutedAt, q.Before.ID)
+	}
+
+	if q.After != nil {
+		where += " and (executed_at, id) > (?, ?)"
+		args = append(args, q.After.ExecutedAt, q.After.ID)
+	}
+
+	return where, args
+}
+
+// newestFirst puts a page fetched in the order of the query newest trades first.
+func (q *TradeQuery) newestFirst(trades []*Trade) []*Trade {
+	if q.ascending() {
+		for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+			trades[i], trades[j] = trades[j], trades[i]
+		}
+	}
+
+	return trades
+}
+
 var TradeDao ITradeDao
 var TradeDaoPG ITradeDao
 
@@ -51,33 +131,27 @@ func init() {
 }
 
 type tradeDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
 }
 
-func (tradeDaoPG) FindTradesByMarket(marketID string, startTime time.Time, endTime time.Time) []*Trade {
+func (d tradeDaoPG) FindTradesByMarket(marketID string, startTime time.Time, endTime time.Time) []*Trade {
 	var trades []*Trade
 
-	DB.Where("market_id = ? and status = ? and executed_at between ? and ? ", marketID, common.STATUS_SUCCESSFUL, startTime, endTime).Order("executed_at desc").Find(&trades)
+	conn(d.tx).Where("market_id = ? and status = ? and executed_at between ? and ? ", marketID, common.STATUS_SUCCESSFUL, startTime, endTime).Order("executed_at desc").Find(&trades)
 	return trades
 }
 
-func (tradeDaoPG) FindAllTrades(marketID string) (int64, []*Trade) {
-	var trades []*Trade
-	var count int64
-
-	DB.Where("market_id = ? and status = ?", marketID, common.STATUS_SUCCESSFUL).Order("created_at desc").Find(&trades).Count(&count)
-	return count, trades
-}
-
-func (tradeDaoPG) FindTradesByHash(hash string) []*Trade {
+func (d tradeDaoPG) FindTradesByHash(hash string) []*Trade {
 	var trades []*Trade
-	DB.Where("transaction_hash = ?", hash).Order("created_at desc").Find(&trades)
+	conn(d.tx).Where("transaction_hash = ?", hash).Order("created_at desc").Find(&trades)
 	return trades
 }
 
-func (tradeDaoPG) FindTradeByID(id int64) *Trade {
+func (d tradeDaoPG) FindTradeByID(id int64) *Trade {
 	var trade Trade
 
-	DB.Where("id = ?", id).Find(&trade)
+	conn(d.tx).Where("id = ?", id).Find(&trade)
 	if trade.Status == "" {
 		return nil
 	}
@@ -85,31 +159,69 @@ func (tradeDaoPG) FindTradeByID(id int64) *Trade {
 	return &trade
 }
 
-func (tradeDaoPG) FindAccountMarketTrades(account, marketID, status string, limit, offset int) (int64, []*Trade) {
+// FindLastTrade returns the successful trade of the market which was executed last, or nil if there is none.
+func (d tradeDaoPG) FindLastTrade(marketID string) *Trade {
 	var trades []*Trade
-	var count int64
 
-	DB.Where("market_id = ? and (taker = ? or maker = ?)", marketID, account, account).Order("created_at desc").Find(&trades).Count(&count)
-	return count, trades
+	conn(d.tx).Where("market_id = ? and status = ?", marketID, common.STATUS_SUCCESSFUL).Order("executed_at desc, id desc").Limit(1).Find(&trades)
+	if len(trades) == 0 {
+		return nil
+	}
+
+	return trades[0]
+}
+
+// FindMarketTradesPage returns a page of the trades of the market, it pages on (executed_at, id) so that
+// deep pages are as fast as the first one.
+func (d tradeDaoPG) FindMarketTradesPage(query *TradeQuery) []*Trade {
+	var trades []*Trade
+
+	where, args := query.conditions()
+	conn(d.tx).Where(where, args...).Order(query.order()).Limit(query.Limit).Find(&trades)
+	return query.newestFirst(trades)
+}
+
+// FindAccountTradesPage returns a page of the trades of the market the account took part in.
+// Trades as taker and as maker are paged apart, each on its own index, and merged.
+func (d tradeDaoPG) FindAccountTradesPage(account string, query *TradeQuery) []*Trade {
+	var trades []*Trade
+
+	where, args := query.conditions()
+	order := query.order()
+
+	sql := fmt.Sprintf(
+		"(select * from trades where taker = ? and %s order by %s limit ?) union (select * from trades where maker = ? and %s order by %s limit ?) order by %s limit ?",
+		where, order, where, order, order,
+	)
+
+	var values []interface{}
+	values = append(values, account)
+	values = append(values, args...)
+	values = append(values, query.Limit, account)
+	values = append(values, args...)
+	values = append(values, query.Limit, query.Limit)
+
+	conn(d.tx).Raw(sql, values...).Scan(&trades)
+	return query.newestFirst(trades)
 }
 
-func (tradeDaoPG) InsertTrade(trade *Trade) error {
-	return DB.Create(trade).Error
+func (d tradeDaoPG) InsertTrade(trade *Trade) error {
+	return conn(d.tx).Create(trade).Error
 }
 
-func (tradeDaoPG) UpdateTrade(trade *Trade) error {
-	return DB.Save(trade).Error
+func (d tradeDaoPG) UpdateTrade(trade *Trade) error {
+	return conn(d.tx).Save(trade).Error
 }
 
-func (tradeDaoPG) Count() int {
+func (d tradeDaoPG) Count() int {
 	var count int
-	DB.Model(&Trade{}).Count(&count)
+	conn(d.tx).Model(&Trade{}).Count(&count)
 	return count
 }
 
-func (tradeDaoPG) FindTradeByTransactionID(transactionID int64) []*Trade {
+func (d tradeDaoPG) FindTradeByTransactionID(transactionID int64) []*Trade {
 	var trades []*Trade
 
-	DB.Where("transaction_id = ? ", transactionID).Order("created_at asc").Find(&trades)
+	conn(d.tx).Where("transaction_id = ? ", transactionID).Order("created_at asc").Find(&trades)
 	return trades
 }
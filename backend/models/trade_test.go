@@ -8,42 +8,94 @@ import (
 	"time"
 )
 
-func Test_PG_FindAccountMarketTrades(t *testing.T) {
+func TestTradeDao_PG_FindMarketTradesPage(t *testing.T) {
 	setEnvs()
 	InitTestDBPG()
 
-	_, trades := TradeDaoPG.FindAllTrades("WETH-DAI")
-	assert.EqualValues(t, 0, len(trades))
-
-	trade := NewTrade("WETH-DAI", true)
-	_ = TradeDaoPG.InsertTrade(trade)
-
-	var count int64
-	count, trades = TradeDaoPG.FindAccountMarketTrades(trade.Maker, "WETH-DAI", common.STATUS_SUCCESSFUL, 1, 0)
-	assert.EqualValues(t, 1, len(trades))
-	assert.EqualValues(t, 1, count)
-
-	count, trades = TradeDaoPG.FindAccountMarketTrades(trade.Maker, "WETH-DAI", common.STATUS_SUCCESSFUL, 2, 0)
-	assert.EqualValues(t, 1, len(trades))
-	assert.EqualValues(t, 1, count)
+	now := time.Now().Truncate(time.Second)
+	var inserted []*Trade
+	for i := 0; i < 5; i++ {
+		trade := NewTradeWithTime("WETH-DAI", true, now.Add(time.Duration(i)*time.Minute))
+		_ = TradeDaoPG.InsertTrade(trade)
+		inserted = append(inserted, trade)
+	}
+	_ = TradeDaoPG.InsertTrade(NewTradeWithTime("WETH-DAI", false, now))
+	_ = TradeDaoPG.InsertTrade(NewTradeWithTime("HOT-DAI", true, now))
+
+	query := &TradeQuery{MarketID: "WETH-DAI", Status: common.STATUS_SUCCESSFUL, Limit: 2}
+	trades := TradeDaoPG.FindMarketTradesPage(query)
+	assert.EqualValues(t, 2, len(trades))
+	assert.EqualValues(t, inserted[4].ID, trades[0].ID)
+	assert.EqualValues(t, inserted[3].ID, trades[1].ID)
+
+	query.Before = &TradeCursor{ExecutedAt: trades[1].ExecutedAt, ID: trades[1].ID}
+	trades = TradeDaoPG.FindMarketTradesPage(query)
+	assert.EqualValues(t, 2, len(trades))
+	assert.EqualValues(t, inserted[2].ID, trades[0].ID)
+	assert.EqualValues(t, inserted[1].ID, trades[1].ID)
+
+	// after only pages from the cursor up, the page is still newest first
+	query.Before = nil
+	query.After = &TradeCursor{ExecutedAt: inserted[0].ExecutedAt, ID: inserted[0].ID}
+	trades = TradeDaoPG.FindMarketTradesPage(query)
+	assert.EqualValues(t, 2, len(trades))
+	assert.EqualValues(t, inserted[2].ID, trades[0].ID)
+	assert.EqualValues(t, inserted[1].ID, trades[1].ID)
+
+	query.After = nil
+	query.From = now.Add(time.Minute)
+	query.To = now.Add(3 * time.Minute)
+	query.Limit = 10
+	trades = TradeDaoPG.FindMarketTradesPage(query)
+	assert.EqualValues(t, 2, len(trades))
+	assert.EqualValues(t, inserted[2].ID, trades[0].ID)
+	assert.EqualValues(t, inserted[1].ID, trades[1].ID)
+
+	trades = TradeDaoPG.FindMarketTradesPage(&TradeQuery{MarketID: "WETH-DAI", Limit: 10})
+	assert.EqualValues(t, 6, len(trades))
 }
 
-func TestTradeDao_PG_FindAllTrades(t *testing.T) {
+func TestTradeDao_PG_FindAccountTradesPage(t *testing.T) {
 	setEnvs()
 	InitTestDBPG()
 
-	_, trades := TradeDaoPG.FindAllTrades("WETH-DAI")
-	assert.EqualValues(t, 0, len(trades))
+	now := time.Now().Truncate(time.Second)
+	account := "0x5409ed021d9299bf6814279a6a1411a7e866a631"
 
-	trade1 := NewTrade("WETH-DAI", true)
-	trade2 := NewTrade("WETH-DAI", true)
-	trade3 := NewTrade("WETH-DAI", true)
-	_ = TradeDaoPG.InsertTrade(trade1)
-	_ = TradeDaoPG.InsertTrade(trade2)
-	_ = TradeDaoPG.InsertTrade(trade3)
+	asTaker := NewTradeWithTime("WETH-DAI", true, now)
+	asTaker.Taker = account
+	asMaker := NewTradeWithTime("WETH-DAI", true, now.Add(time.Minute))
+	asMaker.Maker = account
+	asBoth := NewTradeWithTime("WETH-DAI", true, now.Add(2*time.Minute))
+	asBoth.Taker = account
+	asBoth.Maker = account
 
-	_, trades = TradeDaoPG.FindAllTrades("WETH-DAI")
+	_ = TradeDaoPG.InsertTrade(asTaker)
+	_ = TradeDaoPG.InsertTrade(asMaker)
+	_ = TradeDaoPG.InsertTrade(asBoth)
+	_ = TradeDaoPG.InsertTrade(NewTradeWithTime("WETH-DAI", true, now))
+
+	trades := TradeDaoPG.FindAccountTradesPage(account, &TradeQuery{MarketID: "WETH-DAI", Limit: 10})
 	assert.EqualValues(t, 3, len(trades))
+	assert.EqualValues(t, asBoth.ID, trades[0].ID)
+	assert.EqualValues(t, asMaker.ID, trades[1].ID)
+	assert.EqualValues(t, asTaker.ID, trades[2].ID)
+
+	trades = TradeDaoPG.FindAccountTradesPage(account, &TradeQuery{
+		MarketID: "WETH-DAI",
+		Before:   &TradeCursor{ExecutedAt: trades[0].ExecutedAt, ID: trades[0].ID},
+		Limit:    1,
+	})
+	assert.EqualValues(t, 1, len(trades))
+	assert.EqualValues(t, asMaker.ID, trades[0].ID)
+
+	trades = TradeDaoPG.FindAccountTradesPage(account, &TradeQuery{
+		MarketID: "WETH-DAI",
+		After:    &TradeCursor{ExecutedAt: asTaker.ExecutedAt, ID: asTaker.ID},
+		Limit:    1,
+	})
+	assert.EqualValues(t, 1, len(trades))
+	assert.EqualValues(t, asMaker.ID, trades[0].ID)
 }
 
 func TestTradeDao_PG_InsertAndFindOneAndUpdateTrade(t *testing.T) {
@@ -89,7 +141,7 @@ func TestTradeDao_PG_FindTradesByMarket(t *testing.T) {
 	setEnvs()
 	InitTestDBPG()
 
-	_, trades := TradeDaoPG.FindAllTrades("WETH-DAI")
+	trades := TradeDaoPG.FindMarketTradesPage(&TradeQuery{MarketID: "WETH-DAI", Limit: 10})
 	assert.EqualValues(t, 0, len(trades))
 
 	//"2006-01-02T15:04:05Z07:00"
@@ -107,7 +159,7 @@ func TestTradeDao_PG_FindTradesByMarket(t *testing.T) {
 	_ = TradeDaoPG.InsertTrade(trade2)
 	_ = TradeDaoPG.InsertTrade(trade3)
 
-	_, trades1 := TradeDaoPG.FindAllTrades("WETH-DAI")
+	trades1 := TradeDaoPG.FindMarketTradesPage(&TradeQuery{MarketID: "WETH-DAI", Limit: 10})
 	assert.EqualValues(t, 3, len(trades1))
 
 	trades2 := TradeDaoPG.FindTradesByMarket("WETH-DAI", time4, time5)
@@ -117,6 +169,28 @@ func TestTradeDao_PG_FindTradesByMarket(t *testing.T) {
 	assert.EqualValues(t, 2, len(trades3))
 }
 
+func TestTradeDao_PG_FindLastTrade(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	assert.Nil(t, TradeDaoPG.FindLastTrade("WETH-DAI"))
+
+	time1, _ := time.Parse(time.RFC3339, "2019-02-02T00:00:00Z")
+	time2, _ := time.Parse(time.RFC3339, "2019-02-03T00:00:00Z")
+	time3, _ := time.Parse(time.RFC3339, "2019-02-04T00:00:00Z")
+
+	trade1 := NewTradeWithTime("WETH-DAI", true, time1)
+	trade2 := NewTradeWithTime("WETH-DAI", true, time2)
+	failedTrade := NewTradeWithTime("WETH-DAI", false, time3)
+	_ = TradeDaoPG.InsertTrade(trade2)
+	_ = TradeDaoPG.InsertTrade(trade1)
+	_ = TradeDaoPG.InsertTrade(failedTrade)
+
+	lastTrade := TradeDaoPG.FindLastTrade("WETH-DAI")
+	assert.NotNil(t, lastTrade)
+	assert.EqualValues(t, trade2.ID, lastTrade.ID)
+}
+
 func NewTradeWithTime(marketID string, success bool, time time.Time) *Trade {
 	status := common.STATUS_SUCCESSFUL
 
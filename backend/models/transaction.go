@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"github.com/jinzhu/gorm"
 	"time"
 )
 
@@ -14,14 +15,29 @@ type ITransactionDao interface {
 	FindTransactionByID(id int64) *Transaction
 }
 
+// Reasons of a failed settlement, the watcher finds them out when the transaction fails
+const (
+	// SETTLEMENT_FAILURE_GAS the transaction ran out of gas, none of the orders is at fault
+	SETTLEMENT_FAILURE_GAS = "gas"
+	// SETTLEMENT_FAILURE_BALANCE a trader doesn't hold the tokens the trades need
+	SETTLEMENT_FAILURE_BALANCE = "balance"
+	// SETTLEMENT_FAILURE_ALLOWANCE a trader doesn't allow the proxy to move the tokens the trades need
+	SETTLEMENT_FAILURE_ALLOWANCE = "allowance"
+	// SETTLEMENT_FAILURE_UNKNOWN nothing explains the failure, the taker order is held at fault
+	SETTLEMENT_FAILURE_UNKNOWN = "unknown"
+)
+
 type Transaction struct {
 	ID              int64           `json:"id"              db:"id" primaryKey:"true"  autoIncrement:"true" gorm:"primary_key"`
 	MarketID        string          `json:"marketID"        db:"market_id"`
 	TransactionHash *sql.NullString `json:"transactionHash" db:"transaction_hash"`
 	Status          string          `json:"status"          db:"status"`
-	ExecutedAt      time.Time       `json:"executedAt"      db:"executed_at"`
-	UpdatedAt       time.Time       `json:"updatedAt"       db:"updated_at"`
-	CreatedAt       time.Time       `json:"createdAt"       db:"created_at"`
+	// FailureReason and FaultyOrders, a comma separated list of order IDs, are set when the transaction fails
+	FailureReason string    `json:"failureReason"   db:"failure_reason"`
+	FaultyOrders  string    `json:"faultyOrders"    db:"faulty_orders"`
+	ExecutedAt    time.Time `json:"executedAt"      db:"executed_at"`
+	UpdatedAt     time.Time `json:"updatedAt"       db:"updated_at"`
+	CreatedAt     time.Time `json:"createdAt"       db:"created_at"`
 }
 
 func (Transaction) TableName() string {
@@ -37,11 +53,13 @@ func init() {
 }
 
 type transactionDaoPG struct {
+	// set when the dao is used in a sql transaction
+	tx *gorm.DB
 }
 
-func (transactionDaoPG) FindTransactionByHash(transactionHash string) *Transaction {
+func (d transactionDaoPG) FindTransactionByHash(transactionHash string) *Transaction {
 	var transaction Transaction
-	DB.Where("transaction_hash = ?", transactionHash).First(&transaction)
+	conn(d.tx).Where("transaction_hash = ?", transactionHash).First(&transaction)
 	if !transaction.TransactionHash.Valid {
 		return nil
 	}
@@ -49,28 +67,28 @@ func (transactionDaoPG) FindTransactionByHash(transactionHash string) *Transacti
 	return &transaction
 }
 
-func (transactionDaoPG) InsertTransaction(transaction *Transaction) error {
-	return DB.Create(transaction).Error
+func (d transactionDaoPG) InsertTransaction(transaction *Transaction) error {
+	return conn(d.tx).Create(transaction).Error
 }
 
-func (transactionDaoPG) UpdateTransaction(transaction *Transaction) error {
-	return DB.Save(transaction).Error
+func (d transactionDaoPG) UpdateTransaction(transaction *Transaction) error {
+	return conn(d.tx).Save(transaction).Error
 }
 
-func (transactionDaoPG) UpdateTransactionStatus(status, hash string) error {
-	return DB.Exec(`update transactions set "status"=$1 where transaction_hash = $2`, status, hash).Error
+func (d transactionDaoPG) UpdateTransactionStatus(status, hash string) error {
+	return conn(d.tx).Exec(`update transactions set "status"=$1 where transaction_hash = $2`, status, hash).Error
 }
 
-func (transactionDaoPG) Count() int {
+func (d transactionDaoPG) Count() int {
 	var count int
-	DB.Model(&Transaction{}).Count(&count)
+	conn(d.tx).Model(&Transaction{}).Count(&count)
 	return count
 }
 
-func (transactionDaoPG) FindTransactionByID(id int64) *Transaction {
+func (d transactionDaoPG) FindTransactionByID(id int64) *Transaction {
 	var transaction Transaction
 
-	DB.Where("id = ?", id).Find(&transaction)
+	conn(d.tx).Where("id = ?", id).Find(&transaction)
 	if transaction.Status == "" {
 		return nil
 	}
@@ -0,0 +1,48 @@
+package models
+
+// Tx holds the daos whose writes are committed together.
+type Tx struct {
+	OrderDao         IOrderDao
+	TradeDao         ITradeDao
+	TransactionDao   ITransactionDao
+	LaunchLogDao     ILaunchLogDao
+	BalanceDao       IBalanceDao
+	OutboxMessageDao IOutboxMessageDao
+	CandleDao        ICandleDao
+	EngineEventDao   IEngineEventDao
+}
+
+// RunInTransaction calls fn with daos bound to a single sql transaction.
+// The transaction is committed if fn returns nil, and rolled back if it returns an error or panics.
+func RunInTransaction(fn func(tx *Tx) error) (err error) {
+	db := DB.Begin()
+	if db.Error != nil {
+		return db.Error
+	}
+
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			db.Rollback()
+			panic(rcv)
+		}
+	}()
+
+	tx := &Tx{
+		OrderDao:         &orderDaoPG{tx: db},
+		TradeDao:         &tradeDaoPG{tx: db},
+		TransactionDao:   &transactionDaoPG{tx: db},
+		LaunchLogDao:     &launchLogDaoPG{tx: db},
+		BalanceDao:       &balanceDaoPG{tx: db},
+		OutboxMessageDao: &outboxMessageDaoPG{tx: db},
+		CandleDao:        &candleDaoPG{tx: db},
+		EngineEventDao:   &engineEventDaoPG{tx: db},
+	}
+
+	err = fn(tx)
+	if err != nil {
+		db.Rollback()
+		return err
+	}
+
+	return db.Commit().Error
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRunInTransaction_Commit(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	err := RunInTransaction(func(tx *Tx) error {
+		_ = tx.LaunchLogDao.InsertLaunchLog(newLaunchLog())
+		return tx.OutboxMessageDao.InsertMessage(&OutboxMessage{Payload: "{}", CreatedAt: time.Now().UTC()})
+	})
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(LaunchLogDaoPG.FindAllCreated()))
+	assert.EqualValues(t, 1, len(OutboxMessageDaoPG.FindMessages(time.Now().UTC(), 10)))
+}
+
+func TestRunInTransaction_Rollback(t *testing.T) {
+	setEnvs()
+	InitTestDBPG()
+
+	err := RunInTransaction(func(tx *Tx) error {
+		_ = tx.LaunchLogDao.InsertLaunchLog(newLaunchLog())
+		_ = tx.OutboxMessageDao.InsertMessage(&OutboxMessage{Payload: "{}", CreatedAt: time.Now().UTC()})
+		return errors.New("rollback")
+	})
+
+	assert.EqualError(t, err, "rollback")
+	assert.EqualValues(t, 0, len(LaunchLogDaoPG.FindAllCreated()))
+	assert.EqualValues(t, 0, len(OutboxMessageDaoPG.FindMessages(time.Now().UTC(), 10)))
+}
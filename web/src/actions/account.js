@@ -2,12 +2,12 @@ import { getAllowance, getTokenBalance } from '../lib/wallet';
 import { saveLoginData } from '../lib/session';
 import BigNumber from 'bignumber.js';
 import api from '../lib/api';
+import env from '../lib/env';
 import { getSelectedAccount, getSelectedAccountWallet } from '@gongddex/hydro-sdk-wallet';
 
-// request ddex private auth token
+// sign in with ethereum, the signed message is traded for a session token
 export const loginRequest = () => {
   return async (dispatch, getState) => {
-    const message = 'HYDRO-AUTHENTICATION';
     const state = getState();
     const selectedAccount = getSelectedAccount(state);
     const address = selectedAccount ? selectedAccount.get('address') : null;
@@ -15,17 +15,48 @@ export const loginRequest = () => {
     if (!wallet) {
       return;
     }
+
+    const nonceRes = await api.get('/sessions/nonce');
+    if (nonceRes.data.status !== 0) {
+      return;
+    }
+
+    const message = buildSignInMessage(address, nonceRes.data.data.nonce);
     const signature = await wallet.signPersonalMessage(message);
     if (!signature) {
       return;
     }
 
-    const hydroAuthentication = address + '#' + message + '#' + signature;
-    saveLoginData(address, hydroAuthentication);
-    return dispatch(login(address, hydroAuthentication));
+    const res = await api.post('/sessions', { message, signature });
+    if (res.data.status !== 0) {
+      return;
+    }
+
+    saveLoginData(address, res.data.data.token);
+    return dispatch(login(address));
   };
 };
 
+// Sign-In with Ethereum message (EIP-4361), the session lasts an hour at most
+const buildSignInMessage = (address, nonce) => {
+  const issuedAt = new Date();
+  const expiresAt = new Date(issuedAt.getTime() + 60 * 60 * 1000);
+
+  return [
+    `${window.location.host} wants you to sign in with your Ethereum account:`,
+    address,
+    '',
+    'Sign in to trade on the exchange.',
+    '',
+    `URI: ${window.location.origin}`,
+    'Version: 1',
+    `Chain ID: ${env.NETWORK_ID}`,
+    `Nonce: ${nonce}`,
+    `Issued At: ${issuedAt.toISOString()}`,
+    `Expiration Time: ${expiresAt.toISOString()}`
+  ].join('\n');
+};
+
 export const login = address => {
   return (dispatch, getState) => {
     dispatch(loadAccountLockedBalance());
@@ -15,7 +15,7 @@ const getAxiosInstance = () => {
   if (hydroAuthentication) {
     instance = axios.create({
       headers: {
-        'Hydro-Authentication': hydroAuthentication
+        Authorization: `Bearer ${hydroAuthentication}`
       }
     });
   } else {
@@ -2,7 +2,7 @@
  * Login Data
  * {
  *   address: "0x....",
- *   hydroAuthentication: "xxx.bbb.ccc"
+ *   hydroAuthentication: "{session token}"
  * }
  */
 